@@ -0,0 +1,375 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 口座関連ハンドラー
+
+// 口座一覧取得（残高付き）
+func getAccounts(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	var accounts []Account
+
+	query := db.Where("user_id = ?", userID).Order("is_default DESC, id ASC")
+	if c.Query("active") == "true" {
+		query = query.Where("is_active = ?", true)
+	}
+	if err := query.Find(&accounts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch accounts: " + err.Error()})
+		return
+	}
+
+	balances, err := accountBalances(db, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate balances: " + err.Error()})
+		return
+	}
+	for i := range accounts {
+		accounts[i].Balance = accounts[i].OpeningBalance + balances[accounts[i].ID]
+	}
+
+	c.JSON(http.StatusOK, accounts)
+}
+
+// 口座取得（残高付き）
+func getAccount(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	id := c.Param("id")
+	var account Account
+
+	if err := db.Where("user_id = ?", userID).First(&account, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+		return
+	}
+
+	balances, err := accountBalances(db, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to calculate balances: " + err.Error()})
+		return
+	}
+	account.Balance = account.OpeningBalance + balances[account.ID]
+
+	c.JSON(http.StatusOK, account)
+}
+
+// 口座作成
+func createAccount(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	var req AccountRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+
+	currency, err := currencyOrDefault(req.Currency, userBaseCurrency(userID))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	account := Account{
+		UserID:         userID.(uint),
+		Name:           req.Name,
+		Type:           req.Type,
+		Currency:       currency,
+		OpeningBalance: req.OpeningBalance,
+		IsActive:       true,
+		StatementRef:   strings.TrimSpace(req.StatementRef),
+	}
+	if req.IsActive != nil {
+		account.IsActive = *req.IsActive
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&account).Error; err != nil {
+			return err
+		}
+		if req.IsDefault != nil && *req.IsDefault {
+			return setDefaultAccount(tx, account.UserID, account.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create account: " + err.Error()})
+		return
+	}
+
+	db.First(&account, account.ID)
+	account.Balance = account.OpeningBalance
+	c.JSON(http.StatusCreated, account)
+}
+
+// 口座更新
+func updateAccount(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	id := c.Param("id")
+	var account Account
+
+	if err := db.Where("user_id = ?", userID).First(&account, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+		return
+	}
+
+	var req AccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+
+	// 取引がある口座の通貨を変えると残高が壊れるため変更させない
+	currency, err := currencyOrDefault(req.Currency, account.Currency)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if currency != account.Currency {
+		var count int64
+		db.Model(&Transaction{}).Where("account_id = ? OR to_account_id = ?", account.ID, account.ID).Count(&count)
+		if count > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot change currency of an account with existing transactions"})
+			return
+		}
+	}
+
+	account.Name = req.Name
+	account.Type = req.Type
+	account.Currency = currency
+	account.OpeningBalance = req.OpeningBalance
+	account.StatementRef = strings.TrimSpace(req.StatementRef)
+	if req.IsActive != nil {
+		account.IsActive = *req.IsActive
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&account).Error; err != nil {
+			return err
+		}
+		if req.IsDefault != nil && *req.IsDefault && !account.IsDefault {
+			return setDefaultAccount(tx, account.UserID, account.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update account: " + err.Error()})
+		return
+	}
+
+	balances, _ := accountBalances(db, userID)
+	db.First(&account, account.ID)
+	account.Balance = account.OpeningBalance + balances[account.ID]
+	c.JSON(http.StatusOK, account)
+}
+
+// 口座削除
+func deleteAccount(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	id := c.Param("id")
+	var account Account
+
+	if err := db.Where("user_id = ?", userID).First(&account, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+		return
+	}
+
+	var count int64
+	db.Model(&Transaction{}).Where("user_id = ? AND (account_id = ? OR to_account_id = ?)", userID, account.ID, account.ID).Count(&count)
+	if count > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot delete account with existing transactions"})
+		return
+	}
+	if account.IsDefault {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot delete the default account"})
+		return
+	}
+
+	if err := db.Delete(&account).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete account: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Account deleted successfully"})
+}
+
+// デフォルト口座を切り替える
+func setDefaultAccount(tx *gorm.DB, userID, accountID uint) error {
+	if err := tx.Model(&Account{}).Where("user_id = ? AND id <> ?", userID, accountID).Update("is_default", false).Error; err != nil {
+		return err
+	}
+	return tx.Model(&Account{}).Where("user_id = ? AND id = ?", userID, accountID).Update("is_default", true).Error
+}
+
+// ユーザーのデフォルト口座を返す（なければ基準通貨の現金口座を作成）
+func ensureUserHasDefaultAccount(tx *gorm.DB, userID uint) (Account, error) {
+	var account Account
+	result := tx.Where("user_id = ? AND is_default = ?", userID, true).Limit(1).Find(&account)
+	if result.Error != nil {
+		return account, result.Error
+	}
+	if result.RowsAffected > 0 {
+		return account, nil
+	}
+
+	account = Account{
+		UserID:    userID,
+		Name:      "現金",
+		Type:      "cash",
+		Currency:  userBaseCurrency(userID),
+		IsDefault: true,
+		IsActive:  true,
+	}
+	return account, tx.Create(&account).Error
+}
+
+// 取引で指定された口座を検証して返す（未指定の場合はデフォルト口座）
+func resolveAccount(userID uint, accountID uint) (Account, error) {
+	if accountID == 0 {
+		return ensureUserHasDefaultAccount(db, userID)
+	}
+
+	var account Account
+	if err := db.Where("user_id = ?", userID).First(&account, accountID).Error; err != nil {
+		return account, errors.New("account not found")
+	}
+	return account, nil
+}
+
+// 口座ごとの取引による増減（初期残高を除く、口座の通貨建て）
+//
+// 収入は加算、支出と振替元は減算、振替先は振替先通貨での金額を加算する。
+func accountBalances(tx *gorm.DB, userID interface{}) (map[uint]Money, error) {
+	var rows []struct {
+		AccountID uint
+		Total     Money
+	}
+
+	if err := tx.Model(&Transaction{}).Where("user_id = ?", userID).
+		Select("account_id, COALESCE(SUM(CASE WHEN type = 'income' THEN amount ELSE -amount END), 0) as total").
+		Group("account_id").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	balances := make(map[uint]Money, len(rows))
+	for _, row := range rows {
+		balances[row.AccountID] += row.Total
+	}
+
+	rows = nil
+	if err := tx.Model(&Transaction{}).Where("user_id = ? AND type = ? AND to_account_id IS NOT NULL", userID, "transfer").
+		Select("to_account_id as account_id, COALESCE(SUM(to_amount), 0) as total").
+		Group("to_account_id").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		balances[row.AccountID] += row.Total
+	}
+
+	return balances, nil
+}
+
+// 全口座の残高合計を基準通貨で返す（現在のレートで換算）
+func (cv *currencyConverter) totalAccountBalance(userID interface{}) (Money, error) {
+	var accounts []Account
+	if err := db.Where("user_id = ?", userID).Find(&accounts).Error; err != nil {
+		return 0, err
+	}
+
+	balances, err := accountBalances(db, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	var total Money
+	now := time.Now()
+	for _, account := range accounts {
+		amount, err := cv.convert(account.OpeningBalance+balances[account.ID], account.Currency, now)
+		if err != nil {
+			return 0, err
+		}
+		total += amount
+	}
+	return total, nil
+}
+
+// 既存ユーザーの口座を作成し、既存の取引・固定収支を割り当てる（マイグレーション用）
+//
+// 通貨ごとに現金口座を1つ作成し、基準通貨の口座をデフォルトにする。
+func assignAccountsToExistingData(tx *gorm.DB) error {
+	type user struct {
+		ID           uint
+		BaseCurrency string
+	}
+	type account struct {
+		ID             uint
+		UserID         uint
+		Name           string
+		Type           string
+		Currency       string
+		OpeningBalance int64
+		IsDefault      bool
+		IsActive       bool
+		CreatedAt      time.Time
+		UpdatedAt      time.Time
+	}
+
+	var users []user
+	if err := tx.Table("users").Select("id, base_currency").Find(&users).Error; err != nil {
+		return err
+	}
+
+	for _, u := range users {
+		base := u.BaseCurrency
+		if base == "" {
+			base = defaultCurrency
+		}
+
+		// 取引・固定収支で使われている通貨（基準通貨を先頭に）
+		var used []string
+		if err := tx.Raw("SELECT currency FROM transactions WHERE user_id = ? UNION SELECT currency FROM fixed_expenses WHERE user_id = ?", u.ID, u.ID).
+			Scan(&used).Error; err != nil {
+			return err
+		}
+		currencies := []string{base}
+		for _, currency := range used {
+			if currency != "" && currency != base {
+				currencies = append(currencies, currency)
+			}
+		}
+
+		for _, currency := range currencies {
+			name := "現金"
+			if currency != base {
+				name = "現金 (" + currency + ")"
+			}
+			acc := account{
+				UserID:    u.ID,
+				Name:      name,
+				Type:      "cash",
+				Currency:  currency,
+				IsDefault: currency == base,
+				IsActive:  true,
+			}
+			if err := tx.Table("accounts").Create(&acc).Error; err != nil {
+				return err
+			}
+
+			for _, table := range []string{"transactions", "fixed_expenses"} {
+				query := tx.Table(table).Where("user_id = ? AND currency = ?", u.ID, currency)
+				if currency == base {
+					query = tx.Table(table).Where("user_id = ? AND (currency = ? OR currency IS NULL OR currency = '')", u.ID, currency)
+				}
+				if err := query.Update("account_id", acc.ID).Error; err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 添付ファイルの保存先
+type AttachmentStorage interface {
+	Put(key string, data []byte, contentType string) error
+	Get(key string) (io.ReadCloser, error)
+	Delete(key string) error
+}
+
+var attachmentStorage AttachmentStorage
+
+var errAttachmentObjectNotFound = errors.New("attachment object not found")
+
+// 環境変数から保存先を選択する
+//
+// ATTACHMENT_STORAGE=s3 の場合は S3_ENDPOINT / S3_REGION / S3_BUCKET / S3_ACCESS_KEY_ID / S3_SECRET_ACCESS_KEY で
+// S3互換のストレージ（ローカルでは MinIO など）に保存する。それ以外の場合は ATTACHMENT_DIR に保存する。
+func newAttachmentStorageFromEnv() (AttachmentStorage, error) {
+	switch strings.ToLower(getEnv("ATTACHMENT_STORAGE", "local")) {
+	case "s3":
+		storage := &s3Storage{
+			Endpoint:  strings.TrimRight(getEnv("S3_ENDPOINT", "https://s3.amazonaws.com"), "/"),
+			Region:    getEnv("S3_REGION", "us-east-1"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+			PathStyle: getEnv("S3_USE_PATH_STYLE", "true") == "true",
+			Client:    &http.Client{Timeout: getEnvDuration("S3_TIMEOUT", 30*time.Second)},
+		}
+		if storage.Bucket == "" || storage.AccessKey == "" || storage.SecretKey == "" {
+			return nil, errors.New("S3_BUCKET, S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY are required")
+		}
+		return storage, nil
+	case "local":
+		return &localStorage{Dir: getEnv("ATTACHMENT_DIR", "attachments")}, nil
+	default:
+		return nil, fmt.Errorf("unknown ATTACHMENT_STORAGE %q", os.Getenv("ATTACHMENT_STORAGE"))
+	}
+}
+
+// ローカルのディレクトリに保存する
+type localStorage struct {
+	Dir string
+}
+
+func (s *localStorage) path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if clean == "/" || clean != "/"+key {
+		return "", fmt.Errorf("invalid attachment key: %q", key)
+	}
+	return filepath.Join(s.Dir, filepath.FromSlash(clean)), nil
+}
+
+func (s *localStorage) Put(key string, data []byte, contentType string) error {
+	name, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(name, data, 0o644)
+}
+
+func (s *localStorage) Get(key string) (io.ReadCloser, error) {
+	name, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errAttachmentObjectNotFound
+	}
+	return f, err
+}
+
+func (s *localStorage) Delete(key string) error {
+	name, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// 添付ファイルの上限（ATTACHMENT_MAX_SIZE_MB、既定 10MB）
+func attachmentMaxSize() int64 {
+	mb, err := strconv.Atoi(getEnv("ATTACHMENT_MAX_SIZE_MB", "10"))
+	if err != nil || mb <= 0 {
+		mb = 10
+	}
+	return int64(mb) << 20
+}
+
+// 受け付ける形式（内容から判定した MIME タイプ → 拡張子）
+var attachmentTypes = map[string]string{
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+	"image/gif":       ".gif",
+	"image/webp":      ".webp",
+	"application/pdf": ".pdf",
+}
+
+const (
+	// サムネイルの長辺
+	thumbnailSize = 320
+	// デコードする画像の画素数の上限（巨大な画像でメモリを使い切らないため）
+	maxThumbnailPixels = 50_000_000
+)
+
+// サムネイルの有無（保存先のキーは返さない）
+func (a *Attachment) AfterFind(tx *gorm.DB) error {
+	a.HasThumbnail = a.ThumbnailKey != ""
+	return nil
+}
+
+// 取引の添付ファイル一覧
+func getAttachments(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var transaction Transaction
+	if err := db.Where("user_id = ?", userID).First(&transaction, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
+		return
+	}
+
+	var attachments []Attachment
+	if err := db.Where("transaction_id = ?", transaction.ID).Order("id").Find(&attachments).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch attachments: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, attachments)
+}
+
+// 添付ファイルのアップロード（multipart の file）
+//
+// 形式はファイル名やヘッダーではなく内容から判定する。画像はサムネイルも作成する。
+func uploadAttachment(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var transaction Transaction
+	if err := db.Where("user_id = ?", userID).First(&transaction, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
+		return
+	}
+
+	maxSize := attachmentMaxSize()
+	// multipart のヘッダー分の余裕を持たせる
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize+1<<20)
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File is required (field: file) and must not exceed " + strconv.FormatInt(maxSize>>20, 10) + "MB"})
+		return
+	}
+	if file.Size > maxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File must not exceed " + strconv.FormatInt(maxSize>>20, 10) + "MB"})
+		return
+	}
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read uploaded file: " + err.Error()})
+		return
+	}
+	defer src.Close()
+	data, err := io.ReadAll(io.LimitReader(src, maxSize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read uploaded file: " + err.Error()})
+		return
+	}
+	if int64(len(data)) > maxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File must not exceed " + strconv.FormatInt(maxSize>>20, 10) + "MB"})
+		return
+	}
+	if len(data) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File is empty"})
+		return
+	}
+
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	ext, ok := attachmentTypes[contentType]
+	if !ok {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Unsupported file type " + contentType + "; upload JPEG, PNG, GIF, WebP or PDF"})
+		return
+	}
+
+	attachment := Attachment{
+		UserID:        userID.(uint),
+		TransactionID: transaction.ID,
+		FileName:      attachmentFileName(file.Filename, ext),
+	}
+	if err := storeAttachmentFile(&attachment, data, contentType); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store attachment: " + err.Error()})
+		return
+	}
+
+	if err := db.Create(&attachment).Error; err != nil {
+		removeAttachmentObjects([]Attachment{attachment})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save attachment: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, attachment)
+}
+
+// ファイルを新しいキーで保存し、形式・サイズ・ハッシュ・サムネイルを attachment に設定する
+//
+// 画像はサムネイルも作成する。サムネイルを作れない画像（WebPなど）でも保存は成功とする。
+func storeAttachmentFile(attachment *Attachment, data []byte, contentType string) error {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(data)
+	attachment.ContentType = contentType
+	attachment.Size = int64(len(data))
+	attachment.SHA256 = hex.EncodeToString(sum[:])
+	attachment.StorageKey = fmt.Sprintf("%d/%s%s", attachment.UserID, hex.EncodeToString(random), attachmentTypes[contentType])
+	attachment.ThumbnailKey, attachment.HasThumbnail = "", false
+
+	if err := attachmentStorage.Put(attachment.StorageKey, data, contentType); err != nil {
+		return err
+	}
+
+	if thumbnail, err := makeThumbnail(data); err != nil {
+		if contentType != "application/pdf" {
+			log.Printf("[ATTACHMENT] No thumbnail for %s: %v", attachment.StorageKey, err)
+		}
+	} else {
+		key := attachment.StorageKey + ".thumb.jpg"
+		if err := attachmentStorage.Put(key, thumbnail, "image/jpeg"); err != nil {
+			log.Printf("[ATTACHMENT] ERROR: Failed to store thumbnail %s: %v", key, err)
+		} else {
+			attachment.ThumbnailKey, attachment.HasThumbnail = key, true
+		}
+	}
+	return nil
+}
+
+// 保存するファイル名（パスを除き、拡張子がなければ形式に合わせて付ける）
+func attachmentFileName(name, ext string) string {
+	name = strings.TrimSpace(filepath.Base(strings.ReplaceAll(name, "\\", "/")))
+	if name == "" || name == "." || name == "/" {
+		name = "attachment"
+	}
+	if r := []rune(name); len(r) > 200 {
+		name = string(r[:200])
+	}
+	if filepath.Ext(name) == "" {
+		name += ext
+	}
+	return name
+}
+
+// 画像のサムネイル（長辺 thumbnailSize のJPEG）
+func makeThumbnail(data []byte) ([]byte, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if config.Width*config.Height > maxThumbnailPixels {
+		return nil, fmt.Errorf("image too large for a thumbnail (%dx%d)", config.Width, config.Height)
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, scaleImage(src, thumbnailSize), &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// 長辺が size 以下になるよう縮小する（各画素は元の範囲内の最大4x4点の平均）
+func scaleImage(src image.Image, size int) image.Image {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	dw, dh := w, h
+	if w >= h && w > size {
+		dw, dh = size, max(1, h*size/w)
+	} else if h > w && h > size {
+		dw, dh = max(1, w*size/h), size
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	// 透過部分はJPEGで黒くならないよう白で塗る
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*h/dh, max((y+1)*h/dh, y*h/dh+1)
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*w/dw, max((x+1)*w/dw, x*w/dw+1)
+			stepX, stepY := max(1, (x1-x0)/4), max(1, (y1-y0)/4)
+
+			var r, g, b, n uint32
+			for sy := y0; sy < y1; sy += stepY {
+				for sx := x0; sx < x1; sx += stepX {
+					pr, pg, pb, pa := src.At(bounds.Min.X+sx, bounds.Min.Y+sy).RGBA()
+					r += pr + (0xffff - pa)
+					g += pg + (0xffff - pa)
+					b += pb + (0xffff - pa)
+					n++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: 0xffff})
+		}
+	}
+	return dst
+}
+
+// 添付ファイルのダウンロード（thumbnail=true でサムネイル、download=true で保存用）
+func downloadAttachment(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var attachment Attachment
+	if err := db.Where("user_id = ?", userID).First(&attachment, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+		return
+	}
+
+	key, contentType, fileName := attachment.StorageKey, attachment.ContentType, attachment.FileName
+	if c.Query("thumbnail") == "true" {
+		if attachment.ThumbnailKey == "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Attachment has no thumbnail"})
+			return
+		}
+		key, contentType = attachment.ThumbnailKey, "image/jpeg"
+		fileName = strings.TrimSuffix(fileName, filepath.Ext(fileName)) + "_thumb.jpg"
+	}
+
+	object, err := attachmentStorage.Get(key)
+	if errors.Is(err, errAttachmentObjectNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment file not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read attachment: " + err.Error()})
+		return
+	}
+	defer object.Close()
+
+	disposition := "inline"
+	if c.Query("download") == "true" {
+		disposition = "attachment"
+	}
+	c.Header("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": fileName}))
+	// アップロードされた内容をブラウザで実行させない
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Content-Security-Policy", "default-src 'none'; sandbox")
+	c.Header("Cache-Control", "private, max-age=3600")
+
+	var length int64 = -1
+	if key == attachment.StorageKey {
+		length = attachment.Size
+	}
+	c.DataFromReader(http.StatusOK, length, contentType, object, nil)
+}
+
+// 添付ファイルの削除
+func deleteAttachment(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var attachment Attachment
+	if err := db.Where("user_id = ?", userID).First(&attachment, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+		return
+	}
+	if err := db.Delete(&attachment).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete attachment: " + err.Error()})
+		return
+	}
+	removeAttachmentObjects([]Attachment{attachment})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Attachment deleted successfully"})
+}
+
+// 取引の添付ファイルの記録を削除し、削除したものを返す（ファイルはコミット後に removeAttachmentObjects で消す）
+func deleteAttachmentRecords(tx *gorm.DB, transactionIDs interface{}) ([]Attachment, error) {
+	var attachments []Attachment
+	if err := tx.Where("transaction_id IN (?)", transactionIDs).Find(&attachments).Error; err != nil {
+		return nil, err
+	}
+	if len(attachments) == 0 {
+		return nil, nil
+	}
+	if err := tx.Where("transaction_id IN (?)", transactionIDs).Delete(&Attachment{}).Error; err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+// 保存先からファイルとサムネイルを削除する（失敗してもログのみ）
+func removeAttachmentObjects(attachments []Attachment) {
+	for _, attachment := range attachments {
+		for _, key := range []string{attachment.StorageKey, attachment.ThumbnailKey} {
+			if key == "" {
+				continue
+			}
+			if err := attachmentStorage.Delete(key); err != nil {
+				log.Printf("[ATTACHMENT] ERROR: Failed to delete %s: %v", key, err)
+			}
+		}
+	}
+}
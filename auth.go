@@ -1,6 +1,7 @@
 package main
 
 import (
+	"log"
 	"net/http"
 	"strings"
 	"time"
@@ -8,13 +9,13 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
-var jwtSecret = []byte("your-secret-key-change-in-production")
-
 type Claims struct {
-	UserID uint   `json:"user_id"`
-	Email  string `json:"email"`
+	UserID    uint   `json:"user_id"`
+	Email     string `json:"email"`
+	SessionID uint   `json:"sid"`
 	jwt.RegisteredClaims
 }
 
@@ -30,19 +31,20 @@ func checkPasswordHash(password, hash string) bool {
 	return err == nil
 }
 
-// JWTトークン生成
-func generateToken(userID uint, email string) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour)
+// JWTトークン生成（短命なアクセストークン。更新はリフレッシュトークンで行う）
+func generateToken(userID uint, email string, sessionID uint) (string, error) {
+	expirationTime := time.Now().Add(accessTokenTTL)
 	claims := &Claims{
-		UserID: userID,
-		Email:  email,
+		UserID:    userID,
+		Email:     email,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	return jwtKeys.sign(claims)
 }
 
 // ユーザー登録
@@ -53,9 +55,9 @@ func register(c *gin.Context) {
 		return
 	}
 
-	// 既存ユーザーチェック
+	// 既存ユーザーチェック（メールアドレスの大文字・小文字は区別しない）
 	var existingUser User
-	if err := db.Where("email = ?", req.Email).First(&existingUser).Error; err == nil {
+	if err := db.Where("LOWER(email) = LOWER(?)", req.Email).First(&existingUser).Error; err == nil {
 		c.JSON(http.StatusConflict, gin.H{"error": "このメールアドレスは既に登録されています"})
 		return
 	}
@@ -67,11 +69,19 @@ func register(c *gin.Context) {
 		return
 	}
 
+	// 基準通貨（未指定の場合は円）
+	baseCurrency, err := currencyOrDefault(req.BaseCurrency, defaultCurrency)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// ユーザー作成
 	user := User{
-		Email:    req.Email,
-		Password: hashedPassword,
-		Name:     req.Name,
+		Email:        req.Email,
+		Password:     hashedPassword,
+		Name:         req.Name,
+		BaseCurrency: baseCurrency,
 	}
 
 	if err := db.Create(&user).Error; err != nil {
@@ -80,19 +90,24 @@ func register(c *gin.Context) {
 	}
 
 	// デフォルトカテゴリ作成
-	ensureUserHasDefaultCategories(user.ID)
+	ensureUserHasDefaultCategories(db, user.ID)
+
+	// デフォルト口座（基準通貨の現金）作成
+	ensureUserHasDefaultAccount(db, user.ID)
+
+	// メールアドレス確認メール送信（失敗しても登録は完了させる）
+	if err := sendVerificationEmail(user); err != nil {
+		log.Printf("[MAIL] ERROR: Failed to send verification email to user %d: %v", user.ID, err)
+	}
 
 	// トークン生成
-	token, err := generateToken(user.ID, user.Email)
+	response, err := issueTokens(c, user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "トークンの生成に失敗しました"})
 		return
 	}
 
-	c.JSON(http.StatusCreated, AuthResponse{
-		Token: token,
-		User:  user,
-	})
+	c.JSON(http.StatusCreated, response)
 }
 
 // ログイン
@@ -103,34 +118,81 @@ func login(c *gin.Context) {
 		return
 	}
 
+	// 総当たり対策（アカウント・IPごとの失敗回数でロック）
+	ip := c.ClientIP()
+	if err := checkLoginThrottle(req.Email, ip); err != nil {
+		if respondLockedOut(c, err) {
+			auditLoginFailure(c, req.Email, nil, "locked_out")
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "ログインに失敗しました"})
+		return
+	}
+
 	// ユーザー検索
 	var user User
-	if err := db.Where("email = ?", req.Email).First(&user).Error; err != nil {
+	if err := db.Where("LOWER(email) = LOWER(?)", req.Email).First(&user).Error; err != nil {
+		recordLoginFailure(req.Email, ip)
+		auditLoginFailure(c, req.Email, nil, "unknown_email")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "メールアドレスまたはパスワードが間違っています"})
 		return
 	}
 
 	// パスワード検証
 	if !checkPasswordHash(req.Password, user.Password) {
+		recordLoginFailure(req.Email, ip)
+		auditLoginFailure(c, req.Email, &user.ID, "bad_password")
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "メールアドレスまたはパスワードが間違っています"})
 		return
 	}
 
+	// 削除予定のアカウントは復元するまでログインできない
+	if user.DeletionScheduledAt != nil {
+		resetLoginFailures(req.Email)
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":               "このアカウントは削除予定です。復元するには /api/account/restore を使用してください",
+			"deletionScheduledAt": user.DeletionScheduledAt,
+		})
+		return
+	}
+
+	// 2段階認証が有効な場合は認証コードの確認後にトークンを発行する
+	if user.TOTPEnabled {
+		challenge, err := generateChallengeToken(user.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "トークンの生成に失敗しました"})
+			return
+		}
+
+		c.JSON(http.StatusOK, TwoFactorChallengeResponse{
+			TwoFactorRequired: true,
+			ChallengeToken:    challenge,
+			ExpiresIn:         int64(twoFactorChallengeTTL / time.Second),
+		})
+		return
+	}
+
+	resetLoginFailures(req.Email)
+
 	// トークン生成
-	token, err := generateToken(user.ID, user.Email)
+	response, err := issueTokens(c, user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "トークンの生成に失敗しました"})
 		return
 	}
 
-	c.JSON(http.StatusOK, AuthResponse{
-		Token: token,
-		User:  user,
-	})
+	c.JSON(http.StatusOK, response)
 }
 
-// ログアウト
+// ログアウト（セッションを失効させ、リフレッシュトークンも使えなくする）
 func logout(c *gin.Context) {
+	sessionID, _ := c.Get("sessionID")
+
+	if err := revokeSession(sessionID.(uint), "logout"); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "ログアウトに失敗しました"})
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "ログアウトしました"})
 }
 
@@ -158,11 +220,24 @@ func authMiddleware() gin.HandlerFunc {
 		}
 
 		tokenString := strings.Replace(authHeader, "Bearer ", "", 1)
+
+		// パーソナルアクセストークン（スコープは requireScope で確認する）
+		if strings.HasPrefix(tokenString, personalAccessTokenPrefix) {
+			token, err := authenticatePersonalAccessToken(tokenString)
+			if err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "無効なトークンです"})
+				c.Abort()
+				return
+			}
+
+			c.Set("userID", token.UserID)
+			c.Set("tokenScopes", token.Scopes)
+			c.Next()
+			return
+		}
 		
 		claims := &Claims{}
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return jwtSecret, nil
-		})
+		token, err := jwtKeys.parse(tokenString, claims)
 
 		if err != nil || !token.Valid {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "無効なトークンです"})
@@ -170,14 +245,22 @@ func authMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		// ログアウト・失効済みのセッションのトークンは拒否する
+		if claims.SessionID == 0 || !isSessionActive(claims.SessionID) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "セッションが無効です。再度ログインしてください"})
+			c.Abort()
+			return
+		}
+
 		c.Set("userID", claims.UserID)
+		c.Set("sessionID", claims.SessionID)
 		c.Set("userEmail", claims.Email)
 		c.Next()
 	}
 }
 
 // 特定のユーザーに不足しているカテゴリを追加
-func ensureUserHasDefaultCategories(userID uint) {
+func ensureUserHasDefaultCategories(tx *gorm.DB, userID uint) error {
 	// 必要なカテゴリのリスト
 	requiredCategories := []Category{
 		// 収入カテゴリ
@@ -206,26 +289,37 @@ func ensureUserHasDefaultCategories(userID uint) {
 	// 各カテゴリが存在するかチェックし、存在しない場合は作成
 	for _, requiredCategory := range requiredCategories {
 		var existingCategory Category
-		result := db.Where("user_id = ? AND name = ? AND type = ?", userID, requiredCategory.Name, requiredCategory.Type).First(&existingCategory)
-		
+		result := tx.Where("user_id = ? AND name = ? AND type = ?", userID, requiredCategory.Name, requiredCategory.Type).Limit(1).Find(&existingCategory)
 		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
 			// カテゴリが存在しない場合は作成
-			db.Create(&requiredCategory)
+			if err := tx.Create(&requiredCategory).Error; err != nil {
+				return err
+			}
 		}
 	}
+	return nil
 }
 
-// 既存のすべてのユーザーに対して不足しているカテゴリを追加
-func ensureAllUsersHaveDefaultCategories() {
+// 既存のすべてのユーザーに対して不足しているカテゴリを追加（データマイグレーション）
+func ensureAllUsersHaveDefaultCategories(tx *gorm.DB) error {
 	var users []User
-	db.Find(&users)
-	
+	if err := tx.Select("id").Find(&users).Error; err != nil {
+		return err
+	}
+
 	for _, user := range users {
-		ensureUserHasDefaultCategories(user.ID)
+		if err := ensureUserHasDefaultCategories(tx, user.ID); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
 // デフォルトカテゴリ作成（後方互換性のため残す）
 func createDefaultCategories(userID uint) {
-	ensureUserHasDefaultCategories(userID)
+	ensureUserHasDefaultCategories(db, userID)
 }
\ No newline at end of file
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/unicode/norm"
+	"gorm.io/gorm"
+)
+
+// ルールで付けるタグ名の一覧（DBにはJSONで保存する）
+type TagNames []string
+
+func (t TagNames) Value() (driver.Value, error) {
+	if t == nil {
+		return "[]", nil
+	}
+	data, err := json.Marshal(t)
+	return string(data), err
+}
+
+func (t *TagNames) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*t = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, t)
+	case string:
+		return json.Unmarshal([]byte(v), t)
+	default:
+		return fmt.Errorf("cannot scan %T into TagNames", value)
+	}
+}
+
+// 評価用に準備したルール
+type compiledCategoryRule struct {
+	rule    CategoryRule
+	keyword string         // contains（foldRuleText で正規化した語）
+	pattern *regexp.Regexp // regex（compileRulePattern で準備したもの）
+}
+
+// 自動振り分けルールの評価
+type categoryRuleEngine struct {
+	rules []compiledCategoryRule
+}
+
+// 有効なルールを優先度の順（同じ優先度は作成順）に読み込む
+func newCategoryRuleEngine(userID uint) (*categoryRuleEngine, error) {
+	var rules []CategoryRule
+	if err := db.Preload("Category").Where("user_id = ? AND enabled = ?", userID, true).
+		Order("priority ASC, id ASC").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+
+	engine := &categoryRuleEngine{}
+	for _, rule := range rules {
+		compiled := compiledCategoryRule{rule: rule}
+		if rule.MatchType == "regex" {
+			pattern, err := compileRulePattern(rule.Pattern)
+			if err != nil {
+				// 保存時に検証しているので通常は起きない
+				continue
+			}
+			compiled.pattern = pattern
+		} else {
+			compiled.keyword = foldRuleText(rule.Pattern)
+		}
+		engine.rules = append(engine.rules, compiled)
+	}
+	return engine, nil
+}
+
+// 全角・半角（半角カナの濁点を含む）と大文字・小文字の違いをなくす
+func foldRuleText(text string) string {
+	return strings.ToLower(norm.NFKC.String(text))
+}
+
+// 正規表現を foldRuleText で正規化した説明に一致するように準備する
+//
+// contains と同じく、リテラル部分も正規化して大文字・小文字を区別しない（ｾﾌﾞﾝ は セブン に一致する）。
+// 文字クラスやエスケープはそのまま使う。
+func compileRulePattern(pattern string) (*regexp.Regexp, error) {
+	re, err := syntax.Parse(pattern, syntax.Perl|syntax.FoldCase)
+	if err != nil {
+		return nil, err
+	}
+	foldRuleLiterals(re)
+	return regexp.Compile(re.String())
+}
+
+func foldRuleLiterals(re *syntax.Regexp) {
+	if re.Op == syntax.OpLiteral {
+		re.Rune = []rune(foldRuleText(string(re.Rune)))
+	}
+	for _, sub := range re.Sub {
+		foldRuleLiterals(sub)
+	}
+}
+
+// 最初に一致したルール（なければ nil）
+//
+// ルールはカテゴリと同じ種類の取引にだけ一致する。
+func (e *categoryRuleEngine) match(transactionType string, amount Money, description string) *CategoryRule {
+	if e == nil || transactionType == "" || transactionType == "transfer" {
+		return nil
+	}
+	folded := foldRuleText(description)
+	for i := range e.rules {
+		r := &e.rules[i]
+		if r.rule.Type != transactionType {
+			continue
+		}
+		if r.rule.MinAmount != nil && amount < *r.rule.MinAmount {
+			continue
+		}
+		if r.rule.MaxAmount != nil && amount > *r.rule.MaxAmount {
+			continue
+		}
+		if r.pattern != nil && !r.pattern.MatchString(folded) {
+			continue
+		}
+		if r.pattern == nil && !strings.Contains(folded, r.keyword) {
+			continue
+		}
+		return &r.rule
+	}
+	return nil
+}
+
+// 取引にルールを適用する（一致した場合はカテゴリを置き換え、タグを追加する）
+func (e *categoryRuleEngine) apply(transaction *Transaction) *CategoryRule {
+	rule := e.match(transaction.Type, transaction.Amount, transaction.Description)
+	if rule == nil {
+		return nil
+	}
+	transaction.CategoryID = rule.CategoryID
+	transaction.Category = rule.Category
+	transaction.Tags = mergeRuleTags(transaction.Tags, rule.Tags)
+	return rule
+}
+
+// ルールで付けるタグ名を置き換える（タグの名前の変更・統合・削除の際に使う。to が空の場合は外す）
+func renameRuleTags(tx *gorm.DB, userID uint, from, to string) error {
+	var rules []CategoryRule
+	if err := tx.Select("id, tags").Where("user_id = ?", userID).Find(&rules).Error; err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		changed := false
+		renamed := make([]string, 0, len(rule.Tags))
+		for _, name := range rule.Tags {
+			if strings.TrimSpace(name) == from {
+				changed = true
+				name = to
+			}
+			renamed = append(renamed, name)
+		}
+		if !changed {
+			continue
+		}
+		// 置き換えた名前がすでにあれば1つにする
+		unique := TagNames{}
+		for _, tag := range tagsFromNames(renamed) {
+			unique = append(unique, tag.Name)
+		}
+		if err := tx.Model(&CategoryRule{}).Where("id = ?", rule.ID).UpdateColumn("tags", unique).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 付いていないタグだけを追加する
+func mergeRuleTags(tags []Tag, names TagNames) []Tag {
+	has := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		has[tag.Name] = true
+	}
+	for _, tag := range tagsFromNames(names) {
+		if !has[tag.Name] {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// 自動振り分けルール一覧（評価する順）
+func getCategoryRules(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var rules []CategoryRule
+	if err := db.Preload("Category").Where("user_id = ?", userID).Order("priority ASC, id ASC").Find(&rules).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch category rules: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, rules)
+}
+
+// リクエスト内容を検証してルールに反映する
+func applyCategoryRuleRequest(rule *CategoryRule, req CategoryRuleRequest) error {
+	if req.MatchType == "" {
+		req.MatchType = "contains"
+	}
+	req.Pattern = strings.TrimSpace(req.Pattern)
+	if req.Pattern == "" && req.MinAmount == nil && req.MaxAmount == nil {
+		return errors.New("pattern or an amount range is required")
+	}
+	if req.MatchType == "regex" {
+		if _, err := compileRulePattern(req.Pattern); err != nil {
+			return fmt.Errorf("invalid pattern: %v", err)
+		}
+	}
+	if req.MinAmount != nil && req.MaxAmount != nil && *req.MinAmount > *req.MaxAmount {
+		return errors.New("minAmount must not exceed maxAmount")
+	}
+
+	// ルールの種類はカテゴリの種類で決まる
+	var category Category
+	if err := db.Where("user_id = ?", rule.UserID).First(&category, req.CategoryID).Error; err != nil {
+		return fmt.Errorf("Category %d not found", req.CategoryID)
+	}
+
+	rule.Name = strings.TrimSpace(req.Name)
+	if rule.Name == "" {
+		rule.Name = req.Pattern
+	}
+	rule.Priority = req.Priority
+	rule.MatchType = req.MatchType
+	rule.Pattern = req.Pattern
+	rule.Type = category.Type
+	rule.MinAmount = req.MinAmount
+	rule.MaxAmount = req.MaxAmount
+	rule.CategoryID = category.ID
+	rule.Category = category
+	rule.Tags = TagNames{}
+	for _, tag := range tagsFromNames(req.Tags) {
+		rule.Tags = append(rule.Tags, tag.Name)
+	}
+	rule.Enabled = req.Enabled == nil || *req.Enabled
+	return nil
+}
+
+func createCategoryRule(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var req CategoryRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+
+	rule := CategoryRule{UserID: userID.(uint)}
+	if err := applyCategoryRuleRequest(&rule, req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := db.Omit("Category").Create(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create category rule: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, rule)
+}
+
+func updateCategoryRule(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var rule CategoryRule
+	if err := db.Where("user_id = ?", userID).First(&rule, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Category rule not found"})
+		return
+	}
+
+	var req CategoryRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+	if err := applyCategoryRuleRequest(&rule, req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := db.Omit("Category").Save(&rule).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update category rule: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, rule)
+}
+
+func deleteCategoryRule(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	result := db.Where("user_id = ?", userID).Delete(&CategoryRule{}, c.Param("id"))
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete category rule: " + result.Error.Error()})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Category rule not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Category rule deleted successfully"})
+}
+
+// ルールを登録済みの取引に適用した場合の変更
+type categoryRuleChange struct {
+	TransactionID  uint      `json:"transactionId"`
+	Date           time.Time `json:"date"`
+	Type           string    `json:"type"`
+	Amount         Money     `json:"amount"`
+	Description    string    `json:"description"`
+	RuleID         uint      `json:"ruleId"`
+	RuleName       string    `json:"ruleName"`
+	FromCategoryID uint      `json:"fromCategoryId"`
+	FromCategory   string    `json:"fromCategory"`
+	ToCategoryID   uint      `json:"toCategoryId"`
+	ToCategory     string    `json:"toCategory"`
+	AddTags        []string  `json:"addTags,omitempty"`
+
+	transaction Transaction
+}
+
+// 取引一覧と同じ条件で絞り込んだ取引にルールを当て、変わるものを返す
+//
+// 振替と内訳のある取引は対象外。transactionIDs を指定した場合はその取引だけを対象にする。
+func categoryRuleChanges(c *gin.Context, userID interface{}, transactionIDs []uint) ([]categoryRuleChange, error) {
+	engine, err := newCategoryRuleEngine(userID.(uint))
+	if err != nil {
+		return nil, err
+	}
+
+	query := filterTransactions(db.Preload("Category").Preload("Tags").Where("user_id = ? AND type <> ?", userID, "transfer"), c).
+		Where("id NOT IN (?)", db.Model(&TransactionSplit{}).Select("transaction_id").Where("user_id = ?", userID))
+	if transactionIDs != nil {
+		query = query.Where("id IN ?", transactionIDs)
+	}
+	var transactions []Transaction
+	if err := query.Order("date DESC, id DESC").Find(&transactions).Error; err != nil {
+		return nil, err
+	}
+
+	changes := []categoryRuleChange{}
+	for _, t := range transactions {
+		rule := engine.match(t.Type, t.Amount, t.Description)
+		if rule == nil {
+			continue
+		}
+		tags := mergeRuleTags(t.Tags, rule.Tags)
+		if rule.CategoryID == t.CategoryID && len(tags) == len(t.Tags) {
+			continue
+		}
+
+		change := categoryRuleChange{
+			TransactionID:  t.ID,
+			Date:           t.Date,
+			Type:           t.Type,
+			Amount:         t.Amount,
+			Description:    t.Description,
+			RuleID:         rule.ID,
+			RuleName:       rule.Name,
+			FromCategoryID: t.CategoryID,
+			FromCategory:   t.Category.Name,
+			ToCategoryID:   rule.CategoryID,
+			ToCategory:     rule.Category.Name,
+		}
+		for _, tag := range tags[len(t.Tags):] {
+			change.AddTags = append(change.AddTags, tag.Name)
+		}
+		t.CategoryID, t.Category, t.Tags = rule.CategoryID, Category{}, tags
+		change.transaction = t
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// 登録済みの取引にルールを当てた場合の変更の一覧（変更はしない）
+//
+// 取引一覧と同じ条件（type, categoryId, accountId, startDate, endDate, tagId, tag）で絞り込める。
+func previewCategoryRules(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	changes, err := categoryRuleChanges(c, userID, nil)
+	if err != nil {
+		respondFilterError(c, "Failed to evaluate category rules", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"changes": changes, "count": len(changes)})
+}
+
+// 登録済みの取引にルールを当てる
+//
+// 絞り込みはプレビューと同じ。transactionIds を指定した場合は、プレビューで選んだ取引だけを変更する。
+func applyCategoryRules(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var req CategoryRuleApplyRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+			return
+		}
+	}
+
+	changes, err := categoryRuleChanges(c, userID, req.TransactionIDs)
+	if err != nil {
+		respondFilterError(c, "Failed to evaluate category rules", err)
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		ids := make([]uint, 0, len(changes))
+		for i := range changes {
+			t := &changes[i].transaction
+			if err := tx.Model(&Transaction{}).Where("id = ?", t.ID).Update("category_id", t.CategoryID).Error; err != nil {
+				return err
+			}
+			if len(changes[i].AddTags) > 0 {
+				if err := replaceTransactionTags(tx, t); err != nil {
+					return err
+				}
+			}
+			ids = append(ids, t.ID)
+		}
+		_, err := indexTransactions(tx, ids)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply category rules: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"changes": changes, "updated": len(changes)})
+}
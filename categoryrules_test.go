@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestCompileRulePattern(t *testing.T) {
+	tests := []struct {
+		pattern     string
+		description string
+		want        bool
+	}{
+		{pattern: `^JR(東|西)日本`, description: "JR東日本 Suica", want: true},
+		{pattern: `^JR(東|西)日本`, description: "ＪＲ西日本", want: true},
+		{pattern: `^JR(東|西)日本`, description: "jr北海道", want: false},
+		{pattern: `ｾﾌﾞﾝ`, description: "セブン-イレブン", want: true},
+		{pattern: `AMAZON\.CO\.JP`, description: "Amazon.co.jp", want: true},
+		{pattern: `amazon\.co\.jp`, description: "AMAZONXCOXJP", want: false},
+		{pattern: `カード\d{4}$`, description: "カード１２３４", want: true},
+		{pattern: `[A-Z]+PAY`, description: "LinePay", want: true},
+		{pattern: `株式会社（`, description: "株式会社(テスト", want: true},
+	}
+	for _, tt := range tests {
+		re, err := compileRulePattern(tt.pattern)
+		if err != nil {
+			t.Errorf("compileRulePattern(%q) returned error: %v", tt.pattern, err)
+			continue
+		}
+		if got := re.MatchString(foldRuleText(tt.description)); got != tt.want {
+			t.Errorf("pattern %q on %q = %v, want %v", tt.pattern, tt.description, got, tt.want)
+		}
+	}
+
+	if _, err := compileRulePattern(`(unclosed`); err == nil {
+		t.Error("compileRulePattern accepted an invalid pattern")
+	}
+}
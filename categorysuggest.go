@@ -0,0 +1,315 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/unicode/norm"
+)
+
+// 過去の取引から学習したカテゴリの推定（ユーザーごとの多項ナイーブベイズ）
+//
+// 特徴は説明の文字2-gram・3-gram（単語の区切りがない日本語でも使える）と金額の桁。
+// モデルはメモリ上に持ち、推定のたびに追加・変更・削除された取引の分だけ学習し直す。
+type categoryModel struct {
+	mu       sync.Mutex
+	lastUsed time.Time
+
+	entries    map[uint]categoryModelEntry // 学習済みの取引
+	features   map[uint]map[string]int     // カテゴリ → 特徴 → 出現数
+	totals     map[uint]int                // カテゴリ → 特徴の総数
+	documents  map[uint]int                // カテゴリ → 学習した明細の数
+	vocabulary map[string]int              // 特徴 → 出現数（全カテゴリ）
+	trained    int                         // 学習した明細の数
+}
+
+// 学習した取引（変更・削除時に同じ特徴を取り除くため）
+type categoryModelEntry struct {
+	UpdatedAt time.Time
+	Lines     []categoryModelLine
+}
+
+type categoryModelLine struct {
+	CategoryID  uint
+	Description string
+	Amount      Money
+}
+
+// ユーザーごとのモデル
+type categoryModelStore struct {
+	mu     sync.Mutex
+	models map[uint]*categoryModel
+}
+
+var categoryModels = &categoryModelStore{models: make(map[uint]*categoryModel)}
+
+// 平滑化の係数
+const categoryModelAlpha = 0.5
+
+func (s *categoryModelStore) get(userID uint) *categoryModel {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	model, ok := s.models[userID]
+	if !ok {
+		// しばらく使われていないモデルを捨ててメモリの増加を防ぐ
+		if len(s.models) >= 1000 {
+			for id, m := range s.models {
+				m.mu.Lock()
+				idle := now.Sub(m.lastUsed) > 24*time.Hour
+				m.mu.Unlock()
+				if idle {
+					delete(s.models, id)
+				}
+			}
+		}
+		model = &categoryModel{
+			entries:    make(map[uint]categoryModelEntry),
+			features:   make(map[uint]map[string]int),
+			totals:     make(map[uint]int),
+			documents:  make(map[uint]int),
+			vocabulary: make(map[string]int),
+		}
+		s.models[userID] = model
+	}
+	return model
+}
+
+func (s *categoryModelStore) forget(userID uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.models, userID)
+}
+
+// 説明と金額から特徴を作る
+func categoryFeatures(description string, amount Money) []string {
+	// 全角・半角と大文字・小文字をそろえ、店舗番号などの数字・記号・空白は除く
+	runes := []rune("^")
+	for _, r := range strings.ToLower(norm.NFKC.String(description)) {
+		if unicode.IsLetter(r) {
+			runes = append(runes, r)
+		}
+	}
+	runes = append(runes, '$')
+
+	// 説明がない場合は金額だけで推定する
+	var features []string
+	if len(runes) > 2 {
+		for n := 2; n <= 3; n++ {
+			for i := 0; i+n <= len(runes); i++ {
+				features = append(features, string(runes[i:i+n]))
+			}
+		}
+	}
+
+	// 金額は 1, 3, 10, 30, 100 ... の区切りで1つの特徴にする
+	if value := math.Abs(amount.Float64()); value >= 1 {
+		features = append(features, "\x00amount:"+strconv.Itoa(int(math.Floor(math.Log10(value)*2))))
+	}
+	return features
+}
+
+func (m *categoryModel) addLine(line categoryModelLine, delta int) {
+	features := m.features[line.CategoryID]
+	if features == nil {
+		features = make(map[string]int)
+		m.features[line.CategoryID] = features
+	}
+	for _, feature := range categoryFeatures(line.Description, line.Amount) {
+		features[feature] += delta
+		if features[feature] <= 0 {
+			delete(features, feature)
+		}
+		m.vocabulary[feature] += delta
+		if m.vocabulary[feature] <= 0 {
+			delete(m.vocabulary, feature)
+		}
+		m.totals[line.CategoryID] += delta
+	}
+	m.documents[line.CategoryID] += delta
+	m.trained += delta
+}
+
+func (m *categoryModel) remove(id uint) {
+	entry, ok := m.entries[id]
+	if !ok {
+		return
+	}
+	for _, line := range entry.Lines {
+		m.addLine(line, -1)
+	}
+	delete(m.entries, id)
+}
+
+func (m *categoryModel) add(t Transaction) {
+	entry := categoryModelEntry{UpdatedAt: t.UpdatedAt}
+	if len(t.Splits) > 0 {
+		// 内訳のある取引は内訳ごとに学習する
+		for _, split := range t.Splits {
+			entry.Lines = append(entry.Lines, categoryModelLine{CategoryID: split.CategoryID, Description: t.Description, Amount: split.Amount})
+		}
+	} else {
+		entry.Lines = []categoryModelLine{{CategoryID: t.CategoryID, Description: t.Description, Amount: t.Amount}}
+	}
+	for _, line := range entry.Lines {
+		m.addLine(line, 1)
+	}
+	m.entries[t.ID] = entry
+}
+
+// 前回から追加・変更・削除された取引を学習し直す
+func (m *categoryModel) refresh(userID uint) error {
+	var current []struct {
+		ID        uint
+		UpdatedAt time.Time
+	}
+	if err := db.Model(&Transaction{}).Select("id, updated_at").Where("user_id = ? AND type <> ?", userID, "transfer").
+		Scan(&current).Error; err != nil {
+		return err
+	}
+
+	seen := make(map[uint]bool, len(current))
+	var changed []uint
+	for _, row := range current {
+		seen[row.ID] = true
+		if entry, ok := m.entries[row.ID]; !ok || !entry.UpdatedAt.Equal(row.UpdatedAt) {
+			changed = append(changed, row.ID)
+		}
+	}
+	for id := range m.entries {
+		if !seen[id] {
+			m.remove(id)
+		}
+	}
+
+	for start := 0; start < len(changed); start += 500 {
+		end := min(start+500, len(changed))
+		var transactions []Transaction
+		if err := db.Preload("Splits").Where("id IN ?", changed[start:end]).Find(&transactions).Error; err != nil {
+			return err
+		}
+		for _, t := range transactions {
+			m.remove(t.ID)
+			m.add(t)
+		}
+	}
+	return nil
+}
+
+// カテゴリの推定結果
+type categorySuggestion struct {
+	Category    Category `json:"category"`
+	Probability float64  `json:"probability"` // 候補の中での確率（0〜1）
+	Trained     int      `json:"trained"`     // このカテゴリで学習した明細の数
+}
+
+// 候補のカテゴリを確率の高い順に並べる
+func (m *categoryModel) rank(categories []Category, description string, amount Money) []categorySuggestion {
+	features := categoryFeatures(description, amount)
+	vocabulary := float64(len(m.vocabulary) + 1)
+
+	scores := make([]float64, len(categories))
+	best := math.Inf(-1)
+	for i, category := range categories {
+		// 事前確率（学習した明細の数。学習していないカテゴリも候補に残す）
+		score := math.Log(float64(m.documents[category.ID]+1) / float64(m.trained+len(categories)))
+		counts := m.features[category.ID]
+		total := float64(m.totals[category.ID])
+		for _, feature := range features {
+			score += math.Log((float64(counts[feature]) + categoryModelAlpha) / (total + categoryModelAlpha*vocabulary))
+		}
+		scores[i] = score
+		best = math.Max(best, score)
+	}
+
+	var sum float64
+	for i := range scores {
+		scores[i] = math.Exp(scores[i] - best)
+		sum += scores[i]
+	}
+	suggestions := make([]categorySuggestion, 0, len(categories))
+	for i, category := range categories {
+		suggestions = append(suggestions, categorySuggestion{
+			Category:    category,
+			Probability: math.Round(scores[i]/sum*1000) / 1000,
+			Trained:     m.documents[category.ID],
+		})
+	}
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		return suggestions[i].Probability > suggestions[j].Probability
+	})
+	return suggestions
+}
+
+// 説明・金額から取引のカテゴリを推定する
+//
+// description, amount のどちらかが必要。type（income, expense）を指定した場合はその種類のカテゴリだけを返す。
+// limit は返す候補の数（既定 5）。
+func suggestCategory(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	description := strings.TrimSpace(c.Query("description"))
+	var amount Money
+	if value := c.Query("amount"); value != "" {
+		parsed, err := parseMoney(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid amount: " + value})
+			return
+		}
+		amount = parsed
+	}
+	if description == "" && amount == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "description or amount is required"})
+		return
+	}
+	transactionType := c.Query("type")
+	if transactionType != "" && transactionType != "income" && transactionType != "expense" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be income or expense"})
+		return
+	}
+	limit := 5
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 50 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 50"})
+			return
+		}
+		limit = n
+	}
+
+	var categories []Category
+	query := db.Where("user_id = ?", userID).Order("id")
+	if transactionType != "" {
+		query = query.Where("type = ?", transactionType)
+	}
+	if err := query.Find(&categories).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch categories: " + err.Error()})
+		return
+	}
+
+	model := categoryModels.get(userID.(uint))
+	model.mu.Lock()
+	defer model.mu.Unlock()
+	model.lastUsed = time.Now()
+	if err := model.refresh(userID.(uint)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to train category model: " + err.Error()})
+		return
+	}
+
+	suggestions := model.rank(categories, description, amount)
+	if len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"suggestions":  suggestions,
+		"trainedLines": model.trained,
+	})
+}
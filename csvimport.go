@@ -0,0 +1,769 @@
+package main
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/width"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 取込できるCSVの最大サイズ
+const maxCSVImportSize = 10 << 20
+
+// 日付形式を指定しない場合に試す形式
+var statementDateLayouts = []string{
+	"2006-01-02",
+	"2006/01/02",
+	"2006/1/2",
+	"20060102",
+	"2006.01.02",
+	"2006年1月2日",
+}
+
+// カテゴリ振り分けルールの一覧（DBにはJSONで保存する）
+type ImportCategoryRules []ImportCategoryRule
+
+func (r ImportCategoryRules) Value() (driver.Value, error) {
+	if r == nil {
+		return "[]", nil
+	}
+	data, err := json.Marshal(r)
+	return string(data), err
+}
+
+func (r *ImportCategoryRules) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*r = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, r)
+	case string:
+		return json.Unmarshal([]byte(v), r)
+	default:
+		return fmt.Errorf("cannot scan %T into ImportCategoryRules", value)
+	}
+}
+
+// CSV取込設定一覧取得
+func getImportProfiles(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	var profiles []ImportProfile
+
+	if err := db.Where("user_id = ?", userID).Order("name").Find(&profiles).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch import profiles: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, profiles)
+}
+
+// CSV取込設定作成
+func createImportProfile(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	var req ImportProfileRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+
+	profile := ImportProfile{UserID: userID.(uint)}
+	if err := applyImportProfileRequest(&profile, req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := db.Create(&profile).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create import profile: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, profile)
+}
+
+// CSV取込設定更新
+func updateImportProfile(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	id := c.Param("id")
+	var profile ImportProfile
+
+	if err := db.Where("user_id = ?", userID).First(&profile, id).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Import profile not found"})
+		return
+	}
+
+	var req ImportProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+
+	if err := applyImportProfileRequest(&profile, req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := db.Save(&profile).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update import profile: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+// CSV取込設定削除
+func deleteImportProfile(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	id := c.Param("id")
+
+	result := db.Where("user_id = ?", userID).Delete(&ImportProfile{}, id)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete import profile: " + result.Error.Error()})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Import profile not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Import profile deleted successfully"})
+}
+
+// リクエスト内容を検証して取込設定に反映する
+func applyImportProfileRequest(profile *ImportProfile, req ImportProfileRequest) error {
+	if req.AmountColumn == "" && (req.IncomeColumn == "" || req.ExpenseColumn == "") {
+		return errors.New("amountColumn, or both incomeColumn and expenseColumn, are required")
+	}
+	if req.DateFormat != "" && !strings.Contains(req.DateFormat, "YY") {
+		return fmt.Errorf("invalid dateFormat: %s", req.DateFormat)
+	}
+
+	// 口座・カテゴリは本人のものに限る
+	if req.AccountID != 0 {
+		if _, err := resolveAccount(profile.UserID, req.AccountID); err != nil {
+			return errors.New("Account not found")
+		}
+	}
+	categoryIDs := []uint{req.DefaultIncomeCategoryID, req.DefaultExpenseCategoryID}
+	for _, rule := range req.CategoryRules {
+		categoryIDs = append(categoryIDs, rule.CategoryID)
+	}
+	for _, categoryID := range categoryIDs {
+		if categoryID == 0 {
+			continue
+		}
+		var count int64
+		db.Model(&Category{}).Where("user_id = ? AND id = ?", profile.UserID, categoryID).Count(&count)
+		if count == 0 {
+			return fmt.Errorf("Category %d not found", categoryID)
+		}
+	}
+
+	profile.Name = req.Name
+	profile.Encoding = req.Encoding
+	profile.Delimiter = req.Delimiter
+	profile.SkipRows = req.SkipRows
+	profile.HasHeader = req.HasHeader
+	profile.DateColumn = req.DateColumn
+	profile.DateFormat = req.DateFormat
+	profile.AmountColumn = req.AmountColumn
+	profile.AmountSign = req.AmountSign
+	profile.IncomeColumn = req.IncomeColumn
+	profile.ExpenseColumn = req.ExpenseColumn
+	profile.DescriptionColumn = req.DescriptionColumn
+	profile.AccountID = req.AccountID
+	profile.DefaultIncomeCategoryID = req.DefaultIncomeCategoryID
+	profile.DefaultExpenseCategoryID = req.DefaultExpenseCategoryID
+	profile.CategoryRules = ImportCategoryRules(req.CategoryRules)
+	if profile.CategoryRules == nil {
+		profile.CategoryRules = ImportCategoryRules{}
+	}
+	return nil
+}
+
+// YYYY/MM/DD 形式の指定をGoの日付レイアウトに変換する
+func dateLayout(format string) string {
+	replacer := strings.NewReplacer(
+		"YYYY", "2006",
+		"YY", "06",
+		"MM", "01",
+		"M", "1",
+		"DD", "02",
+		"D", "2",
+	)
+	return replacer.Replace(format)
+}
+
+// 明細の日付を解釈する（時刻が付いている場合は日付部分のみ使う）
+func parseStatementDate(text, format string) (time.Time, error) {
+	text = width.Narrow.String(strings.TrimSpace(text))
+	if fields := strings.Fields(text); len(fields) > 1 {
+		text = fields[0]
+	}
+
+	if format != "" {
+		return time.Parse(dateLayout(format), text)
+	}
+	for _, layout := range statementDateLayouts {
+		if date, err := time.Parse(layout, text); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date: %q", text)
+}
+
+// 明細の金額を解釈する
+//
+// 桁区切り・通貨記号・全角数字に対応し、「△」「▲」や括弧はマイナスとして扱う。
+func parseStatementAmount(text string) (Money, error) {
+	text = width.Narrow.String(strings.TrimSpace(text))
+	negative := false
+	for _, mark := range []string{"△", "▲"} {
+		if strings.HasPrefix(text, mark) {
+			negative = true
+			text = strings.TrimPrefix(text, mark)
+		}
+	}
+	if strings.HasPrefix(text, "(") && strings.HasSuffix(text, ")") {
+		negative = true
+		text = text[1 : len(text)-1]
+	}
+	text = strings.NewReplacer(",", "", "¥", "", "\\", "", "$", "", "円", "", " ", "").Replace(text)
+
+	amount, err := parseMoney(text)
+	if err != nil {
+		return 0, err
+	}
+	if negative {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+// 文字コードをUTF-8に揃える（auto の場合はUTF-8として不正ならShift_JISとみなす）
+func decodeStatement(data []byte, encoding string) ([]byte, error) {
+	data = bytes.TrimPrefix(data, []byte("\xEF\xBB\xBF"))
+	if encoding == "utf-8" || ((encoding == "" || encoding == "auto") && utf8.Valid(data)) {
+		return data, nil
+	}
+
+	decoded, err := japanese.ShiftJIS.NewDecoder().Bytes(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode Shift_JIS: %w", err)
+	}
+	return decoded, nil
+}
+
+// 列名または1始まりの列番号から列の位置を求める
+func resolveColumn(ref string, header []string) (int, error) {
+	ref = strings.TrimSpace(ref)
+	if n, err := strconv.Atoi(ref); err == nil {
+		if n < 1 {
+			return 0, fmt.Errorf("invalid column number: %d", n)
+		}
+		return n - 1, nil
+	}
+	for i, name := range header {
+		if strings.TrimSpace(name) == ref {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("column %q not found in header", ref)
+}
+
+// 取込時のカテゴリ振り分け
+type importCategorizer struct {
+	userRules  *categoryRuleEngine // 自動振り分けルール（取込設定のルールより先に評価する）
+	payees     *payeeMatcher
+	rules      ImportCategoryRules
+	categories map[uint]Category
+	defaults   map[string]uint // 取引の種類ごとの既定カテゴリ
+}
+
+func newImportCategorizer(userID uint, profile ImportProfile) (*importCategorizer, error) {
+	var categories []Category
+	if err := db.Where("user_id = ?", userID).Find(&categories).Error; err != nil {
+		return nil, err
+	}
+
+	userRules, err := newCategoryRuleEngine(userID)
+	if err != nil {
+		return nil, err
+	}
+	payees, err := newPayeeMatcher(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	ic := &importCategorizer{
+		userRules:  userRules,
+		payees:     payees,
+		rules:      profile.CategoryRules,
+		categories: make(map[uint]Category),
+		defaults: map[string]uint{
+			"income":  profile.DefaultIncomeCategoryID,
+			"expense": profile.DefaultExpenseCategoryID,
+		},
+	}
+	for _, category := range categories {
+		ic.categories[category.ID] = category
+		// 既定カテゴリが未設定の場合は「その他」を使う
+		if category.Name == "その他収入" && ic.defaults["income"] == 0 {
+			ic.defaults["income"] = category.ID
+		}
+		if category.Name == "その他支出" && ic.defaults["expense"] == 0 {
+			ic.defaults["expense"] = category.ID
+		}
+	}
+	return ic, nil
+}
+
+// 摘要にキーワードを含む最初のルール、なければ支払先の既定カテゴリ、取込設定の既定カテゴリの順に返す
+func (ic *importCategorizer) categorize(description, transactionType string, payee *Payee) (Category, bool) {
+	lower := strings.ToLower(description)
+	for _, rule := range ic.rules {
+		if rule.Keyword != "" && strings.Contains(lower, strings.ToLower(rule.Keyword)) {
+			if category, ok := ic.categories[rule.CategoryID]; ok {
+				return category, true
+			}
+		}
+	}
+	if category, ok := payeeDefaultCategory(payee, transactionType); ok {
+		return category, true
+	}
+	category, ok := ic.categories[ic.defaults[transactionType]]
+	return category, ok
+}
+
+// CSVを取引に変換する（行ごとのエラーも返す）
+func parseStatementCSV(userID uint, profile ImportProfile, data []byte) ([]CSVImportRow, error) {
+	account, err := resolveAccount(userID, profile.AccountID)
+	if err != nil {
+		return nil, errors.New("Account not found")
+	}
+	categorizer, err := newImportCategorizer(userID, profile)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = decodeStatement(data, profile.Encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	if profile.Delimiter != "" {
+		reader.Comma, _ = utf8.DecodeRuneInString(profile.Delimiter)
+	}
+
+	for i := 0; i < profile.SkipRows; i++ {
+		if _, err := reader.Read(); err != nil {
+			return nil, fmt.Errorf("failed to skip row %d: %w", i+1, err)
+		}
+	}
+
+	var header []string
+	if profile.HasHeader {
+		if header, err = reader.Read(); err != nil {
+			return nil, fmt.Errorf("failed to read header: %w", err)
+		}
+	}
+
+	// 列の位置（-1は未使用）
+	columns := map[string]int{"date": -1, "amount": -1, "income": -1, "expense": -1, "description": -1}
+	refs := map[string]string{
+		"date":        profile.DateColumn,
+		"amount":      profile.AmountColumn,
+		"income":      profile.IncomeColumn,
+		"expense":     profile.ExpenseColumn,
+		"description": profile.DescriptionColumn,
+	}
+	for name, ref := range refs {
+		if ref == "" {
+			continue
+		}
+		if columns[name], err = resolveColumn(ref, header); err != nil {
+			return nil, err
+		}
+	}
+
+	var rows []CSVImportRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line, _ := reader.FieldPos(0)
+		if err != nil {
+			rows = append(rows, CSVImportRow{Line: line, Errors: []string{err.Error()}})
+			continue
+		}
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+
+		rows = append(rows, buildImportRow(line, record, columns, profile, account, categorizer))
+	}
+	return rows, nil
+}
+
+// 1行分の取引を組み立てる
+func buildImportRow(line int, record []string, columns map[string]int, profile ImportProfile, account Account, categorizer *importCategorizer) CSVImportRow {
+	row := CSVImportRow{Line: line}
+	field := func(name string) string {
+		i := columns[name]
+		if i < 0 || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	transaction := Transaction{
+		UserID:      account.UserID,
+		Currency:    account.Currency,
+		AccountID:   account.ID,
+		Description: field("description"),
+	}
+
+	date, err := parseStatementDate(field("date"), profile.DateFormat)
+	if err != nil {
+		row.Errors = append(row.Errors, "invalid date: "+field("date"))
+	}
+	transaction.Date = date
+
+	// 入金・出金が別の列の場合は、値のある方で種類を決める
+	if columns["amount"] < 0 {
+		income, expense := field("income"), field("expense")
+		switch {
+		case income != "" && expense == "":
+			transaction.Type = "income"
+			transaction.Amount, err = parseStatementAmount(income)
+		case expense != "" && income == "":
+			transaction.Type = "expense"
+			transaction.Amount, err = parseStatementAmount(expense)
+		default:
+			err = errors.New("exactly one of the income and expense columns must have a value")
+		}
+	} else {
+		var amount Money
+		if amount, err = parseStatementAmount(field("amount")); err == nil {
+			if profile.AmountSign == "expense_positive" {
+				amount = -amount
+			}
+			transaction.Type = "income"
+			if amount < 0 {
+				transaction.Type = "expense"
+				amount = -amount
+			}
+			transaction.Amount = amount
+		}
+	}
+	if err != nil {
+		row.Errors = append(row.Errors, "invalid amount: "+err.Error())
+	} else if transaction.Amount <= 0 {
+		row.Errors = append(row.Errors, "amount must be greater than zero")
+	} else if err := checkCurrencyUnit("amount", transaction.Amount, account.Currency); err != nil {
+		row.Errors = append(row.Errors, err.Error())
+	}
+
+	payee := categorizer.payees.apply(&transaction)
+	if transaction.Type != "" {
+		if rule := categorizer.userRules.apply(&transaction); rule != nil {
+			row.RuleID = &rule.ID
+		} else if category, ok := categorizer.categorize(transaction.Description, transaction.Type, payee); ok {
+			transaction.CategoryID = category.ID
+			transaction.Category = category
+		} else {
+			row.Errors = append(row.Errors, "no category matched and no default "+transaction.Type+" category is set")
+		}
+	}
+
+	row.Transaction = &transaction
+	return row
+}
+
+// アップロードされたCSVを取込設定（profileId または profile）か、
+// 家計簿アプリの形式（preset）で読み込んで変換する
+func readCSVImport(c *gin.Context) ([]CSVImportRow, error) {
+	userID, _ := c.Get("userID")
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxCSVImportSize)
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		return nil, errors.New("CSV file is required (field: file)")
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read uploaded file: %w", err)
+	}
+	defer src.Close()
+
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read uploaded file: %w", err)
+	}
+
+	var rows []CSVImportRow
+	if preset := c.PostForm("preset"); preset != "" {
+		accountID, _ := strconv.ParseUint(c.PostForm("accountId"), 10, 64)
+		if rows, err = parsePresetCSV(userID.(uint), preset, uint(accountID), data); err != nil {
+			return nil, err
+		}
+	} else {
+		profile, err := importProfileFromForm(c, userID.(uint))
+		if err != nil {
+			return nil, err
+		}
+		if rows, err = parseStatementCSV(userID.(uint), profile, data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := markDuplicateRows(userID.(uint), rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// 保存済みの取込設定、またはリクエストで直接指定された設定
+func importProfileFromForm(c *gin.Context, userID uint) (ImportProfile, error) {
+	var profile ImportProfile
+	if id := c.PostForm("profileId"); id != "" {
+		if err := db.Where("user_id = ?", userID).First(&profile, id).Error; err != nil {
+			return profile, errors.New("Import profile not found")
+		}
+		return profile, nil
+	}
+
+	var req ImportProfileRequest
+	if err := json.Unmarshal([]byte(c.PostForm("profile")), &req); err != nil {
+		return profile, errors.New("preset, profileId or profile (JSON) is required")
+	}
+	if err := binding.Validator.ValidateStruct(req); err != nil {
+		return profile, fmt.Errorf("invalid profile: %w", err)
+	}
+	profile.UserID = userID
+	err := applyImportProfileRequest(&profile, req)
+	return profile, err
+}
+
+// 登録済みの取引と同じ行に印を付ける
+//
+// 取込元の識別子が一致するもの、または手入力などの取引と日付・種類・金額・口座・摘要がすべて一致するものを重複とみなす。
+// 日付の近い似た取引（重複の検出と同じ条件）は possibleDuplicates で知らせ、取り込みは行う。
+func markDuplicateRows(userID uint, rows []CSVImportRow) error {
+	var from, to time.Time
+	for _, row := range rows {
+		if row.Transaction == nil || row.Transaction.Date.IsZero() {
+			continue
+		}
+		date := row.Transaction.Date
+		if from.IsZero() || date.Before(from) {
+			from = date
+		}
+		if to.IsZero() || date.After(to) {
+			to = date
+		}
+	}
+	if from.IsZero() {
+		return nil
+	}
+
+	// 重複の可能性の判定のため、前後の数日分も読み込む
+	days := defaultDuplicateCriteria.Days
+	var existing []Transaction
+	if err := db.Select("id, type, amount, account_id, to_account_id, category_id, description, date, external_id, duplicate_of_id").
+		Where("user_id = ? AND date >= ? AND date < ?", userID, from.AddDate(0, 0, -days), to.AddDate(0, 0, days+1)).
+		Find(&existing).Error; err != nil {
+		return err
+	}
+
+	contentKey := func(t Transaction) string {
+		return fmt.Sprintf("%s|%s|%d|%d|%s", t.Date.Format("2006-01-02"), t.Type, t.Amount, t.AccountID, t.Description)
+	}
+	byExternalID := make(map[string]uint)
+	byContent := make(map[string]uint)
+	for _, t := range existing {
+		if t.ExternalID != "" {
+			byExternalID[t.ExternalID] = t.ID
+			continue
+		}
+		byContent[contentKey(t)] = t.ID
+	}
+
+	for i := range rows {
+		t := rows[i].Transaction
+		if t == nil || rows[i].Skipped != "" {
+			continue
+		}
+		id, ok := byExternalID[t.ExternalID]
+		if !ok || t.ExternalID == "" {
+			id, ok = byContent[contentKey(*t)]
+		}
+		if ok {
+			rows[i].DuplicateOf = &id
+		}
+	}
+
+	markPossibleDuplicateRows(rows, existing, defaultDuplicateCriteria)
+	return nil
+}
+
+// 行ごとのエラー件数
+func countInvalidRows(rows []CSVImportRow) int {
+	invalid := 0
+	for _, row := range rows {
+		if len(row.Errors) > 0 {
+			invalid++
+		}
+	}
+	return invalid
+}
+
+// CSV取込のプレビュー（登録はしない）
+func previewCSVImport(c *gin.Context) {
+	rows, err := readCSVImport(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	invalid, skipped, duplicates, possibleDuplicates := countInvalidRows(rows), 0, 0, 0
+	for _, row := range rows {
+		if row.Skipped != "" {
+			skipped++
+		} else if row.DuplicateOf != nil && len(row.Errors) == 0 {
+			duplicates++
+		}
+		if len(row.PossibleDuplicates) > 0 {
+			possibleDuplicates++
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"rows":               rows,
+		"valid":              len(rows) - invalid - skipped - duplicates,
+		"invalid":            invalid,
+		"skipped":            skipped,
+		"duplicates":         duplicates,
+		"possibleDuplicates": possibleDuplicates,
+	})
+}
+
+// CSV取込（1行でも不正な行があれば何も登録しない）
+//
+// 取り込まない行（振替・計算対象外）と登録済みの取引と重複する行は除いて登録する。
+func commitCSVImport(c *gin.Context) {
+	rows, err := readCSVImport(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if invalid := countInvalidRows(rows); invalid > 0 {
+		var invalidRows []CSVImportRow
+		for _, row := range rows {
+			if len(row.Errors) > 0 {
+				invalidRows = append(invalidRows, row)
+			}
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rows in CSV", "rows": invalidRows})
+		return
+	}
+
+	skipped, duplicates := 0, 0
+	for _, row := range rows {
+		if row.Skipped != "" {
+			skipped++
+		} else if row.DuplicateOf != nil {
+			duplicates++
+		}
+	}
+
+	imported, createdCategories, err := saveImportRows(rows)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import transactions: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"imported":          imported,
+		"skipped":           skipped,
+		"duplicates":        duplicates,
+		"createdCategories": createdCategories,
+	})
+}
+
+// 取込行のうちエラー・除外・重複のないものを登録する
+//
+// 家計簿アプリの取込などで見つからなかったカテゴリは、ここで作成してから割り当てる。
+func saveImportRows(rows []CSVImportRow) (int, int, error) {
+	var transactions []Transaction
+	for _, row := range rows {
+		if row.Transaction != nil && len(row.Errors) == 0 && row.Skipped == "" && row.DuplicateOf == nil {
+			transactions = append(transactions, *row.Transaction)
+		}
+	}
+	if len(transactions) == 0 {
+		return 0, 0, nil
+	}
+
+	created := make(map[string]uint)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for i := range transactions {
+			t := &transactions[i]
+			if t.CategoryID != 0 || t.Type == "transfer" {
+				continue
+			}
+			key := t.Category.Type + ":" + t.Category.Name
+			if _, ok := created[key]; !ok {
+				category := t.Category
+				if err := tx.Create(&category).Error; err != nil {
+					return err
+				}
+				created[key] = category.ID
+			}
+			t.CategoryID = created[key]
+		}
+
+		if err := tx.Omit(clause.Associations).CreateInBatches(&transactions, 500).Error; err != nil {
+			return err
+		}
+
+		// 自動振り分けルールで付けたタグ
+		ids := make([]uint, 0, len(transactions))
+		for i := range transactions {
+			ids = append(ids, transactions[i].ID)
+			if len(transactions[i].Tags) == 0 {
+				continue
+			}
+			if err := replaceTransactionTags(tx, &transactions[i]); err != nil {
+				return err
+			}
+		}
+		_, err := indexTransactions(tx, ids)
+		return err
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return len(transactions), len(created), nil
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 通貨コードを正規化する（ISO 4217 の3文字英字）
+func normalizeCurrency(code string) (string, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if len(code) != 3 {
+		return "", fmt.Errorf("invalid currency code: %q", code)
+	}
+	for _, r := range code {
+		if r < 'A' || r > 'Z' {
+			return "", fmt.Errorf("invalid currency code: %q", code)
+		}
+	}
+	return code, nil
+}
+
+// 通貨コードを正規化し、未指定の場合は fallback を使う
+func currencyOrDefault(code, fallback string) (string, error) {
+	if strings.TrimSpace(code) == "" {
+		return fallback, nil
+	}
+	return normalizeCurrency(code)
+}
+
+// ユーザーの基準通貨
+func userBaseCurrency(userID interface{}) string {
+	var user User
+	if err := db.Select("base_currency").First(&user, userID).Error; err != nil || user.BaseCurrency == "" {
+		return defaultCurrency
+	}
+	return user.BaseCurrency
+}
+
+// 換算に必要な為替レートが見つからない
+type missingRateError struct {
+	From string
+	To   string
+	Date time.Time
+}
+
+func (e *missingRateError) Error() string {
+	return fmt.Sprintf("exchange rate %s/%s for %s not found", e.From, e.To, e.Date.Format("2006-01-02"))
+}
+
+// 為替換算（基準通貨への換算）
+//
+// 取引日以前で最も新しいレートを使う。逆方向のレートしかない場合は逆数を使う。
+type currencyConverter struct {
+	base  string
+	rates map[string][]ExchangeRate // "FROM/TO" ごとに日付昇順
+}
+
+// ユーザーの為替レートを読み込んで換算器を作成する
+func newCurrencyConverter(userID interface{}) (*currencyConverter, error) {
+	converter := &currencyConverter{
+		base:  userBaseCurrency(userID),
+		rates: make(map[string][]ExchangeRate),
+	}
+
+	var rates []ExchangeRate
+	if err := db.Where("user_id = ? AND (from_currency = ? OR to_currency = ?)", userID, converter.base, converter.base).
+		Order("date ASC").Find(&rates).Error; err != nil {
+		return nil, err
+	}
+	for _, rate := range rates {
+		key := rate.FromCurrency + "/" + rate.ToCurrency
+		converter.rates[key] = append(converter.rates[key], rate)
+	}
+
+	return converter, nil
+}
+
+// 指定日に有効なレート（取引日以前で最新のもの）
+func latestRate(rates []ExchangeRate, date time.Time) (ExchangeRate, bool) {
+	index := sort.Search(len(rates), func(i int) bool { return rates[i].Date.After(date) })
+	if index == 0 {
+		return ExchangeRate{}, false
+	}
+	return rates[index-1], true
+}
+
+// from 通貨の1単位が基準通貨でいくらになるか
+//
+// 同じ通貨ペアの正方向・逆方向のレートがある場合は日付の新しい方を使う。
+func (cv *currencyConverter) rate(from string, date time.Time) (float64, error) {
+	if from == "" || from == cv.base {
+		return 1, nil
+	}
+
+	// 日付の時刻部分は無視して比較する
+	day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	direct, hasDirect := latestRate(cv.rates[from+"/"+cv.base], day)
+	inverse, hasInverse := latestRate(cv.rates[cv.base+"/"+from], day)
+
+	switch {
+	case hasDirect && (!hasInverse || !inverse.Date.After(direct.Date)):
+		return direct.Rate, nil
+	case hasInverse && inverse.Rate > 0:
+		return 1 / inverse.Rate, nil
+	}
+
+	return 0, &missingRateError{From: from, To: cv.base, Date: day}
+}
+
+// 金額を基準通貨に換算する
+func (cv *currencyConverter) convert(amount Money, from string, date time.Time) (Money, error) {
+	if from == "" || from == cv.base {
+		return amount, nil
+	}
+
+	rate, err := cv.rate(from, date)
+	if err != nil {
+		return 0, err
+	}
+	return amount.Mul(rate).Round(cv.base), nil
+}
+
+// グループ・通貨・日付ごとの集計行
+type currencyDayTotal struct {
+	GroupID  uint
+	Currency string
+	Day      string
+	Total    Money
+	Count    int64
+}
+
+// 取引をグループ（例: category_id）ごとに集計し、基準通貨に換算した合計と件数を返す
+//
+// レートは日付ごとに異なるため、通貨と日付の組み合わせごとにSQLで合計してから換算する。
+// groupColumn が空の場合は全体を1グループ（キー0）として集計する。
+func (cv *currencyConverter) totalsBy(query *gorm.DB, groupColumn string) (map[uint]Money, map[uint]int64, error) {
+	day := sqlDateString("date")
+	selectGroup := "0"
+	groupBy := "currency, " + day
+	if groupColumn != "" {
+		selectGroup = groupColumn
+		groupBy = groupColumn + ", " + groupBy
+	}
+
+	var rows []currencyDayTotal
+	if err := query.Select(selectGroup + " as group_id, currency, " + day + " as day, COALESCE(SUM(amount), 0) as total, COUNT(*) as count").
+		Group(groupBy).Scan(&rows).Error; err != nil {
+		return nil, nil, err
+	}
+
+	totals := make(map[uint]Money)
+	counts := make(map[uint]int64)
+	for _, row := range rows {
+		date, _ := time.Parse("2006-01-02", row.Day)
+		amount, err := cv.convert(row.Total, row.Currency, date)
+		if err != nil {
+			return nil, nil, err
+		}
+		totals[row.GroupID] += amount
+		counts[row.GroupID] += row.Count
+	}
+
+	return totals, counts, nil
+}
+
+// 取引の合計を基準通貨で返す
+func (cv *currencyConverter) sum(query *gorm.DB) (Money, error) {
+	totals, _, err := cv.totalsBy(query, "")
+	if err != nil {
+		return 0, err
+	}
+	return totals[0], nil
+}
+
+// 有効な固定支出の合計を基準通貨で返す（date 時点のレートで換算）
+func (cv *currencyConverter) sumFixedExpenses(userID interface{}, date time.Time) (Money, error) {
+	var rows []currencyDayTotal
+	if err := db.Model(&FixedExpense{}).Where("user_id = ? AND type = ? AND is_active = ?", userID, "expense", true).
+		Select("currency, COALESCE(SUM(amount), 0) as total").Group("currency").Scan(&rows).Error; err != nil {
+		return 0, err
+	}
+
+	var total Money
+	for _, row := range rows {
+		amount, err := cv.convert(row.Total, row.Currency, date)
+		if err != nil {
+			return 0, err
+		}
+		total += amount
+	}
+	return total, nil
+}
+
+// 換算エラーをレスポンスに変換する
+func respondAggregationError(c *gin.Context, err error) {
+	var missing *missingRateError
+	if errors.As(err, &missing) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to aggregate transactions: " + err.Error()})
+}
+
+// 為替レート関連ハンドラー
+
+// 為替レート一覧取得
+func getExchangeRates(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	var rates []ExchangeRate
+
+	query := db.Where("user_id = ?", userID).Order("date DESC, from_currency ASC, to_currency ASC")
+	if currency := c.Query("currency"); currency != "" {
+		code := strings.ToUpper(currency)
+		query = query.Where("from_currency = ? OR to_currency = ?", code, code)
+	}
+	if startDate, err := time.Parse("2006-01-02", c.Query("startDate")); err == nil {
+		query = query.Where("date >= ?", startDate)
+	}
+	if endDate, err := time.Parse("2006-01-02", c.Query("endDate")); err == nil {
+		query = query.Where("date <= ?", endDate)
+	}
+
+	if err := query.Find(&rates).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch exchange rates: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, rates)
+}
+
+// 為替レート登録（同じ日付・通貨ペアがあれば更新）
+func createExchangeRate(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	var req ExchangeRateRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+
+	rate, err := buildExchangeRate(userID.(uint), req.Date, req.FromCurrency, req.ToCurrency, req.Rate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := upsertExchangeRates(db, []ExchangeRate{rate}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save exchange rate: " + err.Error()})
+		return
+	}
+
+	var saved ExchangeRate
+	db.Where("user_id = ? AND date = ? AND from_currency = ? AND to_currency = ?",
+		rate.UserID, rate.Date, rate.FromCurrency, rate.ToCurrency).First(&saved)
+	c.JSON(http.StatusCreated, saved)
+}
+
+// 為替レートのCSVアップロード
+//
+// 形式: date,from,to,rate（例: 2026-01-05,USD,JPY,157.32）。ヘッダー行は省略可。
+// 1行でも不正な行があれば何も登録しない。
+func uploadExchangeRates(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "CSV file is required (field: file)"})
+		return
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read uploaded file: " + err.Error()})
+		return
+	}
+	defer src.Close()
+
+	rates, rowErrors, err := parseExchangeRateCSV(userID.(uint), src)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(rowErrors) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rows in CSV", "rows": rowErrors})
+		return
+	}
+
+	if err := upsertExchangeRates(db, rates); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save exchange rates: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"imported": len(rates)})
+}
+
+// 為替レート削除
+func deleteExchangeRate(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	id := c.Param("id")
+
+	if err := db.Where("user_id = ?", userID).Delete(&ExchangeRate{}, id).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete exchange rate: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Exchange rate deleted successfully"})
+}
+
+// 基準通貨の変更
+func updateBaseCurrency(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var req struct {
+		BaseCurrency string `json:"baseCurrency" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+
+	currency, err := normalizeCurrency(req.BaseCurrency)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user User
+	if err := db.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "ユーザーが見つかりません"})
+		return
+	}
+
+	user.BaseCurrency = currency
+	if err := db.Model(&user).Update("base_currency", currency).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update base currency: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+func buildExchangeRate(userID uint, dateText, from, to string, value float64) (ExchangeRate, error) {
+	date, err := time.Parse("2006-01-02", strings.TrimSpace(dateText))
+	if err != nil {
+		return ExchangeRate{}, fmt.Errorf("invalid date: %q (expected YYYY-MM-DD)", dateText)
+	}
+	fromCode, err := normalizeCurrency(from)
+	if err != nil {
+		return ExchangeRate{}, err
+	}
+	toCode, err := normalizeCurrency(to)
+	if err != nil {
+		return ExchangeRate{}, err
+	}
+	if fromCode == toCode {
+		return ExchangeRate{}, fmt.Errorf("fromCurrency and toCurrency must differ")
+	}
+	if value <= 0 {
+		return ExchangeRate{}, fmt.Errorf("rate must be positive")
+	}
+
+	return ExchangeRate{
+		UserID:       userID,
+		Date:         date,
+		FromCurrency: fromCode,
+		ToCurrency:   toCode,
+		Rate:         value,
+	}, nil
+}
+
+func parseExchangeRateCSV(userID uint, r io.Reader) ([]ExchangeRate, []gin.H, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse CSV: %w", err)
+	}
+
+	// 列の位置（ヘッダーがあれば列名で決める）
+	columns := map[string]int{"date": 0, "from": 1, "to": 2, "rate": 3}
+	start := 0
+	if len(records) > 0 {
+		if _, err := time.Parse("2006-01-02", strings.TrimPrefix(strings.TrimSpace(records[0][0]), "\ufeff")); err != nil {
+			for index, name := range records[0] {
+				switch strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))) {
+				case "date":
+					columns["date"] = index
+				case "from", "fromcurrency", "from_currency", "currency":
+					columns["from"] = index
+				case "to", "tocurrency", "to_currency":
+					columns["to"] = index
+				case "rate":
+					columns["rate"] = index
+				}
+			}
+			start = 1
+		}
+	}
+
+	var rates []ExchangeRate
+	var rowErrors []gin.H
+	for i := start; i < len(records); i++ {
+		record := records[i]
+		field := func(name string) string {
+			if columns[name] < len(record) {
+				return strings.TrimSpace(record[columns[name]])
+			}
+			return ""
+		}
+
+		value, err := strconv.ParseFloat(field("rate"), 64)
+		if err != nil {
+			rowErrors = append(rowErrors, gin.H{"line": i + 1, "error": "invalid rate: " + field("rate")})
+			continue
+		}
+		rate, err := buildExchangeRate(userID, field("date"), field("from"), field("to"), value)
+		if err != nil {
+			rowErrors = append(rowErrors, gin.H{"line": i + 1, "error": err.Error()})
+			continue
+		}
+		rates = append(rates, rate)
+	}
+
+	return rates, rowErrors, nil
+}
+
+// 同じユーザー・日付・通貨ペアのレートは上書きする
+func upsertExchangeRates(tx *gorm.DB, rates []ExchangeRate) error {
+	if len(rates) == 0 {
+		return nil
+	}
+
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "date"}, {Name: "from_currency"}, {Name: "to_currency"}},
+		DoUpdates: clause.AssignmentColumns([]string{"rate", "updated_at"}),
+	}).CreateInBatches(rates, 500).Error
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// 対応しているデータベースドライバー
+const (
+	driverSQLite   = "sqlite"
+	driverMySQL    = "mysql"
+	driverPostgres = "postgres"
+)
+
+// 現在接続しているデータベースのドライバー名
+var dbDriver = driverSQLite
+
+// データベース接続設定
+type dbConfig struct {
+	Driver string
+	DSN    string
+}
+
+// 環境変数からデータベース接続設定を組み立てる
+//
+// DB_DRIVER が未指定の場合、MYSQL_HOST / POSTGRES_HOST が設定されていれば
+// それぞれのドライバーを使い、どちらもなければSQLiteを使う。
+// DATABASE_DSN を指定した場合は個別の接続情報より優先する。
+func loadDBConfig() (dbConfig, error) {
+	driver := strings.ToLower(strings.TrimSpace(os.Getenv("DB_DRIVER")))
+	if driver == "" {
+		switch {
+		case os.Getenv("MYSQL_HOST") != "":
+			driver = driverMySQL
+		case os.Getenv("POSTGRES_HOST") != "":
+			driver = driverPostgres
+		default:
+			driver = driverSQLite
+		}
+	}
+	if driver == "sqlite3" {
+		driver = driverSQLite
+	}
+	if driver == "postgresql" || driver == "pgsql" {
+		driver = driverPostgres
+	}
+
+	config := dbConfig{Driver: driver, DSN: os.Getenv("DATABASE_DSN")}
+	if config.DSN != "" {
+		return config, nil
+	}
+
+	switch driver {
+	case driverSQLite:
+		config.DSN = getEnv("SQLITE_PATH", "moneytracker.db")
+	case driverMySQL:
+		// 日付はUTCで読み書きし、どのバックエンドでも同じ集計結果になるようにする
+		config.DSN = fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8mb4&parseTime=True&loc=UTC",
+			getEnv("MYSQL_USER", "root"),
+			os.Getenv("MYSQL_PASSWORD"),
+			getEnv("MYSQL_HOST", "localhost"),
+			getEnv("MYSQL_PORT", "3306"),
+			getEnv("MYSQL_DATABASE", "moneytracker"),
+		)
+	case driverPostgres:
+		config.DSN = fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s TimeZone=UTC",
+			getEnv("POSTGRES_HOST", "localhost"),
+			getEnv("POSTGRES_PORT", "5432"),
+			getEnv("POSTGRES_USER", "postgres"),
+			os.Getenv("POSTGRES_PASSWORD"),
+			getEnv("POSTGRES_DB", "moneytracker"),
+			getEnv("POSTGRES_SSLMODE", "disable"),
+		)
+	default:
+		return config, fmt.Errorf("unsupported DB_DRIVER: %s", driver)
+	}
+
+	return config, nil
+}
+
+// 設定に応じたGORMダイアレクターを返す
+func (config dbConfig) dialector() gorm.Dialector {
+	switch config.Driver {
+	case driverMySQL:
+		return mysql.Open(config.DSN)
+	case driverPostgres:
+		return postgres.Open(config.DSN)
+	default:
+		return sqlite.Open(config.DSN)
+	}
+}
+
+// データベースへ接続する
+func openDatabase(config dbConfig) (*gorm.DB, error) {
+	conn, err := gorm.Open(config.dialector(), &gorm.Config{
+		NowFunc: func() time.Time { return time.Now().UTC() },
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if config.Driver != driverSQLite {
+		sqlDB, err := conn.DB()
+		if err != nil {
+			return nil, err
+		}
+		sqlDB.SetMaxOpenConns(20)
+		sqlDB.SetMaxIdleConns(5)
+		sqlDB.SetConnMaxLifetime(time.Hour)
+	}
+
+	return conn, nil
+}
+
+// 日付カラムを YYYY-MM-DD 形式の文字列として取り出すSQL式
+//
+// SQLiteのDATE()は保存されたタイムゾーンでUTCに変換してしまうため、
+// 保存時の日付部分をそのまま取り出す。
+func sqlDateString(column string) string {
+	switch dbDriver {
+	case driverMySQL:
+		return fmt.Sprintf("DATE_FORMAT(%s, '%%Y-%%m-%%d')", column)
+	case driverPostgres:
+		return fmt.Sprintf("TO_CHAR(%s, 'YYYY-MM-DD')", column)
+	default:
+		return fmt.Sprintf("SUBSTR(%s, 1, 10)", column)
+	}
+}
+
+// YYYY-MM-DD 形式の期間指定を [開始日, 終了日の翌日) の範囲に変換する
+//
+// 文字列のまま BETWEEN で比較するとバックエンドごとに終了日の扱いが
+// 異なるため、常に時刻付きの半開区間で比較する。
+func parseDateRange(startDate, endDate string) (time.Time, time.Time, error) {
+	start, err := time.Parse("2006-01-02", startDate)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid startDate: %s", startDate)
+	}
+	end, err := time.Parse("2006-01-02", endDate)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("invalid endDate: %s", endDate)
+	}
+	return start, end.AddDate(0, 0, 1), nil
+}
+
+// 環境変数を取得し、未設定の場合はデフォルト値を返す
+func getEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// 環境変数を時間（例: 15m, 720h）として取得し、未設定・不正な場合はデフォルト値を返す
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		log.Printf("Invalid %s %q, using %v", key, value, fallback)
+		return fallback
+	}
+	return duration
+}
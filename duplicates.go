@@ -0,0 +1,353 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/width"
+	"gorm.io/gorm"
+)
+
+// 重複の判定条件
+type duplicateCriteria struct {
+	Days          int     // 日付の差の上限（日）
+	MinSimilarity float64 // 説明の類似度の下限（0〜1）
+}
+
+var defaultDuplicateCriteria = duplicateCriteria{Days: 3, MinSimilarity: 0.5}
+
+// 2件の取引が重複している可能性があるか
+//
+// 種類・金額・カテゴリ（振替は口座）が同じで、日付が近く、説明が似ているものを重複とみなす。
+// 同じ取込元の別の明細（externalId が異なる）は、内容が同じでも別の取引として扱う。
+func (dc duplicateCriteria) match(a, b Transaction) (float64, bool) {
+	if a.Type != b.Type || a.Amount != b.Amount || a.CategoryID != b.CategoryID {
+		return 0, false
+	}
+	if a.Type == "transfer" && (a.AccountID != b.AccountID || !sameAccountID(a.ToAccountID, b.ToAccountID)) {
+		return 0, false
+	}
+	if days := math.Abs(a.Date.Sub(b.Date).Hours() / 24); days > float64(dc.Days) {
+		return 0, false
+	}
+	if a.ExternalID != "" && b.ExternalID != "" && externalSource(a.ExternalID) == externalSource(b.ExternalID) {
+		return 0, false
+	}
+
+	similarity := descriptionSimilarity(a.Description, b.Description)
+	return similarity, similarity >= dc.MinSimilarity
+}
+
+func sameAccountID(a, b *uint) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// externalId の取込元（moneyforward:xxx → moneyforward）
+func externalSource(externalID string) string {
+	source, _, _ := strings.Cut(externalID, ":")
+	return source
+}
+
+// 説明の類似度（0〜1）
+//
+// 全角・半角、大文字・小文字、空白と記号の違いは無視し、文字の2-gramの一致率で比べる。
+// 一方が他方を含む場合は 1、一方だけが空の場合は 0.5 とする。
+func descriptionSimilarity(a, b string) float64 {
+	a, b = normalizeDescription(a), normalizeDescription(b)
+	switch {
+	case a == b:
+		return 1
+	case a == "" || b == "":
+		return 0.5
+	case strings.Contains(a, b) || strings.Contains(b, a):
+		return 1
+	}
+
+	bigrams := func(s string) map[string]int {
+		runes := []rune(s)
+		grams := make(map[string]int)
+		if len(runes) == 1 {
+			grams[s]++
+		}
+		for i := 0; i+1 < len(runes); i++ {
+			grams[string(runes[i:i+2])]++
+		}
+		return grams
+	}
+	ga, gb := bigrams(a), bigrams(b)
+	total, common := 0, 0
+	for gram, n := range ga {
+		total += n
+		common += min(n, gb[gram])
+	}
+	for _, n := range gb {
+		total += n
+	}
+	return 2 * float64(common) / float64(total)
+}
+
+func normalizeDescription(text string) string {
+	text = strings.ToLower(width.Fold.String(text))
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) || unicode.IsPunct(r) || unicode.IsSymbol(r) {
+			return -1
+		}
+		return r
+	}, text)
+}
+
+// 重複の候補（同じ取引と思われるもののまとまり）
+type duplicateGroup struct {
+	Transactions []Transaction `json:"transactions"`
+	Similarity   float64       `json:"similarity"` // まとまり内の組の類似度の最小値
+}
+
+// 取引の中から重複の候補をまとめる
+//
+// transactions は日付の昇順であること。重複として確認済みの取引は対象外。
+func findDuplicateGroups(transactions []Transaction, dc duplicateCriteria) []duplicateGroup {
+	// 似ている組を順につなげる（A≒B, B≒C なら A,B,C を1つのまとまりにする）
+	parent := make([]int, len(transactions))
+	for i := range parent {
+		parent[i] = i
+	}
+	var root func(int) int
+	root = func(i int) int {
+		for parent[i] != i {
+			parent[i] = parent[parent[i]]
+			i = parent[i]
+		}
+		return i
+	}
+
+	similarity := make(map[int]float64)
+	for i := range transactions {
+		if transactions[i].DuplicateOfID != nil {
+			continue
+		}
+		for j := i + 1; j < len(transactions); j++ {
+			if transactions[j].Date.Sub(transactions[i].Date).Hours()/24 > float64(dc.Days) {
+				break
+			}
+			if transactions[j].DuplicateOfID != nil {
+				continue
+			}
+			score, ok := dc.match(transactions[i], transactions[j])
+			if !ok {
+				continue
+			}
+			ri, rj := root(i), root(j)
+			minScore := score
+			for _, r := range []int{ri, rj} {
+				if s, ok := similarity[r]; ok && s < minScore {
+					minScore = s
+				}
+			}
+			parent[rj] = ri
+			delete(similarity, rj)
+			similarity[ri] = minScore
+		}
+	}
+
+	members := make(map[int][]Transaction)
+	var roots []int
+	for i := range transactions {
+		r := root(i)
+		if _, ok := similarity[r]; !ok {
+			continue
+		}
+		if _, ok := members[r]; !ok {
+			roots = append(roots, r)
+		}
+		members[r] = append(members[r], transactions[i])
+	}
+
+	groups := make([]duplicateGroup, 0, len(roots))
+	for _, r := range roots {
+		groups = append(groups, duplicateGroup{Transactions: members[r], Similarity: math.Round(similarity[r]*100) / 100})
+	}
+	return groups
+}
+
+// クエリパラメータから判定条件を作る（days, similarity）
+func duplicateCriteriaFromQuery(c *gin.Context) (duplicateCriteria, error) {
+	dc := defaultDuplicateCriteria
+	if value := c.Query("days"); value != "" {
+		days, err := strconv.Atoi(value)
+		if err != nil || days < 0 || days > 31 {
+			return dc, errors.New("days must be between 0 and 31")
+		}
+		dc.Days = days
+	}
+	if value := c.Query("similarity"); value != "" {
+		similarity, err := strconv.ParseFloat(value, 64)
+		if err != nil || similarity < 0 || similarity > 1 {
+			return dc, errors.New("similarity must be between 0 and 1")
+		}
+		dc.MinSimilarity = similarity
+	}
+	return dc, nil
+}
+
+// 重複の可能性がある取引の一覧
+//
+// 取引一覧と同じ条件（type, categoryId, accountId, startDate, endDate）で絞り込める。
+func getDuplicateTransactions(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	dc, err := duplicateCriteriaFromQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var transactions []Transaction
+	query := filterTransactions(db.Preload("Category").Where("user_id = ?", userID), c)
+	if err := query.Order("date ASC, id ASC").Find(&transactions).Error; err != nil {
+		respondFilterError(c, "Failed to load transactions", err)
+		return
+	}
+
+	groups := findDuplicateGroups(transactions, dc)
+	c.JSON(http.StatusOK, gin.H{
+		"groups":     groups,
+		"count":      len(groups),
+		"days":       dc.Days,
+		"similarity": dc.MinSimilarity,
+	})
+}
+
+// 取込予定の行と登録済みの取引を比べ、重複の可能性がある取引を行に記録する
+func markPossibleDuplicateRows(rows []CSVImportRow, existing []Transaction, dc duplicateCriteria) {
+	for i := range rows {
+		t := rows[i].Transaction
+		if t == nil || rows[i].Skipped != "" || rows[i].DuplicateOf != nil || t.Date.IsZero() {
+			continue
+		}
+		for _, e := range existing {
+			if e.DuplicateOfID != nil {
+				continue
+			}
+			if _, ok := dc.match(*t, e); ok {
+				rows[i].PossibleDuplicates = append(rows[i].PossibleDuplicates, e.ID)
+			}
+		}
+	}
+}
+
+var errMergeNotFound = errors.New("Transaction not found")
+
+// 重複取引の統合
+//
+// keepId の取引を残し、duplicateIds の取引を削除する（action=delete）。
+// action=annotate の場合は削除せず、残した取引を duplicateOfId に記録して以後の検出から外す。
+// 削除する取引の externalId と説明は、残す取引に無ければ引き継ぐ（再取込で重複しないように）。タグと添付ファイルは残す取引に付け直す。
+func mergeTransactions(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var req TransactionMergeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+	if req.Action == "" {
+		req.Action = "delete"
+	}
+
+	ids := make(map[uint]bool)
+	for _, id := range req.DuplicateIDs {
+		if id == req.KeepID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "duplicateIds must not include keepId"})
+			return
+		}
+		ids[id] = true
+	}
+	duplicateIDs := make([]uint, 0, len(ids))
+	for id := range ids {
+		duplicateIDs = append(duplicateIDs, id)
+	}
+	sort.Slice(duplicateIDs, func(i, j int) bool { return duplicateIDs[i] < duplicateIDs[j] })
+
+	var keep Transaction
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).First(&keep, req.KeepID).Error; err != nil {
+			return errMergeNotFound
+		}
+		var duplicates []Transaction
+		if err := tx.Where("user_id = ? AND id IN ?", userID, duplicateIDs).Order("id").Find(&duplicates).Error; err != nil {
+			return err
+		}
+		if len(duplicates) != len(duplicateIDs) {
+			return errMergeNotFound
+		}
+
+		if req.Action == "annotate" {
+			return tx.Model(&Transaction{}).Where("id IN ?", duplicateIDs).Update("duplicate_of_id", keep.ID).Error
+		}
+
+		updates := map[string]interface{}{}
+		for _, d := range duplicates {
+			if keep.ExternalID == "" && d.ExternalID != "" {
+				keep.ExternalID = d.ExternalID
+				updates["external_id"] = d.ExternalID
+			}
+			if keep.Description == "" && d.Description != "" {
+				keep.Description = d.Description
+				updates["description"] = d.Description
+			}
+		}
+		if len(updates) > 0 {
+			if err := tx.Model(&keep).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+
+		// 削除する取引を指していた記録は残す取引に付け替える
+		if err := tx.Model(&Transaction{}).Where("duplicate_of_id IN ?", duplicateIDs).Update("duplicate_of_id", keep.ID).Error; err != nil {
+			return err
+		}
+		// 削除する取引のタグは残す取引に付ける
+		if err := tx.Exec(`INSERT INTO transaction_tags (transaction_id, tag_id)
+			SELECT DISTINCT ?, tag_id FROM transaction_tags
+			WHERE transaction_id IN ? AND tag_id NOT IN (SELECT tag_id FROM transaction_tags WHERE transaction_id = ?)`,
+			keep.ID, duplicateIDs, keep.ID).Error; err != nil {
+			return err
+		}
+		// 添付ファイルは残す取引に移す
+		if err := tx.Model(&Attachment{}).Where("transaction_id IN ?", duplicateIDs).Update("transaction_id", keep.ID).Error; err != nil {
+			return err
+		}
+		if _, err := deleteTransactionDetails(tx, duplicateIDs); err != nil {
+			return err
+		}
+		if err := tx.Where("id IN ?", duplicateIDs).Delete(&Transaction{}).Error; err != nil {
+			return err
+		}
+		_, err := indexTransactions(tx, []uint{keep.ID})
+		return err
+	})
+	if errors.Is(err, errMergeNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to merge transactions: " + err.Error()})
+		return
+	}
+
+	db.Preload("Category").Preload("Tags").First(&keep, keep.ID)
+	c.JSON(http.StatusOK, gin.H{
+		"transaction": keep,
+		"action":      req.Action,
+		"merged":      len(duplicateIDs),
+	})
+}
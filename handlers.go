@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"math"
@@ -11,83 +12,169 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 // 取引一覧取得
+//
+// q を指定した場合は説明・支払先・タグ・カテゴリ名を全文検索し、一致箇所を search に付ける（sort=relevance または date）。
 func getTransactions(c *gin.Context) {
 	userID, _ := c.Get("userID")
 	var transactions []Transaction
 
 	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
 	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
-	transactionType := c.Query("type")
-	categoryId := c.Query("categoryId")
-	startDate := c.Query("startDate")
-	endDate := c.Query("endDate")
+	offset := (page - 1) * limit
 
-	query := db.Preload("Category").Where("user_id = ?", userID).Order("date DESC, created_at DESC")
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		terms, err := parseSearchQuery(q)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		query := filterTransactions(db.Model(&Transaction{}).Where("user_id = ?", userID), c)
+		results, err := searchTransactions(c, query, terms, offset, limit)
+		if err != nil {
+			respondFilterError(c, "Failed to search transactions", err)
+			return
+		}
+		c.JSON(http.StatusOK, results)
+		return
+	}
 
-	if transactionType != "" {
+	query := db.Preload("Category").Preload("Splits.Category").Preload("Tags").Where("user_id = ?", userID).Order("date DESC, created_at DESC")
+	query = filterTransactions(query, c)
+
+	if err := query.Offset(offset).Limit(limit).Find(&transactions).Error; err != nil {
+		respondFilterError(c, "Failed to fetch transactions", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, transactions)
+}
+
+// 絞り込み条件の誤り
+type filterError struct {
+	message string
+}
+
+func (e *filterError) Error() string { return e.message }
+
+// 絞り込み条件の誤りは 400、それ以外は 500 を返す
+func respondFilterError(c *gin.Context, message string, err error) {
+	var invalid *filterError
+	if errors.As(err, &invalid) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": invalid.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message + ": " + err.Error()})
+}
+
+// 取引一覧の絞り込み条件（type, categoryId, accountId, payeeId, minAmount, maxAmount, startDate, endDate, tagId, tag）
+//
+// 金額は取引の通貨のまま比べる。
+func filterTransactions(query *gorm.DB, c *gin.Context) *gorm.DB {
+	if transactionType := c.Query("type"); transactionType != "" {
 		query = query.Where("type = ?", transactionType)
 	}
-	if categoryId != "" {
-		query = query.Where("category_id = ?", categoryId)
+	if categoryId := c.Query("categoryId"); categoryId != "" {
+		// 内訳のカテゴリも対象にする
+		query = query.Where("(category_id = ? OR id IN (?))", categoryId,
+			db.Model(&TransactionSplit{}).Select("transaction_id").Where("category_id = ?", categoryId))
 	}
-	if startDate != "" {
-		query = query.Where("date >= ?", startDate)
+	if accountId := c.Query("accountId"); accountId != "" {
+		query = query.Where("(account_id = ? OR to_account_id = ?)", accountId, accountId)
 	}
-	if endDate != "" {
-		query = query.Where("date <= ?", endDate)
+	for param, operator := range map[string]string{"minAmount": ">=", "maxAmount": "<="} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		amount, err := parseMoney(value)
+		if err != nil {
+			query.AddError(&filterError{message: "Invalid " + param + ": " + value})
+			continue
+		}
+		query = query.Where("amount "+operator+" ?", amount)
+	}
+	if payeeId := c.Query("payeeId"); payeeId == "0" {
+		query = query.Where("payee_id IS NULL")
+	} else if payeeId != "" {
+		query = query.Where("payee_id = ?", payeeId)
+	}
+	// 集計と同じく終了日はその日を含める（翌日0時より前）。片方だけの指定もできる
+	if startDate, endDate := c.Query("startDate"), c.Query("endDate"); startDate != "" || endDate != "" {
+		from, to := startDate, endDate
+		if from == "" {
+			from = "0001-01-01"
+		}
+		if to == "" {
+			to = "9999-12-30"
+		}
+		rangeStart, rangeEnd, err := parseDateRange(from, to)
+		if err != nil {
+			query.AddError(&filterError{message: err.Error()})
+		} else {
+			if startDate != "" {
+				query = query.Where("date >= ?", rangeStart)
+			}
+			if endDate != "" {
+				query = query.Where("date < ?", rangeEnd)
+			}
+		}
 	}
-
-	offset := (page - 1) * limit
-	query.Offset(offset).Limit(limit).Find(&transactions)
-
-	c.JSON(http.StatusOK, transactions)
+	return filterTransactionsByTag(query, c)
 }
 
 func createTransaction(c *gin.Context) {
 	userID, _ := c.Get("userID")
 
-	// リクエストデータを受け取るための構造体
-	var req struct {
-		Type        string  `json:"type" binding:"required"`
-		Amount      float64 `json:"amount" binding:"required"`
-		CategoryID  uint    `json:"categoryId" binding:"required"`
-		Description string  `json:"description"`
-		Date        string  `json:"date" binding:"required"`
-	}
-
+	var req TransactionRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
 		return
 	}
 
-	// 日付文字列をtime.Timeに変換（YYYY-MM-DD形式を優先）
-	date, err := time.Parse("2006-01-02", req.Date)
+	// カテゴリの指定がなければ自動振り分けルールで決める
+	if req.Type != "transfer" && req.CategoryID == 0 && len(req.Splits) == 0 {
+		engine, err := newCategoryRuleEngine(userID.(uint))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load category rules: " + err.Error()})
+			return
+		}
+		if rule := engine.match(req.Type, req.Amount, req.Description); rule != nil {
+			req.CategoryID = rule.CategoryID
+			req.Tags = append(req.Tags, rule.Tags...)
+		}
+	}
+
+	// 支払先（指定がなければ説明から決める）。ルールにも一致しなければ支払先の既定カテゴリを使う
+	payee, err := resolvePayee(userID.(uint), req.PayeeID, req.Description)
 	if err != nil {
-		log.Printf("Failed to parse date '%s' with YYYY-MM-DD format: %v", req.Date, err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date format. Expected YYYY-MM-DD format. Received: " + req.Date})
+		respondPayeeError(c, err)
 		return
 	}
+	if req.Type != "transfer" && req.CategoryID == 0 && len(req.Splits) == 0 {
+		if category, ok := payeeDefaultCategory(payee, req.Type); ok {
+			req.CategoryID = category.ID
+		}
+	}
 
 	// Transactionオブジェクトを作成
-	transaction := Transaction{
-		UserID:      userID.(uint),
-		Type:        req.Type,
-		Amount:      req.Amount,
-		CategoryID:  req.CategoryID,
-		Description: req.Description,
-		Date:        date,
+	transaction := Transaction{UserID: userID.(uint)}
+	if status, err := applyTransactionRequest(&transaction, req); err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
 	}
+	setTransactionPayee(&transaction, payee)
 
-	if err := db.Create(&transaction).Error; err != nil {
+	if err := saveTransaction(&transaction); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create transaction: " + err.Error()})
 		return
 	}
 
 	// カテゴリ情報を含めて返す
-	db.Preload("Category").First(&transaction, transaction.ID)
+	db.Preload("Category").Preload("Splits.Category").Preload("Tags").First(&transaction, transaction.ID)
 	c.JSON(http.StatusCreated, transaction)
 }
 
@@ -96,58 +183,175 @@ func updateTransaction(c *gin.Context) {
 	id := c.Param("id")
 	var transaction Transaction
 
-	if err := db.Where("user_id = ?", userID).First(&transaction, id).Error; err != nil {
+	// タグ・内訳の指定がなければ変更しないため、今のタグ・内訳を読み込んでおく
+	if err := db.Preload("Tags").Preload("Splits", func(tx *gorm.DB) *gorm.DB { return tx.Order("id") }).Where("user_id = ?", userID).First(&transaction, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
 		return
 	}
 
-	// リクエストデータを受け取るための構造体
-	var req struct {
-		Type        string  `json:"type" binding:"required"`
-		Amount      float64 `json:"amount" binding:"required"`
-		CategoryID  uint    `json:"categoryId" binding:"required"`
-		Description string  `json:"description"`
-		Date        string  `json:"date" binding:"required"`
-	}
-
+	var req TransactionRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
 		return
 	}
 
+	// 口座の指定がなければ変更しない
+	if req.AccountID == 0 {
+		req.AccountID = transaction.AccountID
+	}
+
+	// 支払先の指定がなく説明も変わらなければ支払先は変更しない
+	payeeChanged := req.PayeeID != nil || req.Description != transaction.Description
+	var payee *Payee
+	if payeeChanged {
+		var err error
+		if payee, err = resolvePayee(userID.(uint), req.PayeeID, req.Description); err != nil {
+			respondPayeeError(c, err)
+			return
+		}
+	}
+
+	// Transactionオブジェクトを更新
+	if status, err := applyTransactionRequest(&transaction, req); err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	if payeeChanged {
+		setTransactionPayee(&transaction, payee)
+	}
+
+	if err := saveTransaction(&transaction); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update transaction: " + err.Error()})
+		return
+	}
+
+	// カテゴリ情報を含めて返す
+	db.Preload("Category").Preload("Splits.Category").Preload("Tags").First(&transaction, transaction.ID)
+	c.JSON(http.StatusOK, transaction)
+}
+
+// リクエスト内容を検証して取引に反映する
+//
+// 通貨は口座の通貨に合わせる。振替の場合は振替先口座と振替先通貨での金額を設定する。
+func applyTransactionRequest(transaction *Transaction, req TransactionRequest) (int, error) {
 	// 日付文字列をtime.Timeに変換（YYYY-MM-DD形式を優先）
 	date, err := time.Parse("2006-01-02", req.Date)
 	if err != nil {
 		log.Printf("Failed to parse date '%s' with YYYY-MM-DD format: %v", req.Date, err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date format. Expected YYYY-MM-DD format. Received: " + req.Date})
-		return
+		return http.StatusBadRequest, fmt.Errorf("Invalid date format. Expected YYYY-MM-DD format. Received: %s", req.Date)
+	}
+
+	account, err := resolveAccount(transaction.UserID, req.AccountID)
+	if err != nil {
+		return http.StatusBadRequest, fmt.Errorf("Account not found")
+	}
+
+	// 通貨は口座の通貨と一致している必要がある
+	currency, err := currencyOrDefault(req.Currency, account.Currency)
+	if err != nil {
+		return http.StatusBadRequest, err
+	}
+	if currency != account.Currency {
+		return http.StatusBadRequest, fmt.Errorf("Currency %s does not match account currency %s", currency, account.Currency)
+	}
+	if err := checkCurrencyUnit("amount", req.Amount, account.Currency); err != nil {
+		return http.StatusBadRequest, err
 	}
 
-	// Transactionオブジェクトを更新
 	transaction.Type = req.Type
 	transaction.Amount = req.Amount
-	transaction.CategoryID = req.CategoryID
+	transaction.Currency = account.Currency
+	transaction.AccountID = account.ID
 	transaction.Description = req.Description
 	transaction.Date = date
+	if req.Tags != nil {
+		transaction.Tags = tagsFromNames(req.Tags)
+	}
 
-	if err := db.Save(&transaction).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update transaction: " + err.Error()})
-		return
+	if req.Type != "transfer" {
+		transaction.ToAccountID = nil
+		transaction.ToAmount = 0
+
+		// 内訳の指定がなければ今の内訳を残す（金額・種類の変更に合うかは検証し直す）
+		splits := req.Splits
+		keptSplits := splits == nil && len(transaction.Splits) > 0
+		if splits == nil {
+			for _, split := range transaction.Splits {
+				splits = append(splits, TransactionSplitRequest{CategoryID: split.CategoryID, Amount: split.Amount, Memo: split.Memo})
+			}
+		}
+		// 内訳がある場合、カテゴリは内訳から決める
+		if len(splits) > 0 {
+			if status, err := applyTransactionSplits(transaction, splits); err != nil {
+				return status, err
+			}
+			// 残した内訳と違うカテゴリが指定された場合、黙って無視しない
+			if keptSplits && req.CategoryID != 0 && req.CategoryID != transaction.CategoryID {
+				return http.StatusBadRequest, fmt.Errorf("categoryId %d conflicts with the existing splits (category %d); send \"splits\": [] to remove the splits", req.CategoryID, transaction.CategoryID)
+			}
+			return 0, nil
+		}
+		if req.CategoryID == 0 {
+			return http.StatusBadRequest, fmt.Errorf("categoryId is required for %s transactions", req.Type)
+		}
+		transaction.CategoryID = req.CategoryID
+		transaction.Splits = nil
+		return 0, nil
 	}
 
-	// カテゴリ情報を含めて返す
-	db.Preload("Category").First(&transaction, transaction.ID)
-	c.JSON(http.StatusOK, transaction)
+	// 振替は収支に含めないためカテゴリを持たない
+	if len(req.Splits) > 0 {
+		return http.StatusBadRequest, fmt.Errorf("Transfers cannot be split")
+	}
+	if req.ToAccountID == nil || *req.ToAccountID == 0 {
+		return http.StatusBadRequest, fmt.Errorf("toAccountId is required for transfers")
+	}
+	if *req.ToAccountID == account.ID {
+		return http.StatusBadRequest, fmt.Errorf("toAccountId must differ from accountId")
+	}
+	toAccount, err := resolveAccount(transaction.UserID, *req.ToAccountID)
+	if err != nil {
+		return http.StatusBadRequest, fmt.Errorf("Destination account not found")
+	}
+
+	toAmount := req.ToAmount
+	if toAccount.Currency == account.Currency {
+		toAmount = req.Amount
+	} else if toAmount == 0 {
+		return http.StatusBadRequest, fmt.Errorf("toAmount is required for transfers between %s and %s accounts", account.Currency, toAccount.Currency)
+	} else if err := checkCurrencyUnit("toAmount", toAmount, toAccount.Currency); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	transaction.CategoryID = 0
+	transaction.Category = Category{}
+	transaction.Splits = nil
+	transaction.ToAccountID = &toAccount.ID
+	transaction.ToAmount = toAmount
+	return 0, nil
 }
 
 func deleteTransaction(c *gin.Context) {
 	userID, _ := c.Get("userID")
 	id := c.Param("id")
 
-	if err := db.Where("user_id = ?", userID).Delete(&Transaction{}, id).Error; err != nil {
+	var attachments []Attachment
+	err := db.Transaction(func(tx *gorm.DB) error {
+		owned := tx.Model(&Transaction{}).Select("id").Where("user_id = ? AND id = ?", userID, id)
+		var err error
+		if attachments, err = deleteTransactionDetails(tx, owned); err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&Transaction{}, id).Error
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	removeAttachmentObjects(attachments)
+
+	// 重複の統合で残した取引が削除された場合、確認済みの記録を外す
+	db.Model(&Transaction{}).Where("user_id = ? AND duplicate_of_id = ?", userID, id).Update("duplicate_of_id", nil)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Transaction deleted successfully"})
 }
@@ -157,7 +361,9 @@ func getTransaction(c *gin.Context) {
 	id := c.Param("id")
 	var transaction Transaction
 
-	if err := db.Preload("Category").Where("user_id = ?", userID).First(&transaction, id).Error; err != nil {
+	if err := db.Preload("Category").Preload("Splits.Category").Preload("Tags").Preload("Attachments", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).Where("user_id = ?", userID).First(&transaction, id).Error; err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Transaction not found"})
 		return
 	}
@@ -207,12 +413,22 @@ func updateCategory(c *gin.Context) {
 		return
 	}
 
+	name := category.Name
 	if err := c.ShouldBindJSON(&category); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
 	db.Save(&category)
+
+	// カテゴリ名は検索の対象なので、名前が変わった場合は索引を作り直す
+	if category.Name != name {
+		withCategory := db.Model(&Transaction{}).Select("id").Where("category_id = ?", category.ID).
+			Or("id IN (?)", db.Model(&TransactionSplit{}).Select("transaction_id").Where("category_id = ?", category.ID))
+		if _, err := indexTransactions(db, withCategory); err != nil {
+			log.Printf("Failed to update search index for category %d: %v", category.ID, err)
+		}
+	}
 	c.JSON(http.StatusOK, category)
 }
 
@@ -222,15 +438,25 @@ func deleteCategory(c *gin.Context) {
 
 	var count int64
 	db.Model(&Transaction{}).Where("user_id = ? AND category_id = ?", userID, id).Count(&count)
+	if count == 0 {
+		db.Model(&TransactionSplit{}).Where("user_id = ? AND category_id = ?", userID, id).Count(&count)
+	}
 	if count > 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot delete category with existing transactions"})
 		return
 	}
+	db.Model(&CategoryRule{}).Where("user_id = ? AND category_id = ?", userID, id).Count(&count)
+	if count > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot delete category used by category rules"})
+		return
+	}
 
 	if err := db.Where("user_id = ?", userID).Delete(&Category{}, id).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	// 既定カテゴリとして使っていた支払先は既定カテゴリなしにする
+	db.Model(&Payee{}).Where("user_id = ? AND default_category_id = ?", userID, id).Update("default_category_id", nil)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Category deleted successfully"})
 }
@@ -239,18 +465,41 @@ func getStats(c *gin.Context) {
 	userID, _ := c.Get("userID")
 	var stats Stats
 
-	// 通常の取引からの集計（固定費から自動生成された取引も含む）
-	db.Model(&Transaction{}).Where("user_id = ? AND type = ?", userID, "income").Select("COALESCE(SUM(amount), 0)").Scan(&stats.TotalIncome)
-	db.Model(&Transaction{}).Where("user_id = ? AND type = ?", userID, "expense").Select("COALESCE(SUM(amount), 0)").Scan(&stats.TotalExpense)
-	stats.CurrentBalance = stats.TotalIncome - stats.TotalExpense
+	// 外貨の取引は取引日のレートで基準通貨に換算して集計する
+	converter, err := newCurrencyConverter(userID)
+	if err != nil {
+		respondAggregationError(c, err)
+		return
+	}
+
+	// 通常の取引からの集計（固定費から自動生成された取引も含む。内訳は内訳のカテゴリで集計する）
+	if stats.TotalIncome, err = converter.sum(db.Model(&Transaction{}).Where("user_id = ? AND type = ?", userID, "income")); err != nil {
+		respondAggregationError(c, err)
+		return
+	}
+	if stats.TotalExpense, err = converter.sum(db.Model(&Transaction{}).Where("user_id = ? AND type = ?", userID, "expense")); err != nil {
+		respondAggregationError(c, err)
+		return
+	}
+	// 残高は全口座（初期残高を含む）の合計。振替は口座間の移動なので相殺される
+	if stats.CurrentBalance, err = converter.totalAccountBalance(userID); err != nil {
+		respondAggregationError(c, err)
+		return
+	}
 
 	now := time.Now()
 	startOfMonth := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
 	endOfMonth := startOfMonth.AddDate(0, 1, 0).Add(-time.Second)
 
 	// 今月の取引（固定費から自動生成された取引も含む）
-	db.Model(&Transaction{}).Where("user_id = ? AND type = ? AND date BETWEEN ? AND ?", userID, "income", startOfMonth, endOfMonth).Select("COALESCE(SUM(amount), 0)").Scan(&stats.ThisMonthIncome)
-	db.Model(&Transaction{}).Where("user_id = ? AND type = ? AND date BETWEEN ? AND ?", userID, "expense", startOfMonth, endOfMonth).Select("COALESCE(SUM(amount), 0)").Scan(&stats.ThisMonthExpense)
+	if stats.ThisMonthIncome, err = converter.sum(db.Model(&Transaction{}).Where("user_id = ? AND type = ? AND date BETWEEN ? AND ?", userID, "income", startOfMonth, endOfMonth)); err != nil {
+		respondAggregationError(c, err)
+		return
+	}
+	if stats.ThisMonthExpense, err = converter.sum(db.Model(&Transaction{}).Where("user_id = ? AND type = ? AND date BETWEEN ? AND ?", userID, "expense", startOfMonth, endOfMonth)); err != nil {
+		respondAggregationError(c, err)
+		return
+	}
 
 	db.Model(&Transaction{}).Where("user_id = ?", userID).Count(&stats.TransactionCount)
 
@@ -261,6 +510,12 @@ func getMonthlySummary(c *gin.Context) {
 	userID, _ := c.Get("userID")
 	year, _ := strconv.Atoi(c.DefaultQuery("year", strconv.Itoa(time.Now().Year())))
 
+	converter, err := newCurrencyConverter(userID)
+	if err != nil {
+		respondAggregationError(c, err)
+		return
+	}
+
 	var summaries []MonthlySummary
 
 	for month := 1; month <= 12; month++ {
@@ -271,9 +526,15 @@ func getMonthlySummary(c *gin.Context) {
 		summary.Year = year
 		summary.Month = month
 
-		// 通常の取引からの集計（固定費から自動生成された取引も含む）
-		db.Model(&Transaction{}).Where("user_id = ? AND type = ? AND date BETWEEN ? AND ?", userID, "income", startDate, endDate).Select("COALESCE(SUM(amount), 0)").Scan(&summary.TotalIncome)
-		db.Model(&Transaction{}).Where("user_id = ? AND type = ? AND date BETWEEN ? AND ?", userID, "expense", startDate, endDate).Select("COALESCE(SUM(amount), 0)").Scan(&summary.TotalExpense)
+		// 通常の取引からの集計（固定費から自動生成された取引も含む。内訳は内訳のカテゴリで集計する）
+		if summary.TotalIncome, err = converter.sum(db.Model(&Transaction{}).Where("user_id = ? AND type = ? AND date BETWEEN ? AND ?", userID, "income", startDate, endDate)); err != nil {
+			respondAggregationError(c, err)
+			return
+		}
+		if summary.TotalExpense, err = converter.sum(db.Model(&Transaction{}).Where("user_id = ? AND type = ? AND date BETWEEN ? AND ?", userID, "expense", startDate, endDate)); err != nil {
+			respondAggregationError(c, err)
+			return
+		}
 		summary.Balance = summary.TotalIncome - summary.TotalExpense
 
 		summaries = append(summaries, summary)
@@ -288,37 +549,60 @@ func getCategorySummary(c *gin.Context) {
 	startDate := c.Query("startDate")
 	endDate := c.Query("endDate")
 
-	// 通常の取引からの集計（固定費から自動生成された取引も含む）
-	query := `
-		SELECT 
-			c.id as category_id,
-			c.name as category_name,
-			c.icon as category_icon,
-			c.color as category_color,
-			c.type as type,
-			COALESCE(SUM(t.amount), 0) as total_amount,
-			COUNT(t.id) as count
-		FROM categories c
-		LEFT JOIN transactions t ON c.id = t.category_id AND t.type = ? AND t.user_id = ?
-	`
-
-	args := []interface{}{transactionType, userID}
+	var categories []Category
+	if err := db.Where("user_id = ? AND type = ?", userID, transactionType).Find(&categories).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch categories: " + err.Error()})
+		return
+	}
 
+	// 通常の取引からの集計（固定費から自動生成された取引も含む。内訳は内訳のカテゴリで集計する）
+	query := categoryLines().Where("user_id = ? AND type = ?", userID, transactionType)
 	if startDate != "" && endDate != "" {
-		query += " AND t.date BETWEEN ? AND ?"
-		args = append(args, startDate, endDate)
+		rangeStart, rangeEnd, err := parseDateRange(startDate, endDate)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		query = query.Where("date >= ? AND date < ?", rangeStart, rangeEnd)
 	}
 
-	query += " WHERE c.user_id = ? AND c.type = ? GROUP BY c.id ORDER BY total_amount DESC"
-	args = append(args, userID, transactionType)
+	// 外貨の取引は取引日のレートで基準通貨に換算する
+	converter, err := newCurrencyConverter(userID)
+	if err != nil {
+		respondAggregationError(c, err)
+		return
+	}
+	totals, counts, err := converter.totalsBy(query, "category_id")
+	if err != nil {
+		respondAggregationError(c, err)
+		return
+	}
 
-	var summaries []CategorySummary
-	db.Raw(query, args...).Scan(&summaries)
+	summaries := make([]CategorySummary, 0, len(categories))
+	for _, category := range categories {
+		summaries = append(summaries, CategorySummary{
+			CategoryID:    category.ID,
+			CategoryName:  category.Name,
+			CategoryIcon:  category.Icon,
+			CategoryColor: category.Color,
+			Type:          category.Type,
+			TotalAmount:   totals[category.ID],
+			Count:         counts[category.ID],
+		})
+	}
+
+	// 金額の大きい順（同額はID順）
+	sort.SliceStable(summaries, func(i, j int) bool {
+		if summaries[i].TotalAmount != summaries[j].TotalAmount {
+			return summaries[i].TotalAmount > summaries[j].TotalAmount
+		}
+		return summaries[i].CategoryID < summaries[j].CategoryID
+	})
 
 	// デバッグログ
 	log.Printf("Category summaries for user %v, type %s: %d categories", userID, transactionType, len(summaries))
 	for _, s := range summaries {
-		log.Printf("Category: %s (ID: %d), Amount: %f, Count: %d", s.CategoryName, s.CategoryID, s.TotalAmount, s.Count)
+		log.Printf("Category: %s (ID: %d), Amount: %s, Count: %d", s.CategoryName, s.CategoryID, s.TotalAmount, s.Count)
 	}
 
 	c.JSON(http.StatusOK, summaries)
@@ -329,20 +613,71 @@ func getDailySummary(c *gin.Context) {
 	startDate := c.DefaultQuery("startDate", time.Now().AddDate(0, 0, -30).Format("2006-01-02"))
 	endDate := c.DefaultQuery("endDate", time.Now().Format("2006-01-02"))
 
+	rangeStart, rangeEnd, err := parseDateRange(startDate, endDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 日付の切り出しはバックエンドごとに異なるためダイアレクトに合わせる
+	day := sqlDateString("date")
 	query := `
 		SELECT 
-			DATE(date) as date,
-			COALESCE(SUM(CASE WHEN type = 'income' THEN amount ELSE 0 END), 0) as total_income,
-			COALESCE(SUM(CASE WHEN type = 'expense' THEN amount ELSE 0 END), 0) as total_expense,
-			COALESCE(SUM(CASE WHEN type = 'income' THEN amount ELSE -amount END), 0) as balance
+			` + day + ` as day,
+			type,
+			currency,
+			COALESCE(SUM(amount), 0) as total
 		FROM transactions 
-		WHERE user_id = ? AND date BETWEEN ? AND ?
-		GROUP BY DATE(date)
-		ORDER BY date DESC
+		WHERE user_id = ? AND type IN ('income', 'expense') AND date >= ? AND date < ?
+		GROUP BY 1, 2, 3
 	`
 
-	var summaries []DailySummary
-	db.Raw(query, userID, startDate, endDate).Scan(&summaries)
+	var rows []struct {
+		Day      string
+		Type     string
+		Currency string
+		Total    Money
+	}
+	if err := db.Raw(query, userID, rangeStart, rangeEnd).Scan(&rows).Error; err != nil {
+		respondAggregationError(c, err)
+		return
+	}
+
+	// 外貨の取引はその日のレートで基準通貨に換算する
+	converter, err := newCurrencyConverter(userID)
+	if err != nil {
+		respondAggregationError(c, err)
+		return
+	}
+
+	byDate := make(map[string]*DailySummary)
+	for _, row := range rows {
+		date, _ := time.Parse("2006-01-02", row.Day)
+		amount, err := converter.convert(row.Total, row.Currency, date)
+		if err != nil {
+			respondAggregationError(c, err)
+			return
+		}
+
+		summary, ok := byDate[row.Day]
+		if !ok {
+			summary = &DailySummary{Date: row.Day}
+			byDate[row.Day] = summary
+		}
+		if row.Type == "income" {
+			summary.TotalIncome += amount
+			summary.Balance += amount
+		} else {
+			summary.TotalExpense += amount
+			summary.Balance -= amount
+		}
+	}
+
+	summaries := make([]DailySummary, 0, len(byDate))
+	for _, summary := range byDate {
+		summaries = append(summaries, *summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Date > summaries[j].Date })
 
 	c.JSON(http.StatusOK, summaries)
 }
@@ -374,6 +709,12 @@ func createBudget(c *gin.Context) {
 		return
 	}
 
+	// 予算は基準通貨の金額
+	if err := checkCurrencyUnit("amount", req.Amount, userBaseCurrency(userID)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// 既存の予算があるかチェック
 	var existingBudget Budget
 	if err := db.Where("user_id = ? AND year = ? AND month = ?", userID, req.Year, req.Month).First(&existingBudget).Error; err == nil {
@@ -413,6 +754,11 @@ func updateBudget(c *gin.Context) {
 		return
 	}
 
+	if err := checkCurrencyUnit("amount", req.Amount, userBaseCurrency(userID)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	budget.Year = req.Year
 	budget.Month = req.Month
 	budget.Amount = req.Amount
@@ -476,13 +822,26 @@ func createFixedExpense(c *gin.Context) {
 	}
 
 	// デバッグログ
-	log.Printf("Creating fixed expense - Name: %s, Amount: %f, Type: %s, CategoryID: %v",
+	log.Printf("Creating fixed expense - Name: %s, Amount: %s, Type: %s, CategoryID: %v",
 		req.Name, req.Amount, req.Type, req.CategoryID)
 
+	// 口座の指定がなければデフォルト口座（通貨は口座の通貨）
+	account, err := resolveFixedExpenseAccount(userID.(uint), req.AccountID, req.Currency)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := checkCurrencyUnit("amount", req.Amount, account.Currency); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	fixedExpense := FixedExpense{
 		UserID:      userID.(uint),
 		Name:        req.Name,
 		Amount:      req.Amount,
+		Currency:    account.Currency,
+		AccountID:   account.ID,
 		Type:        req.Type,
 		CategoryID:  req.CategoryID,
 		Description: req.Description,
@@ -524,8 +883,25 @@ func updateFixedExpense(c *gin.Context) {
 		return
 	}
 
+	// 口座の指定がなければ変更しない
+	accountID := req.AccountID
+	if accountID == 0 {
+		accountID = fixedExpense.AccountID
+	}
+	account, err := resolveFixedExpenseAccount(fixedExpense.UserID, accountID, req.Currency)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := checkCurrencyUnit("amount", req.Amount, account.Currency); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	fixedExpense.Name = req.Name
 	fixedExpense.Amount = req.Amount
+	fixedExpense.Currency = account.Currency
+	fixedExpense.AccountID = account.ID
 	fixedExpense.Type = req.Type
 	fixedExpense.CategoryID = req.CategoryID
 	fixedExpense.Description = req.Description
@@ -544,6 +920,23 @@ func updateFixedExpense(c *gin.Context) {
 	c.JSON(http.StatusOK, fixedExpense)
 }
 
+// 固定費の口座を検証して返す（通貨を指定する場合は口座の通貨と一致している必要がある）
+func resolveFixedExpenseAccount(userID, accountID uint, currency string) (Account, error) {
+	account, err := resolveAccount(userID, accountID)
+	if err != nil {
+		return account, err
+	}
+
+	currency, err = currencyOrDefault(currency, account.Currency)
+	if err != nil {
+		return account, err
+	}
+	if currency != account.Currency {
+		return account, fmt.Errorf("currency %s does not match account currency %s", currency, account.Currency)
+	}
+	return account, nil
+}
+
 // 固定費削除
 func deleteFixedExpense(c *gin.Context) {
 	userID, _ := c.Get("userID")
@@ -564,7 +957,14 @@ func deleteFixedExpense(c *gin.Context) {
 		description = "固定支出: " + fixedExpense.Name
 	}
 
-	// 関連する自動生成取引を削除
+	// 関連する自動生成取引を削除（内訳・タグ・添付ファイルを付けていた場合はそれも）
+	generated := db.Model(&Transaction{}).Select("id").Where("user_id = ? AND category_id = ? AND type = ? AND description = ?",
+		userID, fixedExpense.CategoryID, fixedExpense.Type, description)
+	if attachments, err := deleteTransactionDetails(db, generated); err != nil {
+		log.Printf("Failed to delete related transaction details for fixed expense %d: %v", fixedExpense.ID, err)
+	} else {
+		removeAttachmentObjects(attachments)
+	}
 	if err := db.Where("user_id = ? AND category_id = ? AND type = ? AND description = ?",
 		userID, fixedExpense.CategoryID, fixedExpense.Type, description).Delete(&Transaction{}).Error; err != nil {
 		log.Printf("Failed to delete related transactions for fixed expense %d: %v", fixedExpense.ID, err)
@@ -588,19 +988,32 @@ func getBudgetAnalysis(c *gin.Context) {
 	month, _ := strconv.Atoi(c.Param("month"))
 
 	// 常にカテゴリ別予算の合計を使用（月次予算は廃止）
-	var budgetAmount float64
+	var budgetAmount Money
 	db.Model(&CategoryBudget{}).Where("user_id = ? AND year = ? AND month = ?", userID, year, month).Select("COALESCE(SUM(amount), 0)").Scan(&budgetAmount)
 
 	// 当月の支出取得（固定費から自動生成された取引も含む）
 	startDate := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
 	endDate := startDate.AddDate(0, 1, 0).Add(-time.Second)
 
-	var currentSpending float64
-	db.Model(&Transaction{}).Where("user_id = ? AND type = ? AND date BETWEEN ? AND ?", userID, "expense", startDate, endDate).Select("COALESCE(SUM(amount), 0)").Scan(&currentSpending)
+	// 外貨の取引は取引日のレートで基準通貨に換算する
+	converter, err := newCurrencyConverter(userID)
+	if err != nil {
+		respondAggregationError(c, err)
+		return
+	}
+
+	currentSpending, err := converter.sum(db.Model(&Transaction{}).Where("user_id = ? AND type = ? AND date BETWEEN ? AND ?", userID, "expense", startDate, endDate))
+	if err != nil {
+		respondAggregationError(c, err)
+		return
+	}
 
 	// 固定支出合計取得（表示用）- 固定収入は含めない
-	var totalFixedExpenses float64
-	db.Model(&FixedExpense{}).Where("user_id = ? AND type = ? AND is_active = ?", userID, "expense", true).Select("COALESCE(SUM(amount), 0)").Scan(&totalFixedExpenses)
+	totalFixedExpenses, err := converter.sumFixedExpenses(userID, startDate)
+	if err != nil {
+		respondAggregationError(c, err)
+		return
+	}
 
 	// 残り予算計算（固定費は既にcurrentSpendingに含まれているので重複計算しない）
 	remainingBudget := budgetAmount - currentSpending
@@ -608,7 +1021,7 @@ func getBudgetAnalysis(c *gin.Context) {
 	// 予算使用率計算
 	budgetUtilization := float64(0)
 	if budgetAmount > 0 {
-		budgetUtilization = (currentSpending / budgetAmount) * 100
+		budgetUtilization = currentSpending.PercentOf(budgetAmount)
 	}
 
 	// 残り日数計算
@@ -625,9 +1038,9 @@ func getBudgetAnalysis(c *gin.Context) {
 	}
 
 	// 1日あたり使用可能金額計算
-	dailyAverage := float64(0)
+	dailyAverage := Money(0)
 	if daysRemaining > 0 && remainingBudget > 0 {
-		dailyAverage = remainingBudget / float64(daysRemaining)
+		dailyAverage = remainingBudget.Div(int64(daysRemaining)).Round(converter.base)
 	}
 
 	analysis := BudgetAnalysis{
@@ -652,7 +1065,7 @@ func getRemainingBudget(c *gin.Context) {
 	month, _ := strconv.Atoi(c.Param("month"))
 
 	// 常にカテゴリ別予算の合計を使用（月次予算は廃止）
-	var budgetAmount float64
+	var budgetAmount Money
 	db.Model(&CategoryBudget{}).Where("user_id = ? AND year = ? AND month = ?", userID, year, month).Select("COALESCE(SUM(amount), 0)").Scan(&budgetAmount)
 
 	if budgetAmount == 0 {
@@ -660,16 +1073,29 @@ func getRemainingBudget(c *gin.Context) {
 		return
 	}
 
-	// 固定支出合計取得（固定収入は含めない）
-	var totalFixedExpenses float64
-	db.Model(&FixedExpense{}).Where("user_id = ? AND type = ? AND is_active = ?", userID, "expense", true).Select("COALESCE(SUM(amount), 0)").Scan(&totalFixedExpenses)
-
 	// 当月の支出取得
 	startDate := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
 	endDate := startDate.AddDate(0, 1, 0).Add(-time.Second)
 
-	var currentSpending float64
-	db.Model(&Transaction{}).Where("user_id = ? AND type = ? AND date BETWEEN ? AND ?", userID, "expense", startDate, endDate).Select("COALESCE(SUM(amount), 0)").Scan(&currentSpending)
+	// 外貨の金額は基準通貨に換算する
+	converter, err := newCurrencyConverter(userID)
+	if err != nil {
+		respondAggregationError(c, err)
+		return
+	}
+
+	// 固定支出合計取得（固定収入は含めない）
+	totalFixedExpenses, err := converter.sumFixedExpenses(userID, startDate)
+	if err != nil {
+		respondAggregationError(c, err)
+		return
+	}
+
+	currentSpending, err := converter.sum(db.Model(&Transaction{}).Where("user_id = ? AND type = ? AND date BETWEEN ? AND ?", userID, "expense", startDate, endDate))
+	if err != nil {
+		respondAggregationError(c, err)
+		return
+	}
 
 	// 残り予算計算
 	remainingBudget := budgetAmount - totalFixedExpenses - currentSpending
@@ -689,6 +1115,13 @@ func getBudgetHistory(c *gin.Context) {
 	var history []BudgetHistory
 	now := time.Now()
 
+	// 外貨の金額は基準通貨に換算する
+	converter, err := newCurrencyConverter(userID)
+	if err != nil {
+		respondAggregationError(c, err)
+		return
+	}
+
 	// 過去6ヶ月のデータを取得
 	for i := 5; i >= 0; i-- {
 		targetDate := now.AddDate(0, -i, 0)
@@ -696,24 +1129,30 @@ func getBudgetHistory(c *gin.Context) {
 		month := int(targetDate.Month())
 
 		// 常にカテゴリ別予算の合計を使用（月次予算は廃止）
-		var budgetAmount float64
+		var budgetAmount Money
 		db.Model(&CategoryBudget{}).Where("user_id = ? AND year = ? AND month = ?", userID, year, month).Select("COALESCE(SUM(amount), 0)").Scan(&budgetAmount)
 
-		// 固定支出合計取得（固定収入は含めない）
-		var fixedExpenses float64
-		db.Model(&FixedExpense{}).Where("user_id = ? AND type = ? AND is_active = ?", userID, "expense", true).Select("COALESCE(SUM(amount), 0)").Scan(&fixedExpenses)
-
 		// 実際の支出取得
 		startDate := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
 		endDate := startDate.AddDate(0, 1, 0).Add(-time.Second)
 
-		var actualSpending float64
-		db.Model(&Transaction{}).Where("user_id = ? AND type = ? AND date BETWEEN ? AND ?", userID, "expense", startDate, endDate).Select("COALESCE(SUM(amount), 0)").Scan(&actualSpending)
+		// 固定支出合計取得（固定収入は含めない）
+		fixedExpenses, err := converter.sumFixedExpenses(userID, startDate)
+		if err != nil {
+			respondAggregationError(c, err)
+			return
+		}
+
+		actualSpending, err := converter.sum(db.Model(&Transaction{}).Where("user_id = ? AND type = ? AND date BETWEEN ? AND ?", userID, "expense", startDate, endDate))
+		if err != nil {
+			respondAggregationError(c, err)
+			return
+		}
 
 		// 貯蓄率計算
 		savingsRate := float64(0)
 		if budgetAmount > 0 {
-			savingsRate = ((budgetAmount - actualSpending) / budgetAmount) * 100
+			savingsRate = (budgetAmount - actualSpending).PercentOf(budgetAmount)
 		}
 
 		// 予算超過チェック
@@ -753,17 +1192,27 @@ func getCategoryBudgets(c *gin.Context) {
 	startDate := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
 	endDate := startDate.AddDate(0, 1, 0).Add(-time.Second)
 
+	// 外貨の取引は取引日のレートで基準通貨に換算する
+	converter, err := newCurrencyConverter(userID)
+	if err != nil {
+		respondAggregationError(c, err)
+		return
+	}
+	spentByCategory, _, err := converter.totalsBy(categoryLines().Where("user_id = ? AND type = ? AND date BETWEEN ? AND ?",
+		userID, "expense", startDate, endDate), "category_id")
+	if err != nil {
+		respondAggregationError(c, err)
+		return
+	}
+
 	for i := range categoryBudgets {
-		var spent float64
-		db.Model(&Transaction{}).Where("user_id = ? AND category_id = ? AND type = ? AND date BETWEEN ? AND ?",
-			userID, categoryBudgets[i].CategoryID, "expense", startDate, endDate).
-			Select("COALESCE(SUM(amount), 0)").Scan(&spent)
+		spent := spentByCategory[categoryBudgets[i].CategoryID]
 
 		categoryBudgets[i].Spent = spent
 		categoryBudgets[i].Remaining = categoryBudgets[i].Amount - spent
 
 		if categoryBudgets[i].Amount > 0 {
-			categoryBudgets[i].UtilizationRate = (spent / categoryBudgets[i].Amount) * 100
+			categoryBudgets[i].UtilizationRate = spent.PercentOf(categoryBudgets[i].Amount)
 		}
 	}
 
@@ -780,6 +1229,12 @@ func createCategoryBudget(c *gin.Context) {
 		return
 	}
 
+	// 予算は基準通貨の金額
+	if err := checkCurrencyUnit("amount", req.Amount, userBaseCurrency(userID)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// 既存の予算があるかチェック
 	var existingBudget CategoryBudget
 	if err := db.Where("user_id = ? AND category_id = ? AND year = ? AND month = ?",
@@ -823,6 +1278,11 @@ func updateCategoryBudget(c *gin.Context) {
 		return
 	}
 
+	if err := checkCurrencyUnit("amount", req.Amount, userBaseCurrency(userID)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	categoryBudget.CategoryID = req.CategoryID
 	categoryBudget.Year = req.Year
 	categoryBudget.Month = req.Month
@@ -871,21 +1331,27 @@ func getCategoryBudgetAnalysis(c *gin.Context) {
 	startDate := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
 	endDate := startDate.AddDate(0, 1, 0).Add(-time.Second)
 
-	for _, budget := range categoryBudgets {
-		var spentAmount float64
-		var transactionCount int64
-
-		db.Model(&Transaction{}).Where("user_id = ? AND category_id = ? AND type = ? AND date BETWEEN ? AND ?",
-			userID, budget.CategoryID, "expense", startDate, endDate).
-			Select("COALESCE(SUM(amount), 0)").Scan(&spentAmount)
+	// 外貨の取引は取引日のレートで基準通貨に換算する
+	converter, err := newCurrencyConverter(userID)
+	if err != nil {
+		respondAggregationError(c, err)
+		return
+	}
+	spentByCategory, countByCategory, err := converter.totalsBy(categoryLines().Where("user_id = ? AND type = ? AND date BETWEEN ? AND ?",
+		userID, "expense", startDate, endDate), "category_id")
+	if err != nil {
+		respondAggregationError(c, err)
+		return
+	}
 
-		db.Model(&Transaction{}).Where("user_id = ? AND category_id = ? AND type = ? AND date BETWEEN ? AND ?",
-			userID, budget.CategoryID, "expense", startDate, endDate).Count(&transactionCount)
+	for _, budget := range categoryBudgets {
+		spentAmount := spentByCategory[budget.CategoryID]
+		transactionCount := countByCategory[budget.CategoryID]
 
 		remainingAmount := budget.Amount - spentAmount
 		utilizationRate := float64(0)
 		if budget.Amount > 0 {
-			utilizationRate = (spentAmount / budget.Amount) * 100
+			utilizationRate = spentAmount.PercentOf(budget.Amount)
 		}
 
 		analysisItem := CategoryBudgetAnalysis{
@@ -944,6 +1410,17 @@ func getSpendingPrediction(c *gin.Context) {
 		return
 	}
 
+	// 外貨の取引は取引日のレートで基準通貨に換算してから予測する
+	converter, err := newCurrencyConverter(userID)
+	if err != nil {
+		respondAggregationError(c, err)
+		return
+	}
+	if err := convertTransactionAmounts(converter, monthlyTransactions); err != nil {
+		respondAggregationError(c, err)
+		return
+	}
+
 	expenseTransactions := make([]Transaction, 0, len(monthlyTransactions))
 	for _, transaction := range monthlyTransactions {
 		if isFixedTransactionDescription(transaction.Description) {
@@ -953,13 +1430,13 @@ func getSpendingPrediction(c *gin.Context) {
 	}
 
 	daysInMonth := endOfMonth.Day()
-	dailySpending := make(map[string]float64, daysInMonth)
+	dailySpending := make(map[string]Money, daysInMonth)
 	for day := 1; day <= daysInMonth; day++ {
 		dateKey := fmt.Sprintf("%04d-%02d-%02d", year, month, day)
 		dailySpending[dateKey] = 0
 	}
 
-	var currentSpending float64
+	var currentSpending Money
 	for _, transaction := range expenseTransactions {
 		dateKey := transaction.Date.Format("2006-01-02")
 		dailySpending[dateKey] += transaction.Amount
@@ -976,8 +1453,8 @@ func getSpendingPrediction(c *gin.Context) {
 		}
 	}
 
-	totalSpent := 0.0
-	spentAmounts := make([]float64, 0, currentDay)
+	totalSpent := Money(0)
+	spentAmounts := make([]Money, 0, currentDay)
 	activeDayCount := 0
 	for day := 1; day <= currentDay; day++ {
 		dateKey := fmt.Sprintf("%04d-%02d-%02d", year, month, day)
@@ -989,17 +1466,17 @@ func getSpendingPrediction(c *gin.Context) {
 		}
 	}
 
-	predictions := make([]float64, 0, 4)
-	dailyAverage := 0.0
+	// 予測値は途中で丸めず、最後に通貨単位で丸める
+	predictions := make([]Money, 0, 4)
+	dailyAverage := Money(0)
 	if currentDay > 0 {
-		dailyAverage = totalSpent / float64(currentDay)
-		predictions = append(predictions, dailyAverage*float64(daysInMonth))
+		dailyAverage = totalSpent.Div(int64(currentDay))
+		predictions = append(predictions, totalSpent.Mul(float64(daysInMonth)/float64(currentDay)))
 	}
 
 	if activeDayCount > 0 {
-		activeDayAverage := totalSpent / float64(activeDayCount)
 		estimatedActiveDays := math.Max(float64(activeDayCount), float64(daysInMonth)*0.6)
-		predictions = append(predictions, activeDayAverage*estimatedActiveDays)
+		predictions = append(predictions, totalSpent.Mul(estimatedActiveDays/float64(activeDayCount)))
 	}
 
 	historicalStart := startOfMonth.AddDate(0, -3, 0)
@@ -1010,7 +1487,12 @@ func getSpendingPrediction(c *gin.Context) {
 		return
 	}
 
-	weeklyPattern := calculateWeeklyPattern(historicalTransactions)
+	if err := convertTransactionAmounts(converter, historicalTransactions); err != nil {
+		respondAggregationError(c, err)
+		return
+	}
+
+	weeklyPattern := calculateWeeklyPattern(historicalTransactions, converter.base)
 	if isCurrentMonth && hasPositivePattern(weeklyPattern) {
 		weeklyPrediction := totalSpent
 		for day := currentDay + 1; day <= daysInMonth; day++ {
@@ -1027,13 +1509,13 @@ func getSpendingPrediction(c *gin.Context) {
 		if earlierAverage > 0 {
 			trendMultiplier = recentAverage / earlierAverage
 		}
-		trendPrediction := (totalSpent / float64(currentDay)) * float64(daysInMonth) * trendMultiplier
+		trendPrediction := totalSpent.Mul(float64(daysInMonth) / float64(currentDay) * trendMultiplier)
 		predictions = append(predictions, trendPrediction)
 	}
 
 	predictedTotal := totalSpent
 	if len(predictions) > 0 {
-		sort.Float64s(predictions)
+		sort.Slice(predictions, func(i, j int) bool { return predictions[i] < predictions[j] })
 		predictedTotal = predictions[len(predictions)/2]
 	}
 
@@ -1056,8 +1538,8 @@ func getSpendingPrediction(c *gin.Context) {
 		Year:            year,
 		Month:           month,
 		CurrentSpending: currentSpending,
-		PredictedTotal:  predictedTotal,
-		DailyAverage:    dailyAverage,
+		PredictedTotal:  predictedTotal.Round(converter.base),
+		DailyAverage:    dailyAverage.Round(converter.base),
 		RemainingDays:   remainingDays,
 		Confidence:      confidence,
 		Trend:           trend,
@@ -1068,8 +1550,21 @@ func getSpendingPrediction(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
-func calculateWeeklyPattern(transactions []Transaction) []float64 {
-	weeklySpending := make([]float64, 7)
+// 取引の金額を基準通貨に換算する（集計前の前処理）
+func convertTransactionAmounts(converter *currencyConverter, transactions []Transaction) error {
+	for i := range transactions {
+		amount, err := converter.convert(transactions[i].Amount, transactions[i].Currency, transactions[i].Date)
+		if err != nil {
+			return err
+		}
+		transactions[i].Amount = amount
+		transactions[i].Currency = converter.base
+	}
+	return nil
+}
+
+func calculateWeeklyPattern(transactions []Transaction, currency string) []Money {
+	weeklySpending := make([]Money, 7)
 	weeklyCounts := make([]int, 7)
 
 	for _, transaction := range transactions {
@@ -1084,7 +1579,7 @@ func calculateWeeklyPattern(transactions []Transaction) []float64 {
 
 	for index := range weeklySpending {
 		if weeklyCounts[index] > 0 {
-			weeklySpending[index] = weeklySpending[index] / float64(weeklyCounts[index])
+			weeklySpending[index] = weeklySpending[index].Div(int64(weeklyCounts[index])).Round(currency)
 		} else {
 			weeklySpending[index] = 0
 		}
@@ -1093,7 +1588,7 @@ func calculateWeeklyPattern(transactions []Transaction) []float64 {
 	return weeklySpending
 }
 
-func hasPositivePattern(pattern []float64) bool {
+func hasPositivePattern(pattern []Money) bool {
 	for _, value := range pattern {
 		if value > 0 {
 			return true
@@ -1102,7 +1597,7 @@ func hasPositivePattern(pattern []float64) bool {
 	return false
 }
 
-func analyzeSpendingTrend(spentAmounts []float64) (string, float64, float64) {
+func analyzeSpendingTrend(spentAmounts []Money) (string, float64, float64) {
 	if len(spentAmounts) < 7 {
 		return "stable", 0, 0
 	}
@@ -1131,17 +1626,17 @@ func analyzeSpendingTrend(spentAmounts []float64) (string, float64, float64) {
 	return trend, recentAverage, earliestAverage
 }
 
-func mean(values []float64) float64 {
+func mean(values []Money) float64 {
 	if len(values) == 0 {
 		return 0
 	}
 
-	sum := 0.0
+	sum := Money(0)
 	for _, value := range values {
 		sum += value
 	}
 
-	return sum / float64(len(values))
+	return sum.Float64() / float64(len(values))
 }
 
 func calculatePredictionConfidence(currentDay int) string {
@@ -1201,22 +1696,39 @@ func createFixedTransactionForMonth(userID uint, fixedExpense FixedExpense) bool
 
 	// 既存の取引がない場合のみ新しい取引を生成
 	if existingCount == 0 {
+		// 口座が未設定の固定収支はデフォルト口座に記帳する
+		account, err := resolveAccount(userID, fixedExpense.AccountID)
+		if err != nil {
+			log.Printf("[BATCH] ERROR: Failed to resolve account for %s (ID: %d): %v",
+				fixedExpense.Name, fixedExpense.ID, err)
+			return false
+		}
+
 		transaction := Transaction{
 			UserID:      userID,
 			Type:        fixedExpense.Type,
 			Amount:      fixedExpense.Amount,
+			Currency:    account.Currency,
+			AccountID:   account.ID,
 			CategoryID:  fixedExpense.CategoryID,
 			Description: description,
 			Date:        firstDayOfMonth,
 		}
 
-		if err := db.Create(&transaction).Error; err != nil {
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&transaction).Error; err != nil {
+				return err
+			}
+			_, err := indexTransactions(tx, []uint{transaction.ID})
+			return err
+		})
+		if err != nil {
 			log.Printf("[BATCH] ERROR: Failed to create transaction for %s (ID: %d): %v",
 				fixedExpense.Name, fixedExpense.ID, err)
 			return false
 		} else {
-			log.Printf("[BATCH] Created transaction: %s, ¥%.0f on %s",
-				fixedExpense.Name, fixedExpense.Amount, firstDayOfMonth.Format("2006-01-02"))
+			log.Printf("[BATCH] Created transaction: %s, %s %s on %s",
+				fixedExpense.Name, fixedExpense.Amount.Format(fixedExpense.Currency), fixedExpense.Currency, firstDayOfMonth.Format("2006-01-02"))
 			return true
 		}
 	} else {
@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 家計簿アプリのエクスポート形式
+const (
+	presetMoneyForward = "moneyforward"
+	presetZaim         = "zaim"
+)
+
+// 家計簿アプリのカテゴリ名から既定カテゴリへの対応（大項目・中項目のどちらにも使う）
+//
+// 同じ名前のカテゴリはそのまま対応させるため、名前が異なるものだけを載せる。
+// 空文字は「その他収入」「その他支出」にまとめるカテゴリ。
+var presetCategoryAliases = map[string]string{
+	// マネーフォワード ME
+	"住宅":     "住居費",
+	"水道・光熱費": "光熱費",
+	"健康・医療":  "医療費",
+	"保険":     "医療費",
+	"衣服・美容":  "衣服費",
+	"教養・教育":  "教育費",
+	"趣味・娯楽":  "娯楽費",
+	"自動車":    "交通費",
+	"事業・副業":  "副業",
+	"その他入金":  "",
+	"税・社会保障": "",
+	"特別な支出":  "",
+	"現金・カード": "",
+	"未分類":    "",
+	"その他":    "",
+
+	// Zaim
+	"住まい":   "住居費",
+	"水道・光熱": "光熱費",
+	"日用雑貨":  "日用品",
+	"交通":    "交通費",
+	"クルマ":   "交通費",
+	"通信":    "通信費",
+	"エンタメ":  "娯楽費",
+	"教育・教養": "教育費",
+	"美容・衣服": "衣服費",
+	"医療・保険": "医療費",
+	"事業所得":  "副業",
+	"臨時収入":  "",
+	"立替金返済": "",
+	"大型出費":  "",
+	"税金":    "",
+}
+
+// 取込時のカテゴリ対応（見つからないものは大項目の名前で新規作成する）
+type presetCategoryResolver struct {
+	categories map[string]Category // 種類と名前 → カテゴリ
+}
+
+func newPresetCategoryResolver(userID uint) (*presetCategoryResolver, error) {
+	var categories []Category
+	if err := db.Where("user_id = ?", userID).Order("id").Find(&categories).Error; err != nil {
+		return nil, err
+	}
+
+	r := &presetCategoryResolver{categories: make(map[string]Category)}
+	for _, category := range categories {
+		key := category.Type + ":" + category.Name
+		if _, ok := r.categories[key]; !ok {
+			r.categories[key] = category
+		}
+	}
+	return r, nil
+}
+
+// 中項目・大項目の順に既存のカテゴリを探す
+//
+// 戻り値の bool が true の場合、カテゴリはまだ登録されていない（取込時に作成する）。
+func (r *presetCategoryResolver) resolve(major, minor, transactionType string) (Category, bool) {
+	fallback := "その他支出"
+	if transactionType == "income" {
+		fallback = "その他収入"
+	}
+
+	var names []string
+	for _, name := range []string{minor, major} {
+		if name == "" {
+			continue
+		}
+		names = append(names, name)
+		if alias, ok := presetCategoryAliases[name]; ok {
+			if alias == "" {
+				alias = fallback
+			}
+			names = append(names, alias)
+		}
+	}
+	if len(names) == 0 {
+		names = append(names, fallback)
+	}
+
+	for _, name := range names {
+		if category, ok := r.categories[transactionType+":"+name]; ok {
+			return category, category.ID == 0
+		}
+	}
+
+	// 大項目（なければ中項目）の名前で作成する。未分類などは「その他」にまとめる
+	name := major
+	if name == "" {
+		name = minor
+	}
+	if alias, ok := presetCategoryAliases[name]; name == "" || ok && alias == "" {
+		name = fallback
+	}
+	category := Category{Name: name, Type: transactionType, Color: "#6B7280", Icon: "document"}
+	r.categories[transactionType+":"+name] = category
+	return category, true
+}
+
+// ヘッダー行から列の位置を求める（必須の列がなければエラー）
+func presetColumns(header []string, required []string) (map[string]int, error) {
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+	for _, name := range required {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("column %q not found; is this the right export format?", name)
+		}
+	}
+	return columns, nil
+}
+
+// 家計簿アプリのエクスポートCSVを取引に変換する
+//
+// 振替と計算対象外の行は取り込まない（skipped で理由を返す）。
+func parsePresetCSV(userID uint, preset string, accountID uint, data []byte) ([]CSVImportRow, error) {
+	defaultAccount, err := resolveAccount(userID, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("Account not found")
+	}
+
+	// 金融機関名が口座名と一致する場合はその口座に取り込む
+	var accounts []Account
+	if err := db.Where("user_id = ?", userID).Find(&accounts).Error; err != nil {
+		return nil, err
+	}
+	accountsByName := make(map[string]Account)
+	for _, account := range accounts {
+		accountsByName[account.Name] = account
+	}
+
+	resolver, err := newPresetCategoryResolver(userID)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := newCategoryRuleEngine(userID)
+	if err != nil {
+		return nil, err
+	}
+	payees, err := newPayeeMatcher(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err = decodeStatement(data, "auto")
+	if err != nil {
+		return nil, err
+	}
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+
+	var parseRecord func(record []string, columns map[string]int) presetRecord
+	var required []string
+	switch preset {
+	case presetMoneyForward:
+		required = []string{"計算対象", "日付", "内容", "金額（円）", "保有金融機関", "大項目", "中項目", "メモ", "振替", "ID"}
+		parseRecord = parseMoneyForwardRecord
+	case presetZaim:
+		required = []string{"日付", "方法", "カテゴリ", "カテゴリの内訳", "支払元", "入金先", "品目", "メモ", "お店", "収入", "支出"}
+		parseRecord = parseZaimRecord
+	default:
+		return nil, fmt.Errorf("unknown preset: %s", preset)
+	}
+
+	columns, err := presetColumns(header, required)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []CSVImportRow
+	occurrences := make(map[string]int)
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line, _ := reader.FieldPos(0)
+		if err != nil {
+			rows = append(rows, CSVImportRow{Line: line, Errors: []string{err.Error()}})
+			continue
+		}
+		if strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+
+		parsed := parseRecord(record, columns)
+		row := CSVImportRow{Line: line, Skipped: parsed.Skipped, Errors: parsed.Errors}
+		if row.Skipped != "" {
+			rows = append(rows, row)
+			continue
+		}
+
+		account := defaultAccount
+		if named, ok := accountsByName[parsed.AccountName]; ok {
+			account = named
+		}
+		if parsed.Currency != "" && parsed.Currency != account.Currency {
+			row.Errors = append(row.Errors, fmt.Sprintf("currency %s does not match account currency %s", parsed.Currency, account.Currency))
+		}
+		if err := checkCurrencyUnit("amount", parsed.Amount, account.Currency); err != nil {
+			row.Errors = append(row.Errors, err.Error())
+		}
+
+		// IDのない形式は行の内容から識別子を作る（同じ内容の行は出現順で区別する）
+		externalID := parsed.ExternalID
+		if externalID == "" {
+			content := strings.Join(record, "\x1f")
+			occurrences[content]++
+			sum := sha256.Sum256([]byte(content + "\x1f" + strconv.Itoa(occurrences[content])))
+			externalID = preset + ":" + hex.EncodeToString(sum[:20])
+		}
+
+		transaction := Transaction{
+			UserID:      userID,
+			Type:        parsed.Type,
+			Amount:      parsed.Amount,
+			Currency:    account.Currency,
+			AccountID:   account.ID,
+			Description: parsed.Description,
+			Date:        parsed.Date,
+			ExternalID:  externalID,
+		}
+		payee := payees.apply(&transaction)
+		if rule := rules.apply(&transaction); rule != nil {
+			// 自動振り分けルールは家計簿アプリのカテゴリより優先する
+			row.RuleID = &rule.ID
+		} else if category, ok := payeeDefaultCategory(payee, parsed.Type); ok && parsed.Major == "" {
+			// 取込元にカテゴリがなければ支払先の既定カテゴリを使う
+			transaction.CategoryID = category.ID
+			transaction.Category = category
+		} else if parsed.Type != "" {
+			category, isNew := resolver.resolve(parsed.Major, parsed.Minor, parsed.Type)
+			category.UserID = userID
+			transaction.CategoryID = category.ID
+			transaction.Category = category
+			row.NewCategory = isNew
+		}
+
+		row.Transaction = &transaction
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// 家計簿アプリの1行分
+type presetRecord struct {
+	Type        string
+	Amount      Money
+	Date        time.Time
+	Description string
+	Major       string // 大項目
+	Minor       string // 中項目
+	AccountName string
+	Currency    string
+	ExternalID  string
+	Skipped     string
+	Errors      []string
+}
+
+// マネーフォワード ME の入出金明細
+//
+// 列: 計算対象,日付,内容,金額（円）,保有金融機関,大項目,中項目,メモ,振替,ID
+func parseMoneyForwardRecord(record []string, columns map[string]int) presetRecord {
+	field := presetField(record, columns)
+	var r presetRecord
+
+	if field("振替") == "1" {
+		r.Skipped = "transfer"
+		return r
+	}
+	if field("計算対象") == "0" {
+		r.Skipped = "excluded"
+		return r
+	}
+
+	date, err := parseStatementDate(field("日付"), "")
+	if err != nil {
+		r.Errors = append(r.Errors, "invalid date: "+field("日付"))
+	}
+	r.Date = date
+
+	amount, err := parseStatementAmount(field("金額（円）"))
+	switch {
+	case err != nil:
+		r.Errors = append(r.Errors, "invalid amount: "+err.Error())
+	case amount == 0:
+		r.Errors = append(r.Errors, "amount must not be zero")
+	case amount < 0:
+		r.Type, r.Amount = "expense", -amount
+	default:
+		r.Type, r.Amount = "income", amount
+	}
+
+	r.Description = joinNonEmpty(" / ", field("内容"), field("メモ"))
+	r.Major, r.Minor = field("大項目"), field("中項目")
+	r.AccountName = field("保有金融機関")
+	r.Currency = defaultCurrency
+	if id := field("ID"); id != "" {
+		r.ExternalID = presetMoneyForward + ":" + id
+	}
+	return r
+}
+
+// Zaim の入出金履歴
+//
+// 列: 日付,方法,カテゴリ,カテゴリの内訳,支払元,入金先,品目,メモ,お店,通貨,収入,支出,振替,残高調整,通貨変換前の金額,集計の設定
+func parseZaimRecord(record []string, columns map[string]int) presetRecord {
+	field := presetField(record, columns)
+	var r presetRecord
+
+	switch field("方法") {
+	case "transfer":
+		r.Skipped = "transfer"
+		return r
+	case "balance":
+		r.Skipped = "excluded"
+		return r
+	}
+	if field("集計の設定") == "集計に含めない" {
+		r.Skipped = "excluded"
+		return r
+	}
+
+	date, err := parseStatementDate(field("日付"), "")
+	if err != nil {
+		r.Errors = append(r.Errors, "invalid date: "+field("日付"))
+	}
+	r.Date = date
+
+	amountText := field("支出")
+	r.Type, r.AccountName = "expense", field("支払元")
+	if field("方法") == "income" {
+		amountText = field("収入")
+		r.Type, r.AccountName = "income", field("入金先")
+	}
+	amount, err := parseStatementAmount(amountText)
+	if err != nil {
+		r.Errors = append(r.Errors, "invalid amount: "+err.Error())
+	} else if amount <= 0 {
+		r.Errors = append(r.Errors, "amount must be greater than zero")
+	}
+	r.Amount = amount
+
+	r.Description = joinNonEmpty(" / ", field("お店"), field("品目"), field("メモ"))
+	r.Major, r.Minor = field("カテゴリ"), field("カテゴリの内訳")
+	if currency := field("通貨"); currency != "" {
+		r.Currency, _ = normalizeCurrency(currency)
+	}
+	return r
+}
+
+// 列名で値を取り出す関数を返す
+func presetField(record []string, columns map[string]int) func(string) string {
+	return func(name string) string {
+		i, ok := columns[name]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+}
+
+func joinNonEmpty(sep string, values ...string) string {
+	var parts []string
+	for _, value := range values {
+		if value != "" {
+			parts = append(parts, value)
+		}
+	}
+	return strings.Join(parts, sep)
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// 開発用のデフォルト鍵（本番では JWT_SECRET または JWT_KEYS_FILE を必ず設定する）
+const developmentJWTSecret = "your-secret-key-change-in-production"
+
+// JWT署名鍵
+//
+// 署名用の鍵を持たない鍵は検証専用（ローテーション前の旧鍵や他サービスの公開鍵）。
+type signingKey struct {
+	ID        string
+	Method    jwt.SigningMethod
+	SignKey   interface{} // []byte, *rsa.PrivateKey, ed25519.PrivateKey
+	VerifyKey interface{} // []byte, *rsa.PublicKey, ed25519.PublicKey
+}
+
+// 署名鍵の集合（トークンヘッダーの kid で検証鍵を選ぶ）
+type keySet struct {
+	Active *signingKey
+	Keys   map[string]*signingKey
+}
+
+var jwtKeys *keySet
+
+// 鍵ファイルの1エントリ
+//
+// HS256 は secret、RS256/EdDSA は PEM 形式の privateKey（署名用）または publicKey（検証専用）を指定する。
+// privateKeyFile / publicKeyFile でファイルから読み込むこともできる。
+type keyFileEntry struct {
+	ID             string `json:"kid"`
+	Algorithm      string `json:"alg"`
+	Secret         string `json:"secret"`
+	PrivateKey     string `json:"privateKey"`
+	PrivateKeyFile string `json:"privateKeyFile"`
+	PublicKey      string `json:"publicKey"`
+	PublicKeyFile  string `json:"publicKeyFile"`
+}
+
+type keyFile struct {
+	ActiveKeyID string         `json:"activeKid"`
+	Keys        []keyFileEntry `json:"keys"`
+}
+
+// 環境変数から署名鍵を読み込む
+//
+// JWT_KEYS_FILE が指定されていれば鍵ファイルを、なければ JWT_SECRET（kid は JWT_KEY_ID）を使う。
+// JWT_PREVIOUS_SECRETS（"kid:secret" のカンマ区切り）はローテーション前の検証専用鍵。
+// JWT_ACTIVE_KID で署名に使う鍵を切り替えられる。
+func loadSigningKeys() (*keySet, error) {
+	var entries []keyFileEntry
+	activeID := os.Getenv("JWT_ACTIVE_KID")
+
+	if path := os.Getenv("JWT_KEYS_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read JWT_KEYS_FILE: %w", err)
+		}
+		var file keyFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("failed to parse JWT_KEYS_FILE: %w", err)
+		}
+		entries = file.Keys
+		if activeID == "" {
+			activeID = file.ActiveKeyID
+		}
+	} else {
+		secret := os.Getenv("JWT_SECRET")
+		if secret == "" {
+			if gin.Mode() == gin.ReleaseMode {
+				return nil, fmt.Errorf("JWT_SECRET or JWT_KEYS_FILE must be set in release mode")
+			}
+			log.Println("WARNING: JWT_SECRET is not set, using the insecure development key")
+			secret = developmentJWTSecret
+		}
+		entries = append(entries, keyFileEntry{ID: getEnv("JWT_KEY_ID", "default"), Algorithm: "HS256", Secret: secret})
+
+		for _, previous := range strings.Split(os.Getenv("JWT_PREVIOUS_SECRETS"), ",") {
+			previous = strings.TrimSpace(previous)
+			if previous == "" {
+				continue
+			}
+			kid, secret, ok := strings.Cut(previous, ":")
+			if !ok || kid == "" || secret == "" {
+				return nil, fmt.Errorf("invalid JWT_PREVIOUS_SECRETS entry, expected kid:secret")
+			}
+			entries = append(entries, keyFileEntry{ID: kid, Algorithm: "HS256", Secret: secret})
+		}
+	}
+
+	set := &keySet{Keys: make(map[string]*signingKey)}
+	for _, entry := range entries {
+		key, err := entry.parse()
+		if err != nil {
+			return nil, fmt.Errorf("JWT key %q: %w", entry.ID, err)
+		}
+		if _, exists := set.Keys[key.ID]; exists {
+			return nil, fmt.Errorf("duplicate JWT key id %q", key.ID)
+		}
+		set.Keys[key.ID] = key
+
+		// 指定がなければ最初の署名可能な鍵を使う
+		if set.Active == nil && key.SignKey != nil && (activeID == "" || activeID == key.ID) {
+			set.Active = key
+		}
+	}
+
+	if set.Active == nil {
+		if activeID != "" {
+			return nil, fmt.Errorf("active JWT key %q not found or has no private key", activeID)
+		}
+		return nil, fmt.Errorf("no JWT signing key configured")
+	}
+	return set, nil
+}
+
+// 鍵ファイルのエントリを署名鍵に変換
+func (entry keyFileEntry) parse() (*signingKey, error) {
+	if entry.ID == "" {
+		return nil, fmt.Errorf("kid is required")
+	}
+
+	privatePEM, err := readKeyMaterial(entry.PrivateKey, entry.PrivateKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	publicPEM, err := readKeyMaterial(entry.PublicKey, entry.PublicKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &signingKey{ID: entry.ID}
+	switch strings.ToUpper(entry.Algorithm) {
+	case "", "HS256":
+		if entry.Secret == "" {
+			return nil, fmt.Errorf("secret is required for HS256")
+		}
+		if len(entry.Secret) < 32 && entry.Secret != developmentJWTSecret {
+			log.Printf("WARNING: JWT key %q is shorter than 32 bytes", entry.ID)
+		}
+		key.Method = jwt.SigningMethodHS256
+		key.SignKey = []byte(entry.Secret)
+		key.VerifyKey = []byte(entry.Secret)
+
+	case "RS256":
+		key.Method = jwt.SigningMethodRS256
+		if privatePEM != nil {
+			privateKey, err := jwt.ParseRSAPrivateKeyFromPEM(privatePEM)
+			if err != nil {
+				return nil, err
+			}
+			key.SignKey = privateKey
+			key.VerifyKey = &privateKey.PublicKey
+		} else if publicPEM != nil {
+			publicKey, err := jwt.ParseRSAPublicKeyFromPEM(publicPEM)
+			if err != nil {
+				return nil, err
+			}
+			key.VerifyKey = publicKey
+		}
+
+	case "EDDSA":
+		key.Method = jwt.SigningMethodEdDSA
+		if privatePEM != nil {
+			privateKey, err := jwt.ParseEdPrivateKeyFromPEM(privatePEM)
+			if err != nil {
+				return nil, err
+			}
+			key.SignKey = privateKey
+			key.VerifyKey = privateKey.(ed25519.PrivateKey).Public()
+		} else if publicPEM != nil {
+			publicKey, err := jwt.ParseEdPublicKeyFromPEM(publicPEM)
+			if err != nil {
+				return nil, err
+			}
+			key.VerifyKey = publicKey
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %q", entry.Algorithm)
+	}
+
+	if key.VerifyKey == nil {
+		return nil, fmt.Errorf("privateKey or publicKey is required for %s", key.Method.Alg())
+	}
+	return key, nil
+}
+
+// PEMを文字列またはファイルから読み込む
+func readKeyMaterial(inline, path string) ([]byte, error) {
+	if inline != "" {
+		return []byte(inline), nil
+	}
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return data, nil
+}
+
+// アクティブな鍵で署名（kid ヘッダーを付与）
+func (set *keySet) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(set.Active.Method, claims)
+	token.Header["kid"] = set.Active.ID
+	return token.SignedString(set.Active.SignKey)
+}
+
+// kid と署名方式を確認して検証鍵を返す（jwt.Keyfunc）
+func (set *keySet) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key := set.Active
+	if kid != "" {
+		var ok bool
+		if key, ok = set.Keys[kid]; !ok {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+	}
+
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+	return key.VerifyKey, nil
+}
+
+// トークンを検証してクレームを取り出す
+func (set *keySet) parse(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, claims, set.keyFunc,
+		jwt.WithValidMethods([]string{"HS256", "RS256", "EdDSA"}))
+}
+
+// 公開鍵の一覧（JWK Set）
+//
+// 共通鍵（HS256）は公開しない。
+func getJWKS(c *gin.Context) {
+	ids := make([]string, 0, len(jwtKeys.Keys))
+	for id := range jwtKeys.Keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	keys := []gin.H{}
+	for _, id := range ids {
+		key := jwtKeys.Keys[id]
+		switch publicKey := key.VerifyKey.(type) {
+		case *rsa.PublicKey:
+			keys = append(keys, gin.H{
+				"kty": "RSA",
+				"kid": key.ID,
+				"alg": key.Method.Alg(),
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			keys = append(keys, gin.H{
+				"kty": "OKP",
+				"crv": "Ed25519",
+				"kid": key.ID,
+				"alg": key.Method.Alg(),
+				"use": "sig",
+				"x":   base64.RawURLEncoding.EncodeToString(publicKey),
+			})
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
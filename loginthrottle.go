@@ -0,0 +1,364 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ログイン試行の状態（キーはアカウントまたはIPアドレス）
+type attemptState struct {
+	Failures    int
+	LockedUntil time.Time
+}
+
+// ログイン試行の保存先
+//
+// 単一インスタンスではメモリ、複数インスタンスで動かす場合は共有のデータベースを使う。
+// 同時に失敗したログインの回数を取りこぼさないよう、失敗回数の加算は保存先で不可分に行う。
+type AttemptStore interface {
+	Get(key string) (attemptState, error)
+	// 失敗回数を1増やして加算後の状態を返す。
+	// ttl 経過後（最後の失敗から一定時間後）は失敗回数をリセットしてよい
+	Increment(key string, ttl time.Duration) (attemptState, error)
+	// until までロックする（既により長くロックしている場合は変更しない）
+	Lock(key string, until time.Time) error
+	Delete(key string) error
+}
+
+// ロックアウトの方針
+type throttlePolicy struct {
+	FreeAttempts int           // ロックせずに許容する連続失敗回数
+	BaseLockout  time.Duration // 最初のロック時間（以後失敗ごとに倍になる）
+	MaxLockout   time.Duration
+	Window       time.Duration // この期間失敗がなければ回数をリセット
+}
+
+// 失敗回数に応じたロック時間（指数バックオフ）
+func (p throttlePolicy) lockoutFor(failures int) time.Duration {
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+	lockout := time.Duration(float64(p.BaseLockout) * math.Pow(2, float64(failures-p.FreeAttempts-1)))
+	if lockout <= 0 || lockout > p.MaxLockout {
+		return p.MaxLockout
+	}
+	return lockout
+}
+
+var (
+	accountThrottlePolicy = throttlePolicy{
+		FreeAttempts: 5,
+		BaseLockout:  30 * time.Second,
+		MaxLockout:   getEnvDuration("LOGIN_LOCKOUT_MAX", 15*time.Minute),
+		Window:       time.Hour,
+	}
+	ipThrottlePolicy = throttlePolicy{
+		FreeAttempts: 20,
+		BaseLockout:  30 * time.Second,
+		MaxLockout:   getEnvDuration("LOGIN_LOCKOUT_MAX", 15*time.Minute),
+		Window:       time.Hour,
+	}
+)
+
+var loginAttempts AttemptStore
+
+// 環境変数から保存先を選択（LOGIN_THROTTLE_STORE=memory|database）
+func newAttemptStoreFromEnv() AttemptStore {
+	if strings.ToLower(os.Getenv("LOGIN_THROTTLE_STORE")) == "database" {
+		return &databaseAttemptStore{}
+	}
+	return newMemoryAttemptStore()
+}
+
+// ロックアウト中のエラー
+type lockedOutError struct {
+	RetryAfter time.Duration
+}
+
+func (e *lockedOutError) Error() string {
+	return fmt.Sprintf("too many failed attempts, retry after %v", e.RetryAfter)
+}
+
+func accountThrottleKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// アカウント・IPのどちらかがロック中であればエラーを返す
+func checkLoginThrottle(email, ip string) error {
+	now := time.Now()
+	var retryAfter time.Duration
+
+	for _, key := range []string{accountThrottleKey(email), ipThrottleKey(ip)} {
+		state, err := loginAttempts.Get(key)
+		if err != nil {
+			return err
+		}
+		if wait := state.LockedUntil.Sub(now); wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+
+	if retryAfter > 0 {
+		return &lockedOutError{RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+// 失敗を記録し、必要であればロックする
+func recordLoginFailure(email, ip string) {
+	now := time.Now()
+	keys := map[string]throttlePolicy{
+		accountThrottleKey(email): accountThrottlePolicy,
+		ipThrottleKey(ip):         ipThrottlePolicy,
+	}
+
+	for key, policy := range keys {
+		state, err := loginAttempts.Increment(key, policy.Window+policy.MaxLockout)
+		if err != nil {
+			log.Printf("[AUTH] ERROR: Failed to record login attempt for %s: %v", key, err)
+			continue
+		}
+
+		if lockout := policy.lockoutFor(state.Failures); lockout > 0 {
+			log.Printf("[AUTH] Locking %s for %v after %d failed attempts", key, lockout, state.Failures)
+			if err := loginAttempts.Lock(key, now.Add(lockout)); err != nil {
+				log.Printf("[AUTH] ERROR: Failed to lock %s: %v", key, err)
+			}
+		}
+	}
+}
+
+// パスワード再設定メールの送信回数の上限（他人の受信箱を大量のメールで埋められないようにする）
+const (
+	passwordResetPerEmail = 3
+	passwordResetPerIP    = 20
+	passwordResetWindow   = time.Hour
+)
+
+// パスワード再設定の要求を数え、メールアドレス・IPのどちらかが上限を超えていればエラーを返す
+//
+// 登録の有無が分からないよう、未登録のメールアドレスも同じように数える。
+func checkPasswordResetLimit(email, ip string) error {
+	keys := map[string]int{
+		"reset:" + strings.ToLower(strings.TrimSpace(email)): passwordResetPerEmail,
+		"reset-ip:" + ip: passwordResetPerIP,
+	}
+	for key, limit := range keys {
+		state, err := loginAttempts.Increment(key, passwordResetWindow)
+		if err != nil {
+			return err
+		}
+		if state.Failures > limit {
+			return &lockedOutError{RetryAfter: passwordResetWindow}
+		}
+	}
+	return nil
+}
+
+// 成功したらアカウントの失敗回数をリセット（IPは共有されうるためリセットしない）
+func resetLoginFailures(email string) {
+	if err := loginAttempts.Delete(accountThrottleKey(email)); err != nil {
+		log.Printf("[AUTH] ERROR: Failed to reset login attempts: %v", err)
+	}
+}
+
+// 失敗したログイン試行を監査ログに記録
+func auditLoginFailure(c *gin.Context, email string, userID *uint, reason string) {
+	attempt := LoginAttempt{
+		Email:     strings.ToLower(strings.TrimSpace(email)),
+		UserID:    userID,
+		IPAddress: c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		Reason:    reason,
+	}
+	if err := db.Create(&attempt).Error; err != nil {
+		log.Printf("[AUTH] ERROR: Failed to write login audit log: %v", err)
+	}
+}
+
+// ロックアウト中の応答（429 と Retry-After）
+func respondLockedOut(c *gin.Context, err error) bool {
+	return respondTooManyRequests(c, err, "ログイン試行回数が多すぎます。しばらくしてから再度お試しください")
+}
+
+// 回数制限を超えた場合の応答（lockedOutError でなければ何もしない）
+func respondTooManyRequests(c *gin.Context, err error, message string) bool {
+	var locked *lockedOutError
+	if !errors.As(err, &locked) {
+		return false
+	}
+
+	seconds := int(math.Ceil(locked.RetryAfter.Seconds()))
+	c.Header("Retry-After", fmt.Sprint(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":      message,
+		"retryAfter": seconds,
+	})
+	return true
+}
+
+// 失敗したログイン試行の一覧（本人のアカウントへの試行のみ）
+func getLoginAttempts(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	var attempts []LoginAttempt
+
+	if err := db.Where("user_id = ?", userID).Order("created_at DESC").Limit(100).Find(&attempts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch login attempts: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, attempts)
+}
+
+// 古い監査ログと期限切れの試行状態を削除
+func purgeLoginAttempts() {
+	cutoff := time.Now().AddDate(0, 0, -90)
+	result := db.Where("created_at < ?", cutoff).Delete(&LoginAttempt{})
+	if result.Error != nil {
+		log.Printf("[BATCH] ERROR: Failed to purge login attempts: %v", result.Error)
+		return
+	}
+
+	throttles := db.Where("expires_at < ?", time.Now()).Delete(&LoginThrottle{})
+	if throttles.Error != nil {
+		log.Printf("[BATCH] ERROR: Failed to purge login throttles: %v", throttles.Error)
+		return
+	}
+
+	log.Printf("[BATCH] Purged %d login attempt(s) and %d throttle record(s)", result.RowsAffected, throttles.RowsAffected)
+}
+
+// メモリ上の保存先（単一インスタンス用）
+type memoryAttemptStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryAttemptEntry
+}
+
+type memoryAttemptEntry struct {
+	State     attemptState
+	ExpiresAt time.Time
+}
+
+func newMemoryAttemptStore() *memoryAttemptStore {
+	return &memoryAttemptStore{entries: make(map[string]memoryAttemptEntry)}
+}
+
+func (s *memoryAttemptStore) Get(key string) (attemptState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if !ok || time.Now().After(entry.ExpiresAt) {
+		delete(s.entries, key)
+		return attemptState{}, nil
+	}
+	return entry.State, nil
+}
+
+func (s *memoryAttemptStore) Increment(key string, ttl time.Duration) (attemptState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	entry, ok := s.entries[key]
+	if !ok || now.After(entry.ExpiresAt) {
+		entry = memoryAttemptEntry{}
+	}
+	entry.State.Failures++
+	entry.ExpiresAt = now.Add(ttl)
+	s.entries[key] = entry
+
+	// 期限切れのエントリを掃除してメモリの増加を防ぐ
+	if len(s.entries) > 10000 {
+		for k, entry := range s.entries {
+			if now.After(entry.ExpiresAt) {
+				delete(s.entries, k)
+			}
+		}
+	}
+	return entry.State, nil
+}
+
+func (s *memoryAttemptStore) Lock(key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[key]
+	if ok && until.After(entry.State.LockedUntil) {
+		entry.State.LockedUntil = until
+		s.entries[key] = entry
+	}
+	return nil
+}
+
+func (s *memoryAttemptStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+// データベース上の保存先（複数インスタンスで共有）
+type databaseAttemptStore struct{}
+
+func (s *databaseAttemptStore) Get(key string) (attemptState, error) {
+	var record LoginThrottle
+	result := db.Where("throttle_key = ? AND expires_at > ?", key, time.Now()).Limit(1).Find(&record)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return attemptState{}, result.Error
+	}
+	return attemptState{Failures: record.Failures, LockedUntil: record.LockedUntil}, nil
+}
+
+// 行がなければ作成し、あれば同じ文の中で加算する（期限切れの行は1からやり直す）
+//
+// MySQLは代入を左から順に評価するため、expires_at は最後に更新する。
+func (s *databaseAttemptStore) Increment(key string, ttl time.Duration) (attemptState, error) {
+	now := time.Now()
+	record := LoginThrottle{
+		ThrottleKey: key,
+		Failures:    1,
+		ExpiresAt:   now.Add(ttl),
+	}
+	err := db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "throttle_key"}},
+		DoUpdates: clause.Set{
+			{Column: clause.Column{Name: "failures"}, Value: gorm.Expr("CASE WHEN login_throttles.expires_at > ? THEN login_throttles.failures + 1 ELSE 1 END", now)},
+			{Column: clause.Column{Name: "locked_until"}, Value: gorm.Expr("CASE WHEN login_throttles.expires_at > ? THEN login_throttles.locked_until ELSE ? END", now, time.Time{})},
+			{Column: clause.Column{Name: "expires_at"}, Value: record.ExpiresAt},
+		},
+	}).Create(&record).Error
+	if err != nil {
+		return attemptState{}, err
+	}
+
+	if err := db.Where("throttle_key = ?", key).First(&record).Error; err != nil {
+		return attemptState{}, err
+	}
+	return attemptState{Failures: record.Failures, LockedUntil: record.LockedUntil}, nil
+}
+
+func (s *databaseAttemptStore) Lock(key string, until time.Time) error {
+	return db.Model(&LoginThrottle{}).Where("throttle_key = ? AND locked_until < ?", key, until).
+		Update("locked_until", until).Error
+}
+
+func (s *databaseAttemptStore) Delete(key string) error {
+	return db.Where("throttle_key = ?", key).Delete(&LoginThrottle{}).Error
+}
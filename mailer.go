@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"mime"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// メール
+type Mail struct {
+	To      string
+	Subject string
+	Body    string // プレーンテキスト
+}
+
+// メール送信
+type Mailer interface {
+	Send(mail Mail) error
+}
+
+var mailer Mailer
+
+// 環境変数からメール送信方法を選択する
+//
+// MAIL_DRIVER=smtp の場合は SMTP_HOST / SMTP_PORT / SMTP_USERNAME / SMTP_PASSWORD でSMTPサーバーへ送信する
+// （ローカルでは MailHog などを使う）。それ以外の場合はログに出力し、MAIL_DIR が指定されていれば .eml ファイルとして保存する。
+func newMailerFromEnv() Mailer {
+	from := getEnv("MAIL_FROM", "MoneyTracker <no-reply@moneytracker.local>")
+
+	switch strings.ToLower(getEnv("MAIL_DRIVER", "log")) {
+	case "smtp":
+		return &smtpMailer{
+			Host:     getEnv("SMTP_HOST", "localhost"),
+			Port:     getEnv("SMTP_PORT", "1025"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     from,
+		}
+	default:
+		return &logMailer{Dir: os.Getenv("MAIL_DIR"), From: from}
+	}
+}
+
+// メッセージ本文（ヘッダー付き）を組み立てる
+func buildMessage(from string, mail Mail) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", mail.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("UTF-8", mail.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(mail.Body, "\n", "\r\n"))
+	return buf.Bytes()
+}
+
+// SMTPサーバーへ送信する
+type smtpMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func (m *smtpMailer) Send(mail Mail) error {
+	// 認証なしのSMTPサーバー（MailHogなど）にも対応する
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	sender := m.From
+	if start := strings.Index(sender, "<"); start >= 0 {
+		sender = strings.TrimSuffix(sender[start+1:], ">")
+	}
+
+	return smtp.SendMail(m.Host+":"+m.Port, auth, sender, []string{mail.To}, buildMessage(m.From, mail))
+}
+
+// 開発用：ログに出力し、必要に応じてファイルに保存する
+type logMailer struct {
+	Dir  string
+	From string
+}
+
+func (m *logMailer) Send(mail Mail) error {
+	log.Printf("[MAIL] To: %s, Subject: %s\n%s", mail.To, mail.Subject, mail.Body)
+	if m.Dir == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102-150405.000000000"), strings.NewReplacer("@", "_at_", "/", "_").Replace(mail.To))
+	return os.WriteFile(filepath.Join(m.Dir, name), buildMessage(m.From, mail), 0o644)
+}
@@ -3,21 +3,50 @@ package main
 import (
 	"log"
 	"os"
+	"strings"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 )
 
 var db *gorm.DB
 
 func main() {
+	// マイグレーション用サブコマンド
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		connectDB()
+		runMigrateCommand(os.Args[2:])
+		return
+	}
+
+	// JWT署名鍵の読み込み
+	keys, err := loadSigningKeys()
+	if err != nil {
+		log.Fatal("Invalid JWT key configuration: ", err)
+	}
+	jwtKeys = keys
+	log.Printf("Signing tokens with JWT key %q (%s), %d key(s) accepted", keys.Active.ID, keys.Active.Method.Alg(), len(keys.Keys))
+
+	// メール送信設定
+	mailer = newMailerFromEnv()
+
+	// 添付ファイルの保存先
+	storage, err := newAttachmentStorageFromEnv()
+	if err != nil {
+		log.Fatal("Invalid attachment storage configuration: ", err)
+	}
+	attachmentStorage = storage
+
+	// ログイン試行回数の保存先
+	loginAttempts = newAttemptStoreFromEnv()
+
 	// データベース初期化
 	initDB()
 
 	// 自動スケジューラーを開始
 	startScheduler()
+	startDailyMaintenance()
 
 	// サーバー起動時に当月の処理をチェック
 	checkAndProcessCurrentMonth()
@@ -25,6 +54,12 @@ func main() {
 	// Ginルーター設定
 	r := gin.Default()
 
+	// X-Forwarded-For を信頼するプロキシ（nginx）。ログイン制限などでクライアントIPを判定するため
+	trustedProxies := strings.Split(getEnv("TRUSTED_PROXIES", "127.0.0.1,::1,10.0.0.0/8,172.16.0.0/12,192.168.0.0/16"), ",")
+	if err := r.SetTrustedProxies(trustedProxies); err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES: ", err)
+	}
+
 	// CORS設定
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:3000"},
@@ -33,64 +68,178 @@ func main() {
 		AllowCredentials: true,
 	}))
 
+	// トークン検証用の公開鍵（RS256 / EdDSA）
+	r.GET("/.well-known/jwks.json", getJWKS)
+
 	// API routes
 	api := r.Group("/api")
 	{
 		// 認証関連
 		api.POST("/register", register)
 		api.POST("/login", login)
-		api.POST("/logout", logout)
-		api.GET("/me", authMiddleware(), getCurrentUser)
+		api.POST("/login/2fa", loginTwoFactor)
+		api.POST("/refresh", refreshTokens)
+		api.GET("/me", authMiddleware(), requireScope(scopeRead), getCurrentUser)
+
+		// パスワード再設定・メールアドレス確認
+		api.POST("/password/forgot", forgotPassword)
+		api.POST("/password/reset", resetPassword)
+		api.GET("/verify-email", verifyEmail)
 
-		// 認証が必要なルート
+		// 削除予定のアカウントの復元
+		api.POST("/account/restore", restoreUserAccount)
+
+		// ログインセッションでのみ使えるルート（アクセストークンでは不可）
+		session := api.Group("/")
+		session.Use(authMiddleware(), requireSession())
+		{
+			session.POST("logout", logout)
+			session.PUT("me", updateProfile)
+			session.DELETE("me", deleteUserAccount)
+			session.POST("me/password", changePassword)
+			session.PUT("me/currency", updateBaseCurrency)
+			session.GET("me/login-attempts", getLoginAttempts)
+			session.POST("verify-email/resend", resendVerificationEmail)
+
+			// 2段階認証
+			session.POST("2fa/setup", setupTwoFactor)
+			session.POST("2fa/confirm", confirmTwoFactor)
+			session.POST("2fa/disable", disableTwoFactor)
+			session.POST("2fa/recovery-codes", regenerateRecoveryCodes)
+
+			// パーソナルアクセストークン
+			session.GET("tokens", getPersonalAccessTokens)
+			session.POST("tokens", createPersonalAccessToken)
+			session.DELETE("tokens/:id", deletePersonalAccessToken)
+
+			// データの復元（新しいアカウントへの移行）
+			session.POST("me/import", importUserData)
+		}
+
+		// 認証が必要なルート（アクセストークンはスコープごとに制限）
 		protected := api.Group("/")
 		protected.Use(authMiddleware())
+		if os.Getenv("REQUIRE_EMAIL_VERIFICATION") == "true" {
+			protected.Use(requireVerifiedEmail())
+		}
+
+		// 参照（read）
+		readable := protected.Group("/", requireScope(scopeRead))
+		{
+			readable.GET("transactions", getTransactions)
+			readable.GET("transactions/export", exportTransactions)
+			readable.GET("transactions/duplicates", getDuplicateTransactions)
+			readable.GET("transactions/suggest-category", suggestCategory)
+			readable.GET("transactions/:id", getTransaction)
+			readable.GET("transactions/:id/attachments", getAttachments)
+			readable.GET("attachments/:id", downloadAttachment)
+			readable.GET("accounts", getAccounts)
+			readable.GET("accounts/:id", getAccount)
+			readable.GET("categories", getCategories)
+			readable.GET("tags", getTags)
+			readable.GET("category-rules", getCategoryRules)
+			readable.GET("category-rules/preview", previewCategoryRules)
+			readable.GET("payees", getPayees)
+			readable.GET("exchange-rates", getExchangeRates)
+			readable.GET("import-profiles", getImportProfiles)
+			readable.GET("me/export", exportUserData)
+
+			// 統計・集計
+			readable.GET("stats", getStats)
+			readable.GET("summary/monthly", getMonthlySummary)
+			readable.GET("summary/category", getCategorySummary)
+			readable.GET("summary/tag", getTagSummary)
+			readable.GET("summary/payee", getPayeeSummary)
+			readable.GET("summary/daily", getDailySummary)
+			readable.GET("analytics/spending-prediction", getSpendingPrediction)
+
+			// 予算・固定費
+			readable.GET("budget/:year/:month", getBudget)
+			readable.GET("fixed-expenses", getFixedExpenses)
+			readable.GET("budget/analysis/:year/:month", getBudgetAnalysis)
+			readable.GET("budget/remaining/:year/:month", getRemainingBudget)
+			readable.GET("budget/history", getBudgetHistory)
+			readable.GET("category-budgets/:year/:month", getCategoryBudgets)
+			readable.GET("category-budgets/analysis/:year/:month", getCategoryBudgetAnalysis)
+		}
+
+		// 取引の登録・変更（transactions:write）
+		transactionWrites := protected.Group("/", requireScope(scopeTransactionsWrite))
 		{
 			// 取引関連
-			protected.GET("transactions", getTransactions)
-			protected.POST("transactions", createTransaction)
-			protected.PUT("transactions/:id", updateTransaction)
-			protected.DELETE("transactions/:id", deleteTransaction)
-			protected.GET("transactions/:id", getTransaction)
+			transactionWrites.POST("transactions", createTransaction)
+			transactionWrites.PUT("transactions/:id", updateTransaction)
+			transactionWrites.DELETE("transactions/:id", deleteTransaction)
+			transactionWrites.POST("transactions/merge", mergeTransactions)
+
+			// レシート・書類の添付
+			transactionWrites.POST("transactions/:id/attachments", uploadAttachment)
+			transactionWrites.DELETE("attachments/:id", deleteAttachment)
+
+			// CSV取込（銀行・カードの明細）
+			transactionWrites.POST("transactions/import/preview", previewCSVImport)
+			transactionWrites.POST("transactions/import", commitCSVImport)
+			transactionWrites.POST("import-profiles", createImportProfile)
+			transactionWrites.PUT("import-profiles/:id", updateImportProfile)
+			transactionWrites.DELETE("import-profiles/:id", deleteImportProfile)
+
+			// OFX/QFX・QIF明細の取込
+			transactionWrites.POST("transactions/import/statement", importStatement)
+
+			// 口座関連
+			transactionWrites.POST("accounts", createAccount)
+			transactionWrites.PUT("accounts/:id", updateAccount)
+			transactionWrites.DELETE("accounts/:id", deleteAccount)
 
 			// カテゴリ関連
-			protected.GET("categories", getCategories)
-			protected.POST("categories", createCategory)
-			protected.PUT("categories/:id", updateCategory)
-			protected.DELETE("categories/:id", deleteCategory)
+			transactionWrites.POST("categories", createCategory)
+			transactionWrites.PUT("categories/:id", updateCategory)
+			transactionWrites.DELETE("categories/:id", deleteCategory)
 
-			// 統計・集計
-			protected.GET("stats", getStats)
-			protected.GET("summary/monthly", getMonthlySummary)
-			protected.GET("summary/category", getCategorySummary)
-			protected.GET("summary/daily", getDailySummary)
-			protected.GET("analytics/spending-prediction", getSpendingPrediction)
+			// タグ関連
+			transactionWrites.POST("tags", createTag)
+			transactionWrites.PUT("tags/:id", updateTag)
+			transactionWrites.POST("tags/:id/merge", mergeTag)
+			transactionWrites.DELETE("tags/:id", deleteTag)
 
+			// 自動振り分けルール
+			transactionWrites.POST("category-rules", createCategoryRule)
+			transactionWrites.PUT("category-rules/:id", updateCategoryRule)
+			transactionWrites.DELETE("category-rules/:id", deleteCategoryRule)
+			transactionWrites.POST("category-rules/apply", applyCategoryRules)
+
+			// 支払先
+			transactionWrites.POST("payees", createPayee)
+			transactionWrites.PUT("payees/:id", updatePayee)
+			transactionWrites.DELETE("payees/:id", deletePayee)
+			transactionWrites.POST("payees/:id/merge", mergePayee)
+			transactionWrites.POST("payees/match", matchPayees)
+
+			// 為替レート関連
+			transactionWrites.POST("exchange-rates", createExchangeRate)
+			transactionWrites.POST("exchange-rates/upload", uploadExchangeRates)
+			transactionWrites.DELETE("exchange-rates/:id", deleteExchangeRate)
+		}
+
+		// 予算の登録・変更（budgets:write）
+		budgetWrites := protected.Group("/", requireScope(scopeBudgetsWrite))
+		{
 			// 予算関連
-			protected.GET("budget/:year/:month", getBudget)
-			protected.POST("budget", createBudget)
-			protected.PUT("budget/:id", updateBudget)
-			protected.DELETE("budget/:id", deleteBudget)
+			budgetWrites.POST("budget", createBudget)
+			budgetWrites.PUT("budget/:id", updateBudget)
+			budgetWrites.DELETE("budget/:id", deleteBudget)
+			// 廃止機能のクリーンアップ用
+			budgetWrites.DELETE("budget/cleanup-monthly", deleteAllMonthlyBudgets)
 
 			// 固定費関連
-			protected.GET("fixed-expenses", getFixedExpenses)
-			protected.POST("fixed-expenses", createFixedExpense)
-			protected.PUT("fixed-expenses/:id", updateFixedExpense)
-			protected.DELETE("fixed-expenses/:id", deleteFixedExpense)
-
-			// 予算分析関連
-			protected.GET("budget/analysis/:year/:month", getBudgetAnalysis)
-			protected.GET("budget/remaining/:year/:month", getRemainingBudget)
-			protected.GET("budget/history", getBudgetHistory)
-			// 廃止機能のクリーンアップ用
-			protected.DELETE("budget/cleanup-monthly", deleteAllMonthlyBudgets)
+			budgetWrites.POST("fixed-expenses", createFixedExpense)
+			budgetWrites.PUT("fixed-expenses/:id", updateFixedExpense)
+			budgetWrites.DELETE("fixed-expenses/:id", deleteFixedExpense)
 
 			// カテゴリ別予算関連
-			protected.GET("category-budgets/:year/:month", getCategoryBudgets)
-			protected.POST("category-budgets", createCategoryBudget)
-			protected.PUT("category-budgets/:id", updateCategoryBudget)
-			protected.DELETE("category-budgets/:id", deleteCategoryBudget)
-			protected.GET("category-budgets/analysis/:year/:month", getCategoryBudgetAnalysis)
+			budgetWrites.POST("category-budgets", createCategoryBudget)
+			budgetWrites.PUT("category-budgets/:id", updateCategoryBudget)
+			budgetWrites.DELETE("category-budgets/:id", deleteCategoryBudget)
 		}
 	}
 
@@ -104,18 +253,29 @@ func main() {
 }
 
 func initDB() {
-	var err error
+	connectDB()
+
+	// 未適用のマイグレーションを適用
+	runStartupMigrations()
 
-	// 開発環境ではSQLite
-	db, err = gorm.Open(sqlite.Open("moneytracker.db"), &gorm.Config{})
+	// 初期データ投入
+	seedData()
 
+	// 全文検索の索引を整える
+	syncSearchIndex()
+}
+
+func connectDB() {
+	config, err := loadDBConfig()
 	if err != nil {
-		log.Fatal("Failed to connect to database:", err)
+		log.Fatal("Invalid database configuration:", err)
 	}
 
-	// マイグレーション
-	db.AutoMigrate(&User{}, &Category{}, &Transaction{}, &Budget{}, &FixedExpense{}, &CategoryBudget{})
-
-	// 初期データ投入
-	seedData()
+	// 環境変数に応じてSQLite / MySQL / PostgreSQLを選択
+	db, err = openDatabase(config)
+	if err != nil {
+		log.Fatal("Failed to connect to database:", err)
+	}
+	dbDriver = config.Driver
+	log.Printf("Connected to %s database", dbDriver)
 }
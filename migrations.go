@@ -0,0 +1,964 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// スキーママイグレーション
+//
+// Up / Down はトランザクション内で実行される（MySQLのDDLは暗黙的にコミットされる点に注意）。
+// 一度リリースしたマイグレーションは書き換えず、変更は新しいバージョンとして追加すること。
+type migration struct {
+	Version int
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+// 適用済みマイグレーションの記録
+type SchemaMigration struct {
+	Version   int    `gorm:"primaryKey;autoIncrement:false"`
+	Name      string `gorm:"size:255"`
+	AppliedAt time.Time
+}
+
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// マイグレーション一覧（バージョン順）
+var migrations = []migration{
+	{
+		Version: 1,
+		Name:    "create_initial_schema",
+		Up: func(tx *gorm.DB) error {
+			// 当時のモデル定義のスナップショット（既存のAutoMigrate済みDBとも互換）
+			type user struct {
+				ID        uint   `gorm:"primaryKey"`
+				Email     string `gorm:"size:255;unique;not null"`
+				Password  string `gorm:"not null"`
+				Name      string
+				CreatedAt time.Time
+				UpdatedAt time.Time
+			}
+			type category struct {
+				ID          uint `gorm:"primaryKey"`
+				UserID      uint
+				Name        string
+				Type        string
+				Color       string
+				Icon        string
+				Description string
+				CreatedAt   time.Time
+			}
+			type transaction struct {
+				ID          uint `gorm:"primaryKey"`
+				UserID      uint
+				Type        string
+				Amount      float64
+				CategoryID  uint
+				Description string
+				Date        time.Time
+				CreatedAt   time.Time
+				UpdatedAt   time.Time
+			}
+			type budget struct {
+				ID        uint `gorm:"primaryKey"`
+				UserID    uint
+				Year      int
+				Month     int
+				Amount    float64
+				CreatedAt time.Time
+				UpdatedAt time.Time
+			}
+			type fixedExpense struct {
+				ID             uint `gorm:"primaryKey"`
+				UserID         uint
+				Name           string
+				Amount         float64
+				Type           string `gorm:"default:expense"`
+				CategoryID     uint
+				Description    string
+				IsActive       bool `gorm:"default:true"`
+				AutoRegister   bool `gorm:"default:false"`
+				RegisterDay    int  `gorm:"default:1"`
+				LastRegistered *time.Time
+				CreatedAt      time.Time
+				UpdatedAt      time.Time
+			}
+			type categoryBudget struct {
+				ID         uint `gorm:"primaryKey"`
+				UserID     uint
+				CategoryID uint
+				Year       int
+				Month      int
+				Amount     float64
+				CreatedAt  time.Time
+				UpdatedAt  time.Time
+			}
+
+			tables := []struct {
+				name  string
+				model interface{}
+			}{
+				{"users", &user{}},
+				{"categories", &category{}},
+				{"transactions", &transaction{}},
+				{"budgets", &budget{}},
+				{"fixed_expenses", &fixedExpense{}},
+				{"category_budgets", &categoryBudget{}},
+			}
+			for _, table := range tables {
+				if err := tx.Table(table.name).AutoMigrate(table.model); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("category_budgets", "fixed_expenses", "budgets", "transactions", "categories", "users")
+		},
+	},
+	{
+		Version: 2,
+		Name:    "convert_emoji_icons_to_commercial_icons",
+		Up:      updateEmojiIconsToCommercialIcons,
+		// 元が絵文字だったかどうかは判別できないため戻さない
+		Down: func(tx *gorm.DB) error { return nil },
+	},
+	{
+		Version: 3,
+		Name:    "ensure_default_categories_for_existing_users",
+		Up:      ensureAllUsersHaveDefaultCategories,
+		// ユーザーが使用中の可能性があるため追加したカテゴリは削除しない
+		Down: func(tx *gorm.DB) error { return nil },
+	},
+	{
+		Version: 4,
+		Name:    "convert_amounts_to_integer_minor_units",
+		Up: func(tx *gorm.DB) error {
+			// 浮動小数点の金額を1/100単位の整数に変換してから列の型を変更する
+			type amountColumn struct {
+				Amount int64
+			}
+			for _, table := range moneyTables {
+				if err := tx.Exec("UPDATE " + table + " SET amount = ROUND(amount * 100)").Error; err != nil {
+					return err
+				}
+				if err := tx.Table(table).Migrator().AlterColumn(&amountColumn{}, "Amount"); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			type amountColumn struct {
+				Amount float64
+			}
+			for _, table := range moneyTables {
+				if err := tx.Table(table).Migrator().AlterColumn(&amountColumn{}, "Amount"); err != nil {
+					return err
+				}
+				if err := tx.Exec("UPDATE " + table + " SET amount = amount / 100.0").Error; err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 5,
+		Name:    "add_currencies_and_exchange_rates",
+		Up: func(tx *gorm.DB) error {
+			type userCurrency struct {
+				BaseCurrency string `gorm:"size:3;default:JPY"`
+			}
+			type amountCurrency struct {
+				Currency string `gorm:"size:3;default:JPY"`
+			}
+			type exchangeRate struct {
+				ID           uint      `gorm:"primaryKey"`
+				UserID       uint      `gorm:"uniqueIndex:idx_exchange_rates_user_pair_date"`
+				Date         time.Time `gorm:"uniqueIndex:idx_exchange_rates_user_pair_date"`
+				FromCurrency string    `gorm:"size:3;uniqueIndex:idx_exchange_rates_user_pair_date"`
+				ToCurrency   string    `gorm:"size:3;uniqueIndex:idx_exchange_rates_user_pair_date"`
+				Rate         float64
+				CreatedAt    time.Time
+				UpdatedAt    time.Time
+			}
+
+			if err := tx.Table("users").Migrator().AddColumn(&userCurrency{}, "BaseCurrency"); err != nil {
+				return err
+			}
+			for _, table := range []string{"transactions", "fixed_expenses"} {
+				if err := tx.Table(table).Migrator().AddColumn(&amountCurrency{}, "Currency"); err != nil {
+					return err
+				}
+			}
+
+			// 既存のデータはすべて円として扱う
+			if err := tx.Exec("UPDATE users SET base_currency = 'JPY' WHERE base_currency IS NULL OR base_currency = ''").Error; err != nil {
+				return err
+			}
+			for _, table := range []string{"transactions", "fixed_expenses"} {
+				if err := tx.Exec("UPDATE " + table + " SET currency = 'JPY' WHERE currency IS NULL OR currency = ''").Error; err != nil {
+					return err
+				}
+			}
+
+			return tx.Table("exchange_rates").AutoMigrate(&exchangeRate{})
+		},
+		Down: func(tx *gorm.DB) error {
+			type userCurrency struct {
+				BaseCurrency string
+			}
+			type amountCurrency struct {
+				Currency string
+			}
+
+			if err := tx.Migrator().DropTable("exchange_rates"); err != nil {
+				return err
+			}
+			if err := tx.Table("users").Migrator().DropColumn(&userCurrency{}, "BaseCurrency"); err != nil {
+				return err
+			}
+			for _, table := range []string{"transactions", "fixed_expenses"} {
+				if err := tx.Table(table).Migrator().DropColumn(&amountCurrency{}, "Currency"); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 6,
+		Name:    "add_accounts_and_transfers",
+		Up: func(tx *gorm.DB) error {
+			type account struct {
+				ID             uint `gorm:"primaryKey"`
+				UserID         uint `gorm:"index"`
+				Name           string
+				Type           string
+				Currency       string `gorm:"size:3;default:JPY"`
+				OpeningBalance int64
+				IsDefault      bool `gorm:"default:false"`
+				IsActive       bool `gorm:"default:true"`
+				CreatedAt      time.Time
+				UpdatedAt      time.Time
+			}
+			type transactionAccount struct {
+				AccountID   uint  `gorm:"index"`
+				ToAccountID *uint `gorm:"index"`
+				ToAmount    int64 `gorm:"default:0"`
+			}
+			type fixedExpenseAccount struct {
+				AccountID uint
+			}
+
+			if err := tx.Table("accounts").AutoMigrate(&account{}); err != nil {
+				return err
+			}
+			if err := tx.Table("transactions").AutoMigrate(&transactionAccount{}); err != nil {
+				return err
+			}
+			if err := tx.Table("fixed_expenses").AutoMigrate(&fixedExpenseAccount{}); err != nil {
+				return err
+			}
+			return assignAccountsToExistingData(tx)
+		},
+		Down: func(tx *gorm.DB) error {
+			type transactionAccount struct {
+				AccountID   uint  `gorm:"index"`
+				ToAccountID *uint `gorm:"index"`
+				ToAmount    int64
+			}
+			type fixedExpenseAccount struct {
+				AccountID uint
+			}
+
+			// 振替は収支として表現できないため削除する
+			if err := tx.Exec("DELETE FROM transactions WHERE type = 'transfer'").Error; err != nil {
+				return err
+			}
+			for _, index := range []string{"AccountID", "ToAccountID"} {
+				if tx.Table("transactions").Migrator().HasIndex(&transactionAccount{}, index) {
+					if err := tx.Table("transactions").Migrator().DropIndex(&transactionAccount{}, index); err != nil {
+						return err
+					}
+				}
+			}
+			for _, column := range []string{"AccountID", "ToAccountID", "ToAmount"} {
+				if err := tx.Table("transactions").Migrator().DropColumn(&transactionAccount{}, column); err != nil {
+					return err
+				}
+			}
+			if err := tx.Table("fixed_expenses").Migrator().DropColumn(&fixedExpenseAccount{}, "AccountID"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropTable("accounts")
+		},
+	},
+	{
+		Version: 7,
+		Name:    "add_sessions_and_refresh_tokens",
+		Up: func(tx *gorm.DB) error {
+			type session struct {
+				ID            uint `gorm:"primaryKey"`
+				UserID        uint `gorm:"index"`
+				UserAgent     string
+				IPAddress     string `gorm:"size:45"`
+				LastUsedAt    time.Time
+				ExpiresAt     time.Time
+				RevokedAt     *time.Time
+				RevokedReason string
+				CreatedAt     time.Time
+			}
+			type refreshToken struct {
+				ID        uint   `gorm:"primaryKey"`
+				SessionID uint   `gorm:"index"`
+				TokenHash string `gorm:"size:64;uniqueIndex"`
+				ExpiresAt time.Time
+				UsedAt    *time.Time
+				CreatedAt time.Time
+			}
+
+			if err := tx.Table("sessions").AutoMigrate(&session{}); err != nil {
+				return err
+			}
+			return tx.Table("refresh_tokens").AutoMigrate(&refreshToken{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("refresh_tokens", "sessions")
+		},
+	},
+	{
+		Version: 8,
+		Name:    "add_email_verification_and_user_tokens",
+		Up: func(tx *gorm.DB) error {
+			type userEmailVerified struct {
+				EmailVerified bool `gorm:"default:false"`
+			}
+			type userToken struct {
+				ID        uint   `gorm:"primaryKey"`
+				UserID    uint   `gorm:"index"`
+				Purpose   string `gorm:"size:32"`
+				TokenHash string `gorm:"size:64;uniqueIndex"`
+				ExpiresAt time.Time
+				UsedAt    *time.Time
+				CreatedAt time.Time
+			}
+
+			if err := tx.Table("users").Migrator().AddColumn(&userEmailVerified{}, "EmailVerified"); err != nil {
+				return err
+			}
+			return tx.Table("user_tokens").AutoMigrate(&userToken{})
+		},
+		Down: func(tx *gorm.DB) error {
+			type userEmailVerified struct {
+				EmailVerified bool
+			}
+
+			if err := tx.Migrator().DropTable("user_tokens"); err != nil {
+				return err
+			}
+			return tx.Table("users").Migrator().DropColumn(&userEmailVerified{}, "EmailVerified")
+		},
+	},
+	{
+		Version: 9,
+		Name:    "add_two_factor_authentication",
+		Up: func(tx *gorm.DB) error {
+			type userTOTP struct {
+				TOTPEnabled  bool   `gorm:"default:false"`
+				TOTPSecret   string `gorm:"size:64"`
+				TOTPLastStep int64  `gorm:"default:0"`
+			}
+			type recoveryCode struct {
+				ID        uint   `gorm:"primaryKey"`
+				UserID    uint   `gorm:"index"`
+				CodeHash  string `gorm:"size:64"`
+				UsedAt    *time.Time
+				CreatedAt time.Time
+			}
+
+			for _, field := range []string{"TOTPEnabled", "TOTPSecret", "TOTPLastStep"} {
+				if err := tx.Table("users").Migrator().AddColumn(&userTOTP{}, field); err != nil {
+					return err
+				}
+			}
+			return tx.Table("recovery_codes").AutoMigrate(&recoveryCode{})
+		},
+		Down: func(tx *gorm.DB) error {
+			type userTOTP struct {
+				TOTPEnabled  bool
+				TOTPSecret   string
+				TOTPLastStep int64
+			}
+
+			if err := tx.Migrator().DropTable("recovery_codes"); err != nil {
+				return err
+			}
+			for _, field := range []string{"TOTPEnabled", "TOTPSecret", "TOTPLastStep"} {
+				if err := tx.Table("users").Migrator().DropColumn(&userTOTP{}, field); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 10,
+		Name:    "add_login_attempts_and_throttles",
+		Up: func(tx *gorm.DB) error {
+			type loginAttempt struct {
+				ID        uint   `gorm:"primaryKey"`
+				Email     string `gorm:"size:255;index"`
+				UserID    *uint  `gorm:"index"`
+				IPAddress string `gorm:"size:45;index"`
+				UserAgent string
+				Reason    string    `gorm:"size:32"`
+				CreatedAt time.Time `gorm:"index"`
+			}
+			type loginThrottle struct {
+				ThrottleKey string `gorm:"primaryKey;size:255"`
+				Failures    int
+				LockedUntil time.Time
+				ExpiresAt   time.Time `gorm:"index"`
+			}
+
+			if err := tx.Table("login_attempts").AutoMigrate(&loginAttempt{}); err != nil {
+				return err
+			}
+			return tx.Table("login_throttles").AutoMigrate(&loginThrottle{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("login_throttles", "login_attempts")
+		},
+	},
+	{
+		Version: 11,
+		Name:    "add_personal_access_tokens",
+		Up: func(tx *gorm.DB) error {
+			type personalAccessToken struct {
+				ID          uint `gorm:"primaryKey"`
+				UserID      uint `gorm:"index"`
+				Name        string
+				TokenPrefix string `gorm:"size:16"`
+				TokenHash   string `gorm:"size:64;uniqueIndex"`
+				Scopes      string `gorm:"size:255"`
+				ExpiresAt   *time.Time
+				LastUsedAt  *time.Time
+				CreatedAt   time.Time
+			}
+
+			return tx.Table("personal_access_tokens").AutoMigrate(&personalAccessToken{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("personal_access_tokens")
+		},
+	},
+	{
+		Version: 12,
+		Name:    "add_pending_email_and_account_deletion",
+		Up: func(tx *gorm.DB) error {
+			type userProfile struct {
+				PendingEmail        string `gorm:"size:255"`
+				DeletionScheduledAt *time.Time
+			}
+
+			for _, field := range []string{"PendingEmail", "DeletionScheduledAt"} {
+				if err := tx.Table("users").Migrator().AddColumn(&userProfile{}, field); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		Down: func(tx *gorm.DB) error {
+			type userProfile struct {
+				PendingEmail        string
+				DeletionScheduledAt *time.Time
+			}
+
+			for _, field := range []string{"PendingEmail", "DeletionScheduledAt"} {
+				if err := tx.Table("users").Migrator().DropColumn(&userProfile{}, field); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	},
+	{
+		Version: 13,
+		Name:    "add_import_profiles",
+		Up: func(tx *gorm.DB) error {
+			type importProfile struct {
+				ID                       uint `gorm:"primaryKey"`
+				UserID                   uint `gorm:"index"`
+				Name                     string
+				Encoding                 string `gorm:"size:16"`
+				Delimiter                string `gorm:"size:1"`
+				SkipRows                 int
+				HasHeader                bool
+				DateColumn               string
+				DateFormat               string `gorm:"size:32"`
+				AmountColumn             string
+				AmountSign               string `gorm:"size:16"`
+				IncomeColumn             string
+				ExpenseColumn            string
+				DescriptionColumn        string
+				AccountID                uint
+				DefaultIncomeCategoryID  uint
+				DefaultExpenseCategoryID uint
+				CategoryRules            string `gorm:"type:text"`
+				CreatedAt                time.Time
+				UpdatedAt                time.Time
+			}
+
+			return tx.Table("import_profiles").AutoMigrate(&importProfile{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("import_profiles")
+		},
+	},
+	{
+		Version: 14,
+		Name:    "add_transaction_external_id",
+		Up: func(tx *gorm.DB) error {
+			type transactionExternalID struct {
+				ExternalID string `gorm:"size:64;index"`
+			}
+
+			return tx.Table("transactions").AutoMigrate(&transactionExternalID{})
+		},
+		Down: func(tx *gorm.DB) error {
+			type transactionExternalID struct {
+				ExternalID string `gorm:"size:64;index"`
+			}
+
+			if tx.Table("transactions").Migrator().HasIndex(&transactionExternalID{}, "ExternalID") {
+				if err := tx.Table("transactions").Migrator().DropIndex(&transactionExternalID{}, "ExternalID"); err != nil {
+					return err
+				}
+			}
+			return tx.Table("transactions").Migrator().DropColumn(&transactionExternalID{}, "ExternalID")
+		},
+	},
+	{
+		Version: 15,
+		Name:    "add_account_statement_ref",
+		Up: func(tx *gorm.DB) error {
+			type accountStatementRef struct {
+				StatementRef string `gorm:"size:64"`
+			}
+
+			return tx.Table("accounts").Migrator().AddColumn(&accountStatementRef{}, "StatementRef")
+		},
+		Down: func(tx *gorm.DB) error {
+			type accountStatementRef struct {
+				StatementRef string
+			}
+
+			return tx.Table("accounts").Migrator().DropColumn(&accountStatementRef{}, "StatementRef")
+		},
+	},
+	{
+		Version: 16,
+		Name:    "add_transaction_duplicate_of",
+		Up: func(tx *gorm.DB) error {
+			type transactionDuplicateOf struct {
+				DuplicateOfID *uint `gorm:"index"`
+			}
+
+			return tx.Table("transactions").AutoMigrate(&transactionDuplicateOf{})
+		},
+		Down: func(tx *gorm.DB) error {
+			type transactionDuplicateOf struct {
+				DuplicateOfID *uint `gorm:"index"`
+			}
+
+			if tx.Table("transactions").Migrator().HasIndex(&transactionDuplicateOf{}, "DuplicateOfID") {
+				if err := tx.Table("transactions").Migrator().DropIndex(&transactionDuplicateOf{}, "DuplicateOfID"); err != nil {
+					return err
+				}
+			}
+			return tx.Table("transactions").Migrator().DropColumn(&transactionDuplicateOf{}, "DuplicateOfID")
+		},
+	},
+	{
+		Version: 17,
+		Name:    "create_transaction_splits",
+		Up: func(tx *gorm.DB) error {
+			type transactionSplit struct {
+				ID            uint `gorm:"primaryKey"`
+				UserID        uint `gorm:"index"`
+				TransactionID uint `gorm:"index"`
+				CategoryID    uint `gorm:"index"`
+				Amount        int64
+				Memo          string
+			}
+
+			return tx.Table("transaction_splits").AutoMigrate(&transactionSplit{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("transaction_splits")
+		},
+	},
+	{
+		Version: 18,
+		Name:    "create_tags",
+		Up: func(tx *gorm.DB) error {
+			type tag struct {
+				ID        uint   `gorm:"primaryKey"`
+				UserID    uint   `gorm:"index"`
+				Name      string `gorm:"size:50"`
+				Color     string
+				CreatedAt time.Time
+			}
+			type transactionTag struct {
+				TransactionID uint `gorm:"primaryKey"`
+				TagID         uint `gorm:"primaryKey;index"`
+			}
+
+			if err := tx.Table("tags").AutoMigrate(&tag{}); err != nil {
+				return err
+			}
+			return tx.Table("transaction_tags").AutoMigrate(&transactionTag{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("transaction_tags", "tags")
+		},
+	},
+	{
+		Version: 19,
+		Name:    "create_attachments",
+		Up: func(tx *gorm.DB) error {
+			type attachment struct {
+				ID            uint   `gorm:"primaryKey"`
+				UserID        uint   `gorm:"index"`
+				TransactionID uint   `gorm:"index"`
+				FileName      string `gorm:"size:255"`
+				ContentType   string `gorm:"size:100"`
+				Size          int64
+				SHA256        string `gorm:"size:64"`
+				StorageKey    string `gorm:"size:255"`
+				ThumbnailKey  string `gorm:"size:255"`
+				CreatedAt     time.Time
+			}
+			return tx.Table("attachments").AutoMigrate(&attachment{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("attachments")
+		},
+	},
+	{
+		Version: 20,
+		Name:    "create_category_rules",
+		Up: func(tx *gorm.DB) error {
+			type categoryRule struct {
+				ID         uint `gorm:"primaryKey"`
+				UserID     uint `gorm:"index"`
+				Name       string
+				Priority   int
+				MatchType  string `gorm:"size:16"`
+				Pattern    string
+				Type       string `gorm:"size:16"`
+				MinAmount  *int64
+				MaxAmount  *int64
+				CategoryID uint   `gorm:"index"`
+				Tags       string `gorm:"type:text"`
+				Enabled    bool
+				CreatedAt  time.Time
+				UpdatedAt  time.Time
+			}
+			return tx.Table("category_rules").AutoMigrate(&categoryRule{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable("category_rules")
+		},
+	},
+	{
+		Version: 21,
+		Name:    "create_payees",
+		Up: func(tx *gorm.DB) error {
+			type payee struct {
+				ID                uint   `gorm:"primaryKey"`
+				UserID            uint   `gorm:"index"`
+				Name              string `gorm:"size:100"`
+				DefaultCategoryID *uint
+				CreatedAt         time.Time
+			}
+			type payeeAlias struct {
+				ID         uint   `gorm:"primaryKey"`
+				UserID     uint   `gorm:"index"`
+				PayeeID    uint   `gorm:"index"`
+				Alias      string `gorm:"size:100"`
+				Normalized string `gorm:"size:100;index"`
+			}
+			type transactionPayee struct {
+				PayeeID *uint `gorm:"index"`
+			}
+
+			if err := tx.Table("payees").AutoMigrate(&payee{}); err != nil {
+				return err
+			}
+			if err := tx.Table("payee_aliases").AutoMigrate(&payeeAlias{}); err != nil {
+				return err
+			}
+			return tx.Table("transactions").AutoMigrate(&transactionPayee{})
+		},
+		Down: func(tx *gorm.DB) error {
+			type transactionPayee struct {
+				PayeeID *uint `gorm:"index"`
+			}
+
+			if tx.Table("transactions").Migrator().HasIndex(&transactionPayee{}, "PayeeID") {
+				if err := tx.Table("transactions").Migrator().DropIndex(&transactionPayee{}, "PayeeID"); err != nil {
+					return err
+				}
+			}
+			if err := tx.Table("transactions").Migrator().DropColumn(&transactionPayee{}, "PayeeID"); err != nil {
+				return err
+			}
+			return tx.Migrator().DropTable("payee_aliases", "payees")
+		},
+	},
+	{
+		Version: 22,
+		Name:    "create_transaction_search",
+		Up: func(tx *gorm.DB) error {
+			type transactionSearch struct {
+				TransactionID uint   `gorm:"primaryKey;autoIncrement:false"`
+				UserID        uint   `gorm:"index"`
+				Description   string `gorm:"type:text"`
+				Payee         string `gorm:"type:text"`
+				Tags          string `gorm:"type:text"`
+				Category      string `gorm:"type:text"`
+			}
+
+			if err := tx.Table("transaction_searches").AutoMigrate(&transactionSearch{}); err != nil {
+				return err
+			}
+			// 索引の行は起動時に syncSearchIndex で登録する
+			switch dbDriver {
+			case driverMySQL:
+				return tx.Exec("CREATE FULLTEXT INDEX idx_transaction_searches_fulltext ON transaction_searches (description, payee, tags, category) WITH PARSER ngram").Error
+			case driverPostgres:
+				return tx.Exec("CREATE INDEX idx_transaction_searches_document ON transaction_searches USING GIN ((" + postgresSearchDocument + "))").Error
+			default:
+				_, err := createSearchFTS(tx)
+				return err
+			}
+		},
+		Down: func(tx *gorm.DB) error {
+			if dbDriver == driverSQLite {
+				if err := tx.Exec("DROP TABLE IF EXISTS transaction_searches_fts").Error; err != nil {
+					return err
+				}
+			}
+			return tx.Migrator().DropTable("transaction_searches")
+		},
+	},
+}
+
+// 金額（amount列）を持つテーブル
+var moneyTables = []string{"transactions", "budgets", "fixed_expenses", "category_budgets"}
+
+// マイグレーション管理テーブルを用意する
+func ensureMigrationTable() error {
+	return db.AutoMigrate(&SchemaMigration{})
+}
+
+// 適用済みマイグレーションをバージョンごとに取得
+func appliedMigrations() (map[int]SchemaMigration, error) {
+	if err := ensureMigrationTable(); err != nil {
+		return nil, err
+	}
+
+	var records []SchemaMigration
+	if err := db.Order("version ASC").Find(&records).Error; err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]SchemaMigration, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+// 未適用のマイグレーションを返す
+func pendingMigrations() ([]migration, error) {
+	applied, err := appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []migration
+	for _, m := range sortedMigrations() {
+		if _, ok := applied[m.Version]; !ok {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+func sortedMigrations() []migration {
+	sorted := make([]migration, len(migrations))
+	copy(sorted, migrations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	return sorted
+}
+
+// 未適用のマイグレーションを順に適用する（target が 0 の場合は最新まで）
+func migrateUp(target int) (int, error) {
+	pending, err := pendingMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, m := range pending {
+		if target > 0 && m.Version > target {
+			break
+		}
+
+		log.Printf("[MIGRATE] Applying %04d_%s", m.Version, m.Name)
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: m.Version, Name: m.Name, AppliedAt: time.Now().UTC()}).Error
+		})
+		if err != nil {
+			return count, fmt.Errorf("migration %04d_%s failed: %w", m.Version, m.Name, err)
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// 適用済みのマイグレーションを新しい順に steps 件ロールバックする
+func migrateDown(steps int) (int, error) {
+	applied, err := appliedMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	sorted := sortedMigrations()
+	count := 0
+	for i := len(sorted) - 1; i >= 0 && count < steps; i-- {
+		m := sorted[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+
+		log.Printf("[MIGRATE] Reverting %04d_%s", m.Version, m.Name)
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Down(tx); err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{}, m.Version).Error
+		})
+		if err != nil {
+			return count, fmt.Errorf("rollback of %04d_%s failed: %w", m.Version, m.Name, err)
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// マイグレーションの適用状況を表示する
+func printMigrationStatus() error {
+	applied, err := appliedMigrations()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%-8s %-50s %s\n", "VERSION", "NAME", "APPLIED AT")
+	for _, m := range sortedMigrations() {
+		status := "pending"
+		if record, ok := applied[m.Version]; ok {
+			status = record.AppliedAt.Format(time.RFC3339)
+		}
+		fmt.Printf("%04d     %-50s %s\n", m.Version, m.Name, status)
+	}
+	return nil
+}
+
+// サーバー起動時のマイグレーション
+//
+// DB_AUTO_MIGRATE=false の場合は自動適用せず、未適用のものがあれば起動を中止する。
+func runStartupMigrations() {
+	if os.Getenv("DB_AUTO_MIGRATE") == "false" {
+		pending, err := pendingMigrations()
+		if err != nil {
+			log.Fatal("Failed to check migrations:", err)
+		}
+		if len(pending) > 0 {
+			log.Fatalf("%d pending migration(s). Run `money-tracker migrate up` first.", len(pending))
+		}
+		return
+	}
+
+	count, err := migrateUp(0)
+	if err != nil {
+		log.Fatal("Failed to migrate database:", err)
+	}
+	if count > 0 {
+		log.Printf("[MIGRATE] Applied %d migration(s)", count)
+	}
+}
+
+// migrate サブコマンド
+//
+//	money-tracker migrate up [version]
+//	money-tracker migrate down [steps]
+//	money-tracker migrate status
+func runMigrateCommand(args []string) {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: money-tracker migrate up [version] | down [steps] | status")
+		os.Exit(2)
+	}
+
+	argument := func(fallback int) int {
+		if len(args) < 2 {
+			return fallback
+		}
+		value, err := strconv.Atoi(args[1])
+		if err != nil || value < 0 {
+			log.Fatalf("invalid argument: %s", args[1])
+		}
+		return value
+	}
+
+	switch args[0] {
+	case "up":
+		count, err := migrateUp(argument(0))
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("[MIGRATE] Applied %d migration(s)", count)
+	case "down":
+		count, err := migrateDown(argument(1))
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("[MIGRATE] Reverted %d migration(s)", count)
+	case "status":
+		if err := printMigrationStatus(); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown migrate command: %s\n", args[0])
+		os.Exit(2)
+	}
+}
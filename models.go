@@ -6,12 +6,19 @@ import (
 
 // ユーザー
 type User struct {
-	ID        uint      `json:"id" gorm:"primaryKey"`
-	Email     string    `json:"email" gorm:"unique;not null"`
-	Password  string    `json:"-" gorm:"not null"` // JSONには含めない
-	Name      string    `json:"name"`
-	CreatedAt time.Time `json:"createdAt"`
-	UpdatedAt time.Time `json:"updatedAt"`
+	ID                  uint       `json:"id" gorm:"primaryKey"`
+	Email               string     `json:"email" gorm:"size:255;unique;not null"`
+	Password            string     `json:"-" gorm:"not null"` // JSONには含めない
+	Name                string     `json:"name"`
+	BaseCurrency        string     `json:"baseCurrency" gorm:"size:3;default:JPY"` // 集計・予算に使う基準通貨
+	EmailVerified       bool       `json:"emailVerified" gorm:"default:false"`
+	TOTPEnabled         bool       `json:"totpEnabled" gorm:"default:false"`       // 2段階認証
+	TOTPSecret          string     `json:"-" gorm:"size:64"`                       // Base32
+	TOTPLastStep        int64      `json:"-"`                                      // 最後に使われたコードの時間ステップ（再利用防止）
+	PendingEmail        string     `json:"pendingEmail,omitempty" gorm:"size:255"` // 確認待ちの新しいメールアドレス
+	DeletionScheduledAt *time.Time `json:"deletionScheduledAt,omitempty"`          // この日時以降にデータを完全に削除する
+	CreatedAt           time.Time  `json:"createdAt"`
+	UpdatedAt           time.Time  `json:"updatedAt"`
 }
 
 // カテゴリ
@@ -28,16 +35,239 @@ type Category struct {
 
 // 取引記録
 type Transaction struct {
-	ID          uint      `json:"id" gorm:"primaryKey"`
-	UserID      uint      `json:"userId"`
-	Type        string    `json:"type"` // income, expense
-	Amount      float64   `json:"amount"`
-	CategoryID  uint      `json:"categoryId"`
-	Category    Category  `json:"category" gorm:"foreignKey:CategoryID"`
-	Description string    `json:"description"`
-	Date        time.Time `json:"date"`
-	CreatedAt   time.Time `json:"createdAt"`
-	UpdatedAt   time.Time `json:"updatedAt"`
+	ID            uint               `json:"id" gorm:"primaryKey"`
+	UserID        uint               `json:"userId"`
+	Type          string             `json:"type"` // income, expense, transfer
+	Amount        Money              `json:"amount"`
+	Currency      string             `json:"currency" gorm:"size:3;default:JPY"` // 口座の通貨
+	AccountID     uint               `json:"accountId" gorm:"index"`
+	ToAccountID   *uint              `json:"toAccountId,omitempty" gorm:"index"` // 振替先（transferのみ）
+	ToAmount      Money              `json:"toAmount,omitempty"`                 // 振替先口座の通貨での金額（transferのみ）
+	CategoryID    uint               `json:"categoryId"`
+	Category      Category           `json:"category" gorm:"foreignKey:CategoryID"`
+	Description   string             `json:"description"`
+	Date          time.Time          `json:"date"`
+	ExternalID    string             `json:"externalId,omitempty" gorm:"size:64;index"`        // 取込元での識別子（重複取込の検出用）
+	DuplicateOfID *uint              `json:"duplicateOfId,omitempty" gorm:"index"`             // 重複の統合で残した側の取引（annotate で残した場合。以後は重複として検出しない）
+	PayeeID       *uint              `json:"payeeId,omitempty" gorm:"index"`                   // 支払先（説明から別名で決める）
+	Splits        []TransactionSplit `json:"splits,omitempty" gorm:"foreignKey:TransactionID"` // カテゴリ別の内訳（ある場合、CategoryID は最も金額の大きい内訳のカテゴリ）
+	Tags          []Tag              `json:"tags,omitempty" gorm:"many2many:transaction_tags"`
+	Attachments   []Attachment       `json:"attachments,omitempty" gorm:"foreignKey:TransactionID"` // 取引詳細のみ
+	CreatedAt     time.Time          `json:"createdAt"`
+	UpdatedAt     time.Time          `json:"updatedAt"`
+
+	Search *TransactionSearchResult `json:"search,omitempty" gorm:"-"` // 全文検索（q を指定した取引一覧）のみ
+}
+
+// 取引の内訳（1件の取引を複数のカテゴリに分ける。金額の合計は取引の金額と一致する）
+type TransactionSplit struct {
+	ID            uint     `json:"id" gorm:"primaryKey"`
+	UserID        uint     `json:"userId" gorm:"index"`
+	TransactionID uint     `json:"transactionId" gorm:"index"`
+	CategoryID    uint     `json:"categoryId" gorm:"index"`
+	Category      Category `json:"category" gorm:"foreignKey:CategoryID"`
+	Amount        Money    `json:"amount"`
+	Memo          string   `json:"memo"`
+}
+
+// タグ（カテゴリをまたいだ旅行・イベントなどの集計用）
+type Tag struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	UserID    uint      `json:"userId" gorm:"index"`
+	Name      string    `json:"name" gorm:"size:50"`
+	Color     string    `json:"color"`
+	CreatedAt time.Time `json:"createdAt"`
+
+	TransactionCount int64 `json:"transactionCount,omitempty" gorm:"-"` // タグ一覧のみ
+}
+
+// 全文検索の索引（取引ごとに1行。各列は searchIndexText で検索用に変換した文字列）
+type TransactionSearch struct {
+	TransactionID uint   `gorm:"primaryKey;autoIncrement:false"`
+	UserID        uint   `gorm:"index"`
+	Description   string `gorm:"type:text"`
+	Payee         string `gorm:"type:text"`
+	Tags          string `gorm:"type:text"`
+	Category      string `gorm:"type:text"` // 内訳のカテゴリを含む
+}
+
+// 全文検索の一致箇所
+type TransactionSearchResult struct {
+	Score      float64           `json:"score"`      // 関連度（大きいほど一致している。比較は同じ検索の中でのみ意味がある）
+	Highlights map[string]string `json:"highlights"` // 一致した項目（description, payee, tags, category）の抜粋。HTMLエスケープ済みで、一致箇所を <mark> で囲む
+}
+
+// 取引とタグの対応
+type TransactionTag struct {
+	TransactionID uint `gorm:"primaryKey"`
+	TagID         uint `gorm:"primaryKey;index"`
+}
+
+// 取引の添付ファイル（レシート・請求書など。ファイル本体は attachmentStorage に保存する）
+type Attachment struct {
+	ID            uint      `json:"id" gorm:"primaryKey"`
+	UserID        uint      `json:"userId" gorm:"index"`
+	TransactionID uint      `json:"transactionId" gorm:"index"`
+	FileName      string    `json:"fileName" gorm:"size:255"`
+	ContentType   string    `json:"contentType" gorm:"size:100"` // 内容から判定した形式
+	Size          int64     `json:"size"`
+	SHA256        string    `json:"sha256" gorm:"size:64"`
+	StorageKey    string    `json:"-" gorm:"size:255"`
+	ThumbnailKey  string    `json:"-" gorm:"size:255"` // 画像のみ
+	HasThumbnail  bool      `json:"hasThumbnail" gorm:"-"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+// 支払先（店舗・サービス。説明の表記の揺れは別名でまとめる）
+type Payee struct {
+	ID                uint         `json:"id" gorm:"primaryKey"`
+	UserID            uint         `json:"userId" gorm:"index"`
+	Name              string       `json:"name" gorm:"size:100"`
+	DefaultCategoryID *uint        `json:"defaultCategoryId,omitempty"` // カテゴリの指定もルールの一致もない取引に使う
+	DefaultCategory   *Category    `json:"defaultCategory,omitempty" gorm:"foreignKey:DefaultCategoryID"`
+	Aliases           []PayeeAlias `json:"aliases" gorm:"foreignKey:PayeeID"`
+	CreatedAt         time.Time    `json:"createdAt"`
+
+	TransactionCount int64 `json:"transactionCount,omitempty" gorm:"-"` // 支払先一覧のみ
+}
+
+// 支払先の別名（説明に含まれていれば、その支払先の取引とみなす。JSONでは文字列）
+type PayeeAlias struct {
+	ID         uint   `gorm:"primaryKey"`
+	UserID     uint   `gorm:"index"`
+	PayeeID    uint   `gorm:"index"`
+	Alias      string `gorm:"size:100"`
+	Normalized string `gorm:"size:100;index"` // normalizePayeeText で正規化した別名
+}
+
+// 支払先作成・更新リクエスト
+type PayeeRequest struct {
+	Name              string   `json:"name" binding:"required,max=100"`
+	Aliases           []string `json:"aliases" binding:"omitempty,max=50,dive,max=100"`
+	DefaultCategoryID *uint    `json:"defaultCategoryId"`
+}
+
+// 支払先の統合リクエスト
+type PayeeMergeRequest struct {
+	TargetID uint `json:"targetId" binding:"required"`
+}
+
+// 登録済みの取引への支払先の割り当てリクエスト
+type PayeeMatchRequest struct {
+	Overwrite bool `json:"overwrite"` // true の場合は割り当て済みの取引も決め直す
+}
+
+// 自動振り分けルール（説明・金額・種類が一致した取引にカテゴリとタグを付ける）
+//
+// 優先度の小さい順（同じ優先度は作成順）に評価し、最初に一致したルールを使う。
+type CategoryRule struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	UserID     uint      `json:"userId" gorm:"index"`
+	Name       string    `json:"name"`
+	Priority   int       `json:"priority"`
+	MatchType  string    `json:"matchType" gorm:"size:16"` // contains（部分一致）, regex（正規表現）
+	Pattern    string    `json:"pattern"`                  // 説明と照合する語・正規表現（空の場合は金額だけで判定）
+	Type       string    `json:"type" gorm:"size:16"`      // 対象の取引の種類（カテゴリの種類）
+	MinAmount  *Money    `json:"minAmount,omitempty"`      // 金額の範囲（両端を含む）
+	MaxAmount  *Money    `json:"maxAmount,omitempty"`
+	CategoryID uint      `json:"categoryId" gorm:"index"`
+	Category   Category  `json:"category" gorm:"foreignKey:CategoryID"`
+	Tags       TagNames  `json:"tags" gorm:"type:text"` // 一致した取引に付けるタグ
+	Enabled    bool      `json:"enabled"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// 自動振り分けルール作成・更新リクエスト
+type CategoryRuleRequest struct {
+	Name       string   `json:"name"`
+	Priority   int      `json:"priority"`
+	MatchType  string   `json:"matchType" binding:"omitempty,oneof=contains regex"` // 省略時は contains
+	Pattern    string   `json:"pattern" binding:"max=200"`
+	MinAmount  *Money   `json:"minAmount"`
+	MaxAmount  *Money   `json:"maxAmount"`
+	CategoryID uint     `json:"categoryId" binding:"required"`
+	Tags       []string `json:"tags" binding:"omitempty,max=20,dive,max=50"`
+	Enabled    *bool    `json:"enabled"` // 省略時は有効
+}
+
+// 登録済みの取引へのルール適用リクエスト
+type CategoryRuleApplyRequest struct {
+	TransactionIDs []uint `json:"transactionIds"` // 省略時は条件に合うすべての取引
+}
+
+// 取引登録・更新リクエスト
+type TransactionRequest struct {
+	Type        string `json:"type" binding:"required,oneof=income expense transfer"`
+	Amount      Money  `json:"amount" binding:"required"`
+	Currency    string `json:"currency"`
+	AccountID   uint   `json:"accountId"`   // 未指定の場合はデフォルト口座
+	ToAccountID *uint  `json:"toAccountId"` // transferのみ
+	ToAmount    Money  `json:"toAmount"`    // 通貨の異なる口座間の振替のみ
+	CategoryID  uint   `json:"categoryId"`  // income, expense では必須
+	Description string `json:"description"`
+	Date        string `json:"date" binding:"required"`
+
+	// カテゴリ別の内訳（2件以上。指定した場合 categoryId は不要）。省略した場合、更新では内訳を変更しない（[] で解除）
+	Splits []TransactionSplitRequest `json:"splits" binding:"omitempty,dive"`
+
+	// 支払先。省略した場合は説明から決める（0 で解除）
+	PayeeID *uint `json:"payeeId"`
+
+	// タグ名（未登録のタグは作成する）。省略した場合、更新ではタグを変更しない
+	Tags []string `json:"tags" binding:"omitempty,max=20,dive,max=50"`
+}
+
+// 取引の内訳
+type TransactionSplitRequest struct {
+	CategoryID uint   `json:"categoryId" binding:"required"`
+	Amount     Money  `json:"amount" binding:"required"`
+	Memo       string `json:"memo" binding:"max=255"`
+}
+
+// タグ登録・更新リクエスト
+type TagRequest struct {
+	Name  string `json:"name" binding:"required,max=50"`
+	Color string `json:"color"`
+}
+
+// タグの統合リクエスト（このタグを targetId のタグにまとめる）
+type TagMergeRequest struct {
+	TargetID uint `json:"targetId" binding:"required"`
+}
+
+// 重複取引の統合リクエスト
+type TransactionMergeRequest struct {
+	KeepID       uint   `json:"keepId" binding:"required"`
+	DuplicateIDs []uint `json:"duplicateIds" binding:"required,min=1,max=100"`
+	Action       string `json:"action" binding:"omitempty,oneof=delete annotate"` // 既定は delete
+}
+
+// 口座（現金・銀行・クレジットカード・電子マネー）
+type Account struct {
+	ID             uint      `json:"id" gorm:"primaryKey"`
+	UserID         uint      `json:"userId" gorm:"index"`
+	Name           string    `json:"name"`
+	Type           string    `json:"type"` // cash, bank, credit_card, emoney
+	Currency       string    `json:"currency" gorm:"size:3;default:JPY"`
+	OpeningBalance Money     `json:"openingBalance"`
+	IsDefault      bool      `json:"isDefault" gorm:"default:false"`
+	IsActive       bool      `json:"isActive" gorm:"default:true"`
+	StatementRef   string    `json:"statementRef,omitempty" gorm:"size:64"` // 明細ファイル（OFX・QIF）の口座番号または口座名
+	Balance        Money     `json:"balance" gorm:"-"`                      // 計算フィールド
+	CreatedAt      time.Time `json:"createdAt"`
+	UpdatedAt      time.Time `json:"updatedAt"`
+}
+
+// 口座設定リクエスト
+type AccountRequest struct {
+	Name           string `json:"name" binding:"required"`
+	Type           string `json:"type" binding:"required,oneof=cash bank credit_card emoney"`
+	Currency       string `json:"currency"`
+	OpeningBalance Money  `json:"openingBalance"`
+	IsDefault      *bool  `json:"isDefault,omitempty"`
+	IsActive       *bool  `json:"isActive,omitempty"`
+	StatementRef   string `json:"statementRef" binding:"max=64"`
 }
 
 // 認証リクエスト
@@ -47,52 +277,300 @@ type LoginRequest struct {
 }
 
 type RegisterRequest struct {
-	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=6"`
-	Name     string `json:"name" binding:"required"`
+	Email        string `json:"email" binding:"required,email"`
+	Password     string `json:"password" binding:"required,min=6"`
+	Name         string `json:"name" binding:"required"`
+	BaseCurrency string `json:"baseCurrency"`
 }
 
 type AuthResponse struct {
-	Token string `json:"token"`
-	User  User   `json:"user"`
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresIn    int64  `json:"expiresIn"` // アクセストークンの有効期間（秒）
+	User         User   `json:"user"`
+}
+
+// プロフィール更新（メールアドレスを変更する場合は現在のパスワードが必要）
+type UpdateProfileRequest struct {
+	Name            string `json:"name" binding:"required"`
+	Email           string `json:"email" binding:"required,email"`
+	CurrentPassword string `json:"currentPassword"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword" binding:"required"`
+	NewPassword     string `json:"newPassword" binding:"required,min=6"`
+}
+
+// アカウント削除（2段階認証が有効な場合は認証コードも必要）
+type DeleteAccountRequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=6"`
+}
+
+// 2段階認証のリカバリーコード（ハッシュのみ保存）
+type RecoveryCode struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	UserID    uint       `json:"userId" gorm:"index"`
+	CodeHash  string     `json:"-" gorm:"size:64"`
+	UsedAt    *time.Time `json:"usedAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// 2段階認証の無効化・リカバリーコード再発行（パスワードでの再認証が必要）
+type TwoFactorReauthRequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"` // 認証コードまたはリカバリーコード
+}
+
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challengeToken" binding:"required"`
+	Code           string `json:"code" binding:"required"` // 認証コードまたはリカバリーコード
+}
+
+// 2段階認証が有効なユーザーのログイン応答
+type TwoFactorChallengeResponse struct {
+	TwoFactorRequired bool   `json:"twoFactorRequired"`
+	ChallengeToken    string `json:"challengeToken"`
+	ExpiresIn         int64  `json:"expiresIn"`
+}
+
+// パーソナルアクセストークン（スクリプト・外部連携用。平文は保存せずハッシュのみ保持）
+type PersonalAccessToken struct {
+	ID          uint        `json:"id" gorm:"primaryKey"`
+	UserID      uint        `json:"userId" gorm:"index"`
+	Name        string      `json:"name"`
+	TokenPrefix string      `json:"tokenPrefix" gorm:"size:16"` // 一覧で見分けるための先頭部分
+	TokenHash   string      `json:"-" gorm:"size:64;uniqueIndex"`
+	Scopes      TokenScopes `json:"scopes" gorm:"size:255"` // read, transactions:write, budgets:write
+	ExpiresAt   *time.Time  `json:"expiresAt"`              // nilは無期限
+	LastUsedAt  *time.Time  `json:"lastUsedAt"`
+	CreatedAt   time.Time   `json:"createdAt"`
+}
+
+type PersonalAccessTokenRequest struct {
+	Name          string   `json:"name" binding:"required"`
+	Scopes        []string `json:"scopes" binding:"required,min=1,dive,oneof=read transactions:write budgets:write"`
+	ExpiresInDays int      `json:"expiresInDays" binding:"min=0,max=3650"` // 0は無期限
+}
+
+// 失敗したログイン試行（監査ログ）
+type LoginAttempt struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	Email     string    `json:"email" gorm:"size:255;index"`
+	UserID    *uint     `json:"userId,omitempty" gorm:"index"`
+	IPAddress string    `json:"ipAddress" gorm:"size:45;index"`
+	UserAgent string    `json:"userAgent"`
+	Reason    string    `json:"reason" gorm:"size:32"` // unknown_email, bad_password, bad_2fa_code, locked_out
+	CreatedAt time.Time `json:"createdAt" gorm:"index"`
+}
+
+// ログイン試行回数（複数インスタンスで共有する場合の保存先）
+type LoginThrottle struct {
+	ThrottleKey string `gorm:"primaryKey;size:255"`
+	Failures    int
+	LockedUntil time.Time
+	ExpiresAt   time.Time `gorm:"index"`
+}
+
+// メールで送る使い捨てトークン（パスワードリセット・メールアドレス確認）
+type UserToken struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	UserID    uint       `json:"userId" gorm:"index"`
+	Purpose   string     `json:"purpose" gorm:"size:32"` // password_reset, email_verification
+	TokenHash string     `json:"-" gorm:"size:64;uniqueIndex"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	UsedAt    *time.Time `json:"usedAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// ログインセッション（リフレッシュトークンのファミリー）
+type Session struct {
+	ID            uint       `json:"id" gorm:"primaryKey"`
+	UserID        uint       `json:"userId" gorm:"index"`
+	UserAgent     string     `json:"userAgent"`
+	IPAddress     string     `json:"ipAddress" gorm:"size:45"`
+	LastUsedAt    time.Time  `json:"lastUsedAt"`
+	ExpiresAt     time.Time  `json:"expiresAt"`
+	RevokedAt     *time.Time `json:"revokedAt,omitempty"`
+	RevokedReason string     `json:"revokedReason,omitempty"` // logout, reuse_detected
+	CreatedAt     time.Time  `json:"createdAt"`
+}
+
+// リフレッシュトークン（平文は保存せずSHA-256ハッシュのみ保持）
+type RefreshToken struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	SessionID uint       `json:"sessionId" gorm:"index"`
+	TokenHash string     `json:"-" gorm:"size:64;uniqueIndex"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	UsedAt    *time.Time `json:"usedAt,omitempty"` // ローテーション済み
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// CSV取込の列設定（銀行・カード会社の明細ごとに保存して使い回す）
+type ImportProfile struct {
+	ID                       uint                `json:"id" gorm:"primaryKey"`
+	UserID                   uint                `json:"userId" gorm:"index"`
+	Name                     string              `json:"name"`
+	Encoding                 string              `json:"encoding" gorm:"size:16"` // auto, utf-8, shift_jis
+	Delimiter                string              `json:"delimiter" gorm:"size:1"` // 空の場合はカンマ
+	SkipRows                 int                 `json:"skipRows"`                // ヘッダーより前に読み飛ばす行数
+	HasHeader                bool                `json:"hasHeader"`
+	DateColumn               string              `json:"dateColumn"`                // 列名（ヘッダーあり）または1始まりの列番号
+	DateFormat               string              `json:"dateFormat" gorm:"size:32"` // YYYY/MM/DD など。空の場合は一般的な形式を順に試す
+	AmountColumn             string              `json:"amountColumn"`
+	AmountSign               string              `json:"amountSign" gorm:"size:16"` // expense_negative（マイナスが支出）, expense_positive（プラスが支出）
+	IncomeColumn             string              `json:"incomeColumn"`              // 入金・出金が別の列の場合
+	ExpenseColumn            string              `json:"expenseColumn"`
+	DescriptionColumn        string              `json:"descriptionColumn"`
+	AccountID                uint                `json:"accountId"` // 0はデフォルト口座
+	DefaultIncomeCategoryID  uint                `json:"defaultIncomeCategoryId"`
+	DefaultExpenseCategoryID uint                `json:"defaultExpenseCategoryId"`
+	CategoryRules            ImportCategoryRules `json:"categoryRules" gorm:"type:text"` // 摘要に含まれる語でカテゴリを決める（上から順に評価）
+	CreatedAt                time.Time           `json:"createdAt"`
+	UpdatedAt                time.Time           `json:"updatedAt"`
+}
+
+type ImportCategoryRule struct {
+	Keyword    string `json:"keyword" binding:"required"`
+	CategoryID uint   `json:"categoryId" binding:"required"`
+}
+
+// CSV取込の列設定リクエスト（保存せずに取込時に直接指定することもできる）
+type ImportProfileRequest struct {
+	Name                     string               `json:"name" binding:"required"`
+	Encoding                 string               `json:"encoding" binding:"omitempty,oneof=auto utf-8 shift_jis"`
+	Delimiter                string               `json:"delimiter" binding:"omitempty,len=1"`
+	SkipRows                 int                  `json:"skipRows" binding:"min=0"`
+	HasHeader                bool                 `json:"hasHeader"`
+	DateColumn               string               `json:"dateColumn" binding:"required"`
+	DateFormat               string               `json:"dateFormat"`
+	AmountColumn             string               `json:"amountColumn"`
+	AmountSign               string               `json:"amountSign" binding:"omitempty,oneof=expense_negative expense_positive"`
+	IncomeColumn             string               `json:"incomeColumn"`
+	ExpenseColumn            string               `json:"expenseColumn"`
+	DescriptionColumn        string               `json:"descriptionColumn"`
+	AccountID                uint                 `json:"accountId"`
+	DefaultIncomeCategoryID  uint                 `json:"defaultIncomeCategoryId"`
+	DefaultExpenseCategoryID uint                 `json:"defaultExpenseCategoryId"`
+	CategoryRules            []ImportCategoryRule `json:"categoryRules" binding:"dive"`
+}
+
+// CSV取込のプレビュー行
+type CSVImportRow struct {
+	Line        int          `json:"line"` // ファイル上の行番号
+	Transaction *Transaction `json:"transaction,omitempty"`
+	Errors      []string     `json:"errors,omitempty"`
+	Skipped     string       `json:"skipped,omitempty"`     // 取り込まない理由（transfer, excluded）
+	DuplicateOf *uint        `json:"duplicateOf,omitempty"` // 登録済みの同じ取引（取り込まない）
+	NewCategory bool         `json:"newCategory,omitempty"` // 取込時にカテゴリを作成する
+	RuleID      *uint        `json:"ruleId,omitempty"`      // カテゴリを決めた自動振り分けルール
+
+	// 重複の可能性がある登録済みの取引（警告のみで、取り込む）
+	PossibleDuplicates []uint `json:"possibleDuplicates,omitempty"`
+}
+
+// データエクスポート（アカウント移行・バックアップ用のアーカイブ）
+//
+// IDはエクスポート元のもので、インポート時に振り直す。
+type ExportArchive struct {
+	Format          string           `json:"format"`  // money-tracker-export
+	Version         int              `json:"version"` // アーカイブ形式のバージョン
+	ExportedAt      time.Time        `json:"exportedAt"`
+	Profile         ExportProfile    `json:"profile"`
+	Accounts        []Account        `json:"accounts"`
+	Categories      []Category       `json:"categories"`
+	Tags            []Tag            `json:"tags"`
+	Payees          []Payee          `json:"payees"`
+	CategoryRules   []CategoryRule   `json:"categoryRules"`
+	ImportProfiles  []ImportProfile  `json:"importProfiles"`
+	Transactions    []Transaction    `json:"transactions"`
+	Attachments     []Attachment     `json:"attachments"` // ファイル本体はZIP形式のみ（attachments/<id>/<fileName>）
+	Budgets         []Budget         `json:"budgets"`
+	FixedExpenses   []FixedExpense   `json:"fixedExpenses"`
+	CategoryBudgets []CategoryBudget `json:"categoryBudgets"`
+	ExchangeRates   []ExchangeRate   `json:"exchangeRates"`
+}
+
+type ExportProfile struct {
+	Name         string    `json:"name"`
+	Email        string    `json:"email"`
+	BaseCurrency string    `json:"baseCurrency"`
+	CreatedAt    time.Time `json:"createdAt"`
 }
 
 // 月別集計
 type MonthlySummary struct {
-	Year         int     `json:"year"`
-	Month        int     `json:"month"`
-	TotalIncome  float64 `json:"totalIncome"`
-	TotalExpense float64 `json:"totalExpense"`
-	Balance      float64 `json:"balance"`
+	Year         int   `json:"year"`
+	Month        int   `json:"month"`
+	TotalIncome  Money `json:"totalIncome"`
+	TotalExpense Money `json:"totalExpense"`
+	Balance      Money `json:"balance"`
 }
 
 // カテゴリ別集計
 type CategorySummary struct {
-	CategoryID    uint    `json:"categoryId"`
-	CategoryName  string  `json:"categoryName"`
-	CategoryIcon  string  `json:"categoryIcon"`
-	CategoryColor string  `json:"categoryColor"`
-	Type          string  `json:"type"`
-	TotalAmount   float64 `json:"totalAmount"`
-	Count         int64   `json:"count"`
+	CategoryID    uint   `json:"categoryId"`
+	CategoryName  string `json:"categoryName"`
+	CategoryIcon  string `json:"categoryIcon"`
+	CategoryColor string `json:"categoryColor"`
+	Type          string `json:"type"`
+	TotalAmount   Money  `json:"totalAmount"`
+	Count         int64  `json:"count"`
+}
+
+// タグ別集計
+type TagSummary struct {
+	TagID       uint   `json:"tagId"`
+	TagName     string `json:"tagName"`
+	TagColor    string `json:"tagColor"`
+	Type        string `json:"type"`
+	TotalAmount Money  `json:"totalAmount"`
+	Count       int64  `json:"count"`
+}
+
+// 支払先別集計
+type PayeeSummary struct {
+	PayeeID     uint   `json:"payeeId"` // 0 は支払先のない取引
+	PayeeName   string `json:"payeeName"`
+	Type        string `json:"type"`
+	TotalAmount Money  `json:"totalAmount"`
+	Count       int64  `json:"count"`
 }
 
 // 統計情報
 type Stats struct {
-	TotalIncome      float64 `json:"totalIncome"`
-	TotalExpense     float64 `json:"totalExpense"`
-	CurrentBalance   float64 `json:"currentBalance"`
-	ThisMonthIncome  float64 `json:"thisMonthIncome"`
-	ThisMonthExpense float64 `json:"thisMonthExpense"`
-	TransactionCount int64   `json:"transactionCount"`
+	TotalIncome      Money `json:"totalIncome"`
+	TotalExpense     Money `json:"totalExpense"`
+	CurrentBalance   Money `json:"currentBalance"`
+	ThisMonthIncome  Money `json:"thisMonthIncome"`
+	ThisMonthExpense Money `json:"thisMonthExpense"`
+	TransactionCount int64 `json:"transactionCount"`
 }
 
 // 日別集計
 type DailySummary struct {
-	Date         string  `json:"date"`
-	TotalIncome  float64 `json:"totalIncome"`
-	TotalExpense float64 `json:"totalExpense"`
-	Balance      float64 `json:"balance"`
+	Date         string `json:"date"`
+	TotalIncome  Money  `json:"totalIncome"`
+	TotalExpense Money  `json:"totalExpense"`
+	Balance      Money  `json:"balance"`
 }
 
 // 月次予算
@@ -101,7 +579,7 @@ type Budget struct {
 	UserID    uint      `json:"userId"`
 	Year      int       `json:"year"`
 	Month     int       `json:"month"`
-	Amount    float64   `json:"amount"`
+	Amount    Money     `json:"amount"`
 	CreatedAt time.Time `json:"createdAt"`
 	UpdatedAt time.Time `json:"updatedAt"`
 }
@@ -111,8 +589,10 @@ type FixedExpense struct {
 	ID             uint       `json:"id" gorm:"primaryKey"`
 	UserID         uint       `json:"userId"`
 	Name           string     `json:"name"`
-	Amount         float64    `json:"amount"`
+	Amount         Money      `json:"amount"`
+	Currency       string     `json:"currency" gorm:"size:3;default:JPY"`
 	Type           string     `json:"type" gorm:"default:expense"` // income, expense
+	AccountID      uint       `json:"accountId"`
 	CategoryID     uint       `json:"categoryId"`
 	Category       Category   `json:"category" gorm:"foreignKey:CategoryID"`
 	Description    string     `json:"description"`
@@ -128,65 +608,69 @@ type FixedExpense struct {
 type BudgetAnalysis struct {
 	Year               int     `json:"year"`
 	Month              int     `json:"month"`
-	MonthlyBudget      float64 `json:"monthlyBudget"`
-	TotalFixedExpenses float64 `json:"totalFixedExpenses"`
-	CurrentSpending    float64 `json:"currentSpending"`
-	RemainingBudget    float64 `json:"remainingBudget"`
+	MonthlyBudget      Money   `json:"monthlyBudget"`
+	TotalFixedExpenses Money   `json:"totalFixedExpenses"`
+	CurrentSpending    Money   `json:"currentSpending"`
+	RemainingBudget    Money   `json:"remainingBudget"`
 	BudgetUtilization  float64 `json:"budgetUtilization"` // 使用率 (%)
 	DaysRemaining      int     `json:"daysRemaining"`
-	DailyAverage       float64 `json:"dailyAverage"` // 1日あたり使用可能金額
+	DailyAverage       Money   `json:"dailyAverage"` // 1日あたり使用可能金額
 }
 
 // 支出予測
 type SpendingPrediction struct {
-	Year            int       `json:"year"`
-	Month           int       `json:"month"`
-	CurrentSpending float64   `json:"currentSpending"`
-	PredictedTotal  float64   `json:"predictedTotal"`
-	DailyAverage    float64   `json:"dailyAverage"`
-	RemainingDays   int       `json:"remainingDays"`
-	Confidence      string    `json:"confidence"`
-	Trend           string    `json:"trend"`
-	WeeklyPattern   []float64 `json:"weeklyPattern"`
-	MonthlyProgress float64   `json:"monthlyProgress"`
+	Year            int     `json:"year"`
+	Month           int     `json:"month"`
+	CurrentSpending Money   `json:"currentSpending"`
+	PredictedTotal  Money   `json:"predictedTotal"`
+	DailyAverage    Money   `json:"dailyAverage"`
+	RemainingDays   int     `json:"remainingDays"`
+	Confidence      string  `json:"confidence"`
+	Trend           string  `json:"trend"`
+	WeeklyPattern   []Money `json:"weeklyPattern"`
+	MonthlyProgress float64 `json:"monthlyProgress"`
 }
 
 // 予算履歴
 type BudgetHistory struct {
 	Year           int     `json:"year"`
 	Month          int     `json:"month"`
-	Budget         float64 `json:"budget"`
-	ActualSpending float64 `json:"actualSpending"`
-	FixedExpenses  float64 `json:"fixedExpenses"`
+	Budget         Money   `json:"budget"`
+	ActualSpending Money   `json:"actualSpending"`
+	FixedExpenses  Money   `json:"fixedExpenses"`
 	SavingsRate    float64 `json:"savingsRate"`
 	BudgetExceeded bool    `json:"budgetExceeded"`
 }
 
 // 予算設定リクエスト
 type BudgetRequest struct {
-	Year   int     `json:"year" binding:"required"`
-	Month  int     `json:"month" binding:"required,min=1,max=12"`
-	Amount float64 `json:"amount" binding:"required,min=0"`
+	Year   int   `json:"year" binding:"required"`
+	Month  int   `json:"month" binding:"required,min=1,max=12"`
+	Amount Money `json:"amount" binding:"required,min=0"`
 }
 
 // 固定費設定リクエスト
 type FixedExpenseRequest struct {
-	Name        string  `json:"name" binding:"required"`
-	Amount      float64 `json:"amount" binding:"required,min=0"`
-	Type        string  `json:"type" binding:"required,oneof=income expense"`
-	CategoryID  uint    `json:"categoryId" binding:"required"`
-	Description string  `json:"description"`
-	IsActive    *bool   `json:"isActive,omitempty"`
+	Name        string `json:"name" binding:"required"`
+	Amount      Money  `json:"amount" binding:"required,min=0"`
+	Currency    string `json:"currency"`
+	AccountID   uint   `json:"accountId"`
+	Type        string `json:"type" binding:"required,oneof=income expense"`
+	CategoryID  uint   `json:"categoryId" binding:"required"`
+	Description string `json:"description"`
+	IsActive    *bool  `json:"isActive,omitempty"`
 }
 
 // 固定収支設定リクエスト（固定費と同じ構造）
 type FixedTransactionRequest struct {
-	Name        string  `json:"name" binding:"required"`
-	Amount      float64 `json:"amount" binding:"required,min=0"`
-	Type        string  `json:"type" binding:"required,oneof=income expense"`
-	CategoryID  uint    `json:"categoryId" binding:"required"`
-	Description string  `json:"description"`
-	IsActive    *bool   `json:"isActive,omitempty"`
+	Name        string `json:"name" binding:"required"`
+	Amount      Money  `json:"amount" binding:"required,min=0"`
+	Currency    string `json:"currency"`
+	AccountID   uint   `json:"accountId"`
+	Type        string `json:"type" binding:"required,oneof=income expense"`
+	CategoryID  uint   `json:"categoryId" binding:"required"`
+	Description string `json:"description"`
+	IsActive    *bool  `json:"isActive,omitempty"`
 }
 
 // カテゴリ別予算
@@ -197,9 +681,9 @@ type CategoryBudget struct {
 	Category        Category  `json:"category" gorm:"foreignKey:CategoryID"`
 	Year            int       `json:"year"`
 	Month           int       `json:"month"`
-	Amount          float64   `json:"amount"`
-	Spent           float64   `json:"spent" gorm:"-"`           // 計算フィールド
-	Remaining       float64   `json:"remaining" gorm:"-"`       // 計算フィールド
+	Amount          Money     `json:"amount"`
+	Spent           Money     `json:"spent" gorm:"-"`           // 計算フィールド
+	Remaining       Money     `json:"remaining" gorm:"-"`       // 計算フィールド
 	UtilizationRate float64   `json:"utilizationRate" gorm:"-"` // 計算フィールド
 	CreatedAt       time.Time `json:"createdAt"`
 	UpdatedAt       time.Time `json:"updatedAt"`
@@ -207,10 +691,10 @@ type CategoryBudget struct {
 
 // カテゴリ別予算リクエスト
 type CategoryBudgetRequest struct {
-	CategoryID uint    `json:"categoryId" binding:"required"`
-	Year       int     `json:"year" binding:"required"`
-	Month      int     `json:"month" binding:"required,min=1,max=12"`
-	Amount     float64 `json:"amount" binding:"required,min=0"`
+	CategoryID uint  `json:"categoryId" binding:"required"`
+	Year       int   `json:"year" binding:"required"`
+	Month      int   `json:"month" binding:"required,min=1,max=12"`
+	Amount     Money `json:"amount" binding:"required,min=0"`
 }
 
 // カテゴリ別予算分析
@@ -219,10 +703,30 @@ type CategoryBudgetAnalysis struct {
 	CategoryName     string  `json:"categoryName"`
 	CategoryColor    string  `json:"categoryColor"`
 	CategoryIcon     string  `json:"categoryIcon"`
-	BudgetAmount     float64 `json:"budgetAmount"`
-	SpentAmount      float64 `json:"spentAmount"`
-	RemainingAmount  float64 `json:"remainingAmount"`
+	BudgetAmount     Money   `json:"budgetAmount"`
+	SpentAmount      Money   `json:"spentAmount"`
+	RemainingAmount  Money   `json:"remainingAmount"`
 	UtilizationRate  float64 `json:"utilizationRate"`
 	IsOverBudget     bool    `json:"isOverBudget"`
 	TransactionCount int64   `json:"transactionCount"`
 }
+
+// 為替レート（1 FromCurrency = Rate ToCurrency）
+type ExchangeRate struct {
+	ID           uint      `json:"id" gorm:"primaryKey"`
+	UserID       uint      `json:"userId" gorm:"uniqueIndex:idx_exchange_rates_user_pair_date"`
+	Date         time.Time `json:"date" gorm:"uniqueIndex:idx_exchange_rates_user_pair_date"`
+	FromCurrency string    `json:"fromCurrency" gorm:"size:3;uniqueIndex:idx_exchange_rates_user_pair_date"`
+	ToCurrency   string    `json:"toCurrency" gorm:"size:3;uniqueIndex:idx_exchange_rates_user_pair_date"`
+	Rate         float64   `json:"rate"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
+}
+
+// 為替レート登録リクエスト
+type ExchangeRateRequest struct {
+	Date         string  `json:"date" binding:"required"`
+	FromCurrency string  `json:"fromCurrency" binding:"required"`
+	ToCurrency   string  `json:"toCurrency" binding:"required"`
+	Rate         float64 `json:"rate" binding:"required,gt=0"`
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// 金額
+//
+// 浮動小数点の誤差を避けるため、1/100単位（小数第2位まで）の整数で保持する。
+// JSONでは従来どおり数値（例: 1500, 12.34）として入出力する。
+type Money int64
+
+// Money の1単位あたりの分割数（小数第2位まで）
+const moneyScale = 100
+
+// 既定の通貨
+const defaultCurrency = "JPY"
+
+// 通貨ごとの小数点以下の桁数（未登録の通貨は2桁とみなす）
+var currencyDecimals = map[string]int{
+	"JPY": 0,
+	"KRW": 0,
+	"USD": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"CNY": 2,
+	"TWD": 2,
+	"HKD": 2,
+	"AUD": 2,
+	"CAD": 2,
+	"SGD": 2,
+	"THB": 2,
+}
+
+// 通貨の小数点以下の桁数
+func decimalsOf(currency string) int {
+	if decimals, ok := currencyDecimals[strings.ToUpper(currency)]; ok {
+		return decimals
+	}
+	return 2
+}
+
+// 浮動小数点数から金額を作成する（小数第3位を四捨五入）
+func moneyFromFloat(value float64) Money {
+	return Money(math.Round(value * moneyScale))
+}
+
+// 10進数表記の文字列から誤差なく金額を作成する
+//
+// 小数第3位以下に0以外の数字がある場合はエラーにする（丸めると入力と違う金額を保存してしまうため）。
+func parseMoney(text string) (Money, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return 0, fmt.Errorf("empty amount")
+	}
+
+	negative := false
+	switch text[0] {
+	case '-':
+		negative = true
+		text = text[1:]
+	case '+':
+		text = text[1:]
+	}
+
+	// 指数表記（JSONの数値で使われうる）は浮動小数点で解釈する
+	if strings.ContainsAny(text, "eE") {
+		value, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid amount: %s", text)
+		}
+		scaled := value * moneyScale
+		if math.IsInf(scaled, 0) || math.Abs(scaled) >= math.MaxInt64/2 {
+			return 0, fmt.Errorf("amount out of range: %s", text)
+		}
+		if math.Abs(scaled-math.Round(scaled)) > 1e-6 {
+			return 0, fmt.Errorf("amount has more than 2 decimal places: %s", text)
+		}
+		money := moneyFromFloat(value)
+		if negative {
+			money = -money
+		}
+		return money, nil
+	}
+
+	integerPart, fractionPart, _ := strings.Cut(text, ".")
+	if integerPart == "" && fractionPart == "" {
+		return 0, fmt.Errorf("invalid amount: %s", text)
+	}
+	if integerPart == "" {
+		integerPart = "0"
+	}
+	for _, r := range integerPart + fractionPart {
+		if r < '0' || r > '9' {
+			return 0, fmt.Errorf("invalid amount: %s", text)
+		}
+	}
+
+	units, err := strconv.ParseInt(integerPart, 10, 64)
+	if err != nil || units > math.MaxInt64/moneyScale-1 {
+		return 0, fmt.Errorf("amount out of range: %s", text)
+	}
+
+	if len(fractionPart) > 2 && strings.Trim(fractionPart[2:], "0") != "" {
+		return 0, fmt.Errorf("amount has more than 2 decimal places: %s", text)
+	}
+	fraction := fractionPart + "00"
+	cents, _ := strconv.ParseInt(fraction[:2], 10, 64)
+	value := units*moneyScale + cents
+
+	if negative {
+		value = -value
+	}
+	return Money(value), nil
+}
+
+// 通貨の最小単位で四捨五入する（例: JPYは1円単位、USDは1セント単位）
+func (m Money) Round(currency string) Money {
+	step := int64(1)
+	for i := decimalsOf(currency); i < 2; i++ {
+		step *= 10
+	}
+	if step == 1 {
+		return m
+	}
+
+	value := int64(m)
+	remainder := value % step
+	value -= remainder
+	if remainder*2 >= step {
+		value += step
+	} else if remainder*2 <= -step {
+		value -= step
+	}
+	return Money(value)
+}
+
+// 金額が通貨の最小単位で表せるか検証する（例: JPYで10.5円は不可）
+func checkCurrencyUnit(field string, amount Money, currency string) error {
+	if amount.Round(currency) != amount {
+		return fmt.Errorf("%s must not have more than %d decimal places for %s", field, decimalsOf(currency), currency)
+	}
+	return nil
+}
+
+// 金額を整数で割る（四捨五入）
+func (m Money) Div(n int64) Money {
+	if n == 0 {
+		return 0
+	}
+	return moneyFromFloat(m.Float64() / float64(n))
+}
+
+// 金額を倍率で掛ける（四捨五入）
+func (m Money) Mul(factor float64) Money {
+	return moneyFromFloat(m.Float64() * factor)
+}
+
+// 浮動小数点数に変換する（割合など表示用の計算にのみ使う）
+func (m Money) Float64() float64 {
+	return float64(m) / moneyScale
+}
+
+// total に対する割合（%）
+func (m Money) PercentOf(total Money) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(m) / float64(total) * 100
+}
+
+// 10進数表記（末尾の0は省略）
+func (m Money) String() string {
+	value := int64(m)
+	sign := ""
+	if value < 0 {
+		sign = "-"
+		value = -value
+	}
+
+	units := value / moneyScale
+	cents := value % moneyScale
+	switch {
+	case cents == 0:
+		return fmt.Sprintf("%s%d", sign, units)
+	case cents%10 == 0:
+		return fmt.Sprintf("%s%d.%d", sign, units, cents/10)
+	default:
+		return fmt.Sprintf("%s%d.%02d", sign, units, cents)
+	}
+}
+
+// 通貨の桁数に合わせた表記（例: JPY 1500, USD 12.50）
+func (m Money) Format(currency string) string {
+	decimals := decimalsOf(currency)
+	if decimals >= 2 {
+		return fmt.Sprintf("%.2f", m.Float64())
+	}
+	return strconv.FormatFloat(m.Round(currency).Float64(), 'f', decimals, 64)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	text := strings.Trim(string(data), `"`)
+	if text == "null" {
+		return nil
+	}
+
+	value, err := parseMoney(text)
+	if err != nil {
+		return err
+	}
+	*m = value
+	return nil
+}
+
+// データベースには1/100単位の整数として保存する
+func (m Money) Value() (driver.Value, error) {
+	return int64(m), nil
+}
+
+// SUMの結果など、ドライバーによって型が異なる値も受け付ける
+func (m *Money) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*m = 0
+	case int64:
+		*m = Money(v)
+	case float64:
+		*m = Money(math.Round(v))
+	case []byte:
+		return m.scanString(string(v))
+	case string:
+		return m.scanString(v)
+	default:
+		return fmt.Errorf("cannot scan %T into Money", value)
+	}
+	return nil
+}
+
+func (m *Money) scanString(text string) error {
+	if value, err := strconv.ParseInt(text, 10, 64); err == nil {
+		*m = Money(value)
+		return nil
+	}
+
+	// DECIMAL型の集計結果（例: "150000.0000"）
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return fmt.Errorf("cannot scan %q into Money", text)
+	}
+	*m = Money(math.Round(value))
+	return nil
+}
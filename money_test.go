@@ -0,0 +1,101 @@
+package main
+
+import "testing"
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    Money
+		wantErr bool
+	}{
+		{text: "1500", want: 150000},
+		{text: "12.34", want: 1234},
+		{text: "12.3", want: 1230},
+		{text: "-12.34", want: -1234},
+		{text: "+5", want: 500},
+		{text: ".5", want: 50},
+		{text: "5.", want: 500},
+		{text: " 7 ", want: 700},
+		{text: "12.340", want: 1234},
+		{text: "12.3400000", want: 1234},
+		{text: "1.5e3", want: 150000},
+		{text: "1.234e1", want: 1234},
+		{text: "0.1e-1", want: 1},
+		{text: "12.345", wantErr: true},
+		{text: "12.3449", wantErr: true},
+		{text: "0.001", wantErr: true},
+		{text: "-0.009", wantErr: true},
+		{text: "1.2345e1", wantErr: true},
+		{text: "1e-3", wantErr: true},
+		{text: "1e400", wantErr: true},
+		{text: "", wantErr: true},
+		{text: ".", wantErr: true},
+		{text: "-", wantErr: true},
+		{text: "1,000", wantErr: true},
+		{text: "12a", wantErr: true},
+		{text: "99999999999999999999", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseMoney(tt.text)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseMoney(%q) = %d, want error", tt.text, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseMoney(%q) returned error: %v", tt.text, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseMoney(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyRound(t *testing.T) {
+	tests := []struct {
+		amount   Money
+		currency string
+		want     Money
+	}{
+		{amount: 1049, currency: "JPY", want: 1000},
+		{amount: 1050, currency: "JPY", want: 1100},
+		{amount: 1099, currency: "JPY", want: 1100},
+		{amount: -1049, currency: "JPY", want: -1000},
+		{amount: -1050, currency: "JPY", want: -1100},
+		{amount: 1234, currency: "jpy", want: 1200},
+		{amount: 1250, currency: "KRW", want: 1300},
+		{amount: 1234, currency: "USD", want: 1234},
+		{amount: 1234, currency: "XYZ", want: 1234},
+		{amount: 0, currency: "JPY", want: 0},
+	}
+	for _, tt := range tests {
+		if got := tt.amount.Round(tt.currency); got != tt.want {
+			t.Errorf("Money(%d).Round(%q) = %d, want %d", tt.amount, tt.currency, got, tt.want)
+		}
+	}
+}
+
+func TestCheckCurrencyUnit(t *testing.T) {
+	tests := []struct {
+		amount   Money
+		currency string
+		wantErr  bool
+	}{
+		{amount: 150000, currency: "JPY"},
+		{amount: -100, currency: "JPY"},
+		{amount: 1056, currency: "JPY", wantErr: true},
+		{amount: 1050, currency: "JPY", wantErr: true},
+		{amount: -1, currency: "KRW", wantErr: true},
+		{amount: 1056, currency: "USD"},
+		{amount: 1, currency: "EUR"},
+		{amount: 1, currency: "XYZ"},
+	}
+	for _, tt := range tests {
+		err := checkCurrencyUnit("amount", tt.amount, tt.currency)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("checkCurrencyUnit(%d, %q) error = %v, want error %v", tt.amount, tt.currency, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,580 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/unicode/norm"
+	"gorm.io/gorm"
+)
+
+// JSONでは別名を文字列として扱う
+func (a PayeeAlias) MarshalJSON() ([]byte, error) {
+	return json.Marshal(a.Alias)
+}
+
+func (a *PayeeAlias) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &a.Alias)
+}
+
+// 支払先の照合用に正規化する
+//
+// 全角・半角と大文字・小文字をそろえ、空白・記号を除く（AMAZON.CO.JP → amazoncojp）。
+func normalizePayeeText(text string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, strings.ToLower(norm.NFKC.String(text)))
+}
+
+// 照合に使う名前・別名の最小の文字数（1文字だと多くの説明に含まれてしまう）
+const minPayeeMatchLength = 2
+
+// 説明から支払先を決める
+type payeeMatcher struct {
+	payees  map[uint]*Payee
+	aliases []PayeeAlias // 長い別名から順に照合する
+}
+
+func newPayeeMatcher(userID uint) (*payeeMatcher, error) {
+	var payees []Payee
+	if err := db.Preload("DefaultCategory").Preload("Aliases").Where("user_id = ?", userID).Find(&payees).Error; err != nil {
+		return nil, err
+	}
+
+	pm := &payeeMatcher{payees: make(map[uint]*Payee, len(payees))}
+	for i := range payees {
+		payee := &payees[i]
+		pm.payees[payee.ID] = payee
+		// 支払先の名前も別名として扱う（短すぎるものは以前のデータやインポートしたデータにもあるため除く）
+		names := append([]PayeeAlias{{PayeeID: payee.ID, Alias: payee.Name, Normalized: normalizePayeeText(payee.Name)}}, payee.Aliases...)
+		for _, name := range names {
+			if utf8.RuneCountInString(name.Normalized) >= minPayeeMatchLength {
+				pm.aliases = append(pm.aliases, name)
+			}
+		}
+	}
+	sort.SliceStable(pm.aliases, func(i, j int) bool {
+		return len(pm.aliases[i].Normalized) > len(pm.aliases[j].Normalized)
+	})
+	return pm, nil
+}
+
+// 説明に別名を含む支払先（なければ nil）
+func (pm *payeeMatcher) match(description string) *Payee {
+	if pm == nil {
+		return nil
+	}
+	normalized := normalizePayeeText(description)
+	if normalized == "" {
+		return nil
+	}
+	for _, alias := range pm.aliases {
+		if alias.Normalized != "" && strings.Contains(normalized, alias.Normalized) {
+			return pm.payees[alias.PayeeID]
+		}
+	}
+	return nil
+}
+
+// 支払先を取引に設定し、その支払先を返す（一致しなければ支払先を外す）
+func (pm *payeeMatcher) apply(transaction *Transaction) *Payee {
+	payee := pm.match(transaction.Description)
+	setTransactionPayee(transaction, payee)
+	return payee
+}
+
+// 支払先の既定カテゴリ（取引と同じ種類の場合のみ）
+func payeeDefaultCategory(payee *Payee, transactionType string) (Category, bool) {
+	if payee == nil || payee.DefaultCategory == nil || payee.DefaultCategory.Type != transactionType {
+		return Category{}, false
+	}
+	return *payee.DefaultCategory, true
+}
+
+var errPayeeNotFound = errors.New("Payee not found")
+
+// 取引の支払先を決める（payeeID の指定がなければ説明から。0 は支払先なし）
+func resolvePayee(userID uint, payeeID *uint, description string) (*Payee, error) {
+	if payeeID != nil {
+		if *payeeID == 0 {
+			return nil, nil
+		}
+		var payee Payee
+		if err := db.Preload("DefaultCategory").Where("user_id = ?", userID).First(&payee, *payeeID).Error; err != nil {
+			return nil, errPayeeNotFound
+		}
+		return &payee, nil
+	}
+	matcher, err := newPayeeMatcher(userID)
+	if err != nil {
+		return nil, err
+	}
+	return matcher.match(description), nil
+}
+
+// 取引に支払先を設定する（nil は支払先なし）
+func setTransactionPayee(transaction *Transaction, payee *Payee) {
+	transaction.PayeeID = nil
+	if payee != nil {
+		transaction.PayeeID = &payee.ID
+	}
+}
+
+func respondPayeeError(c *gin.Context, err error) {
+	if errors.Is(err, errPayeeNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load payees: " + err.Error()})
+}
+
+// 支払先一覧（別名と取引の件数つき）
+func getPayees(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var payees []Payee
+	if err := db.Preload("DefaultCategory").Preload("Aliases", func(tx *gorm.DB) *gorm.DB { return tx.Order("id") }).
+		Where("user_id = ?", userID).Order("name ASC").Find(&payees).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payees: " + err.Error()})
+		return
+	}
+
+	var counts []struct {
+		PayeeID uint
+		Count   int64
+	}
+	if err := db.Model(&Transaction{}).Select("payee_id, COUNT(*) as count").
+		Where("user_id = ? AND payee_id IS NOT NULL", userID).Group("payee_id").Scan(&counts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payees: " + err.Error()})
+		return
+	}
+	countByPayee := make(map[uint]int64, len(counts))
+	for _, row := range counts {
+		countByPayee[row.PayeeID] = row.Count
+	}
+	for i := range payees {
+		payees[i].TransactionCount = countByPayee[payees[i].ID]
+	}
+
+	c.JSON(http.StatusOK, payees)
+}
+
+// リクエスト内容を検証して支払先に反映する（別名が他の支払先と重なる場合は 409）
+func applyPayeeRequest(payee *Payee, req PayeeRequest) (int, error) {
+	payee.Name = strings.TrimSpace(req.Name)
+	if normalizePayeeText(payee.Name) == "" {
+		return http.StatusBadRequest, errors.New("name must contain letters or digits")
+	}
+	// 名前も別名と同じく説明との照合に使う
+	if utf8.RuneCountInString(normalizePayeeText(payee.Name)) < minPayeeMatchLength {
+		return http.StatusBadRequest, fmt.Errorf("name %q is too short", payee.Name)
+	}
+
+	payee.DefaultCategoryID, payee.DefaultCategory = nil, nil
+	if req.DefaultCategoryID != nil && *req.DefaultCategoryID != 0 {
+		var category Category
+		if err := db.Where("user_id = ?", payee.UserID).First(&category, *req.DefaultCategoryID).Error; err != nil {
+			return http.StatusBadRequest, fmt.Errorf("Category %d not found", *req.DefaultCategoryID)
+		}
+		payee.DefaultCategoryID, payee.DefaultCategory = &category.ID, &category
+	}
+
+	// 名前と別名は、正規化したものが同じなら1つにまとめる
+	seen := map[string]bool{normalizePayeeText(payee.Name): true}
+	payee.Aliases = nil
+	for _, alias := range req.Aliases {
+		alias = strings.TrimSpace(alias)
+		normalized := normalizePayeeText(alias)
+		if normalized == "" || seen[normalized] {
+			continue
+		}
+		if utf8.RuneCountInString(normalized) < minPayeeMatchLength {
+			return http.StatusBadRequest, fmt.Errorf("alias %q is too short", alias)
+		}
+		seen[normalized] = true
+		payee.Aliases = append(payee.Aliases, PayeeAlias{UserID: payee.UserID, Alias: alias, Normalized: normalized})
+	}
+
+	// 他の支払先の名前・別名と同じものは使えない
+	var others []Payee
+	if err := db.Preload("Aliases").Where("user_id = ? AND id <> ?", payee.UserID, payee.ID).Find(&others).Error; err != nil {
+		return http.StatusInternalServerError, err
+	}
+	for _, other := range others {
+		names := append([]PayeeAlias{{Alias: other.Name, Normalized: normalizePayeeText(other.Name)}}, other.Aliases...)
+		for _, name := range names {
+			if seen[name.Normalized] {
+				return http.StatusConflict, fmt.Errorf("%q is already used by payee %q; merge them into one payee instead", name.Alias, other.Name)
+			}
+		}
+	}
+	return 0, nil
+}
+
+// 支払先と別名を保存する（別名は置き換える）
+func savePayee(payee *Payee) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Aliases", "DefaultCategory").Save(payee).Error; err != nil {
+			return err
+		}
+		// 支払先名は検索の対象なので、割り当て済みの取引の索引を作り直す
+		if _, err := indexTransactions(tx, tx.Model(&Transaction{}).Select("id").Where("payee_id = ?", payee.ID)); err != nil {
+			return err
+		}
+		if err := tx.Where("payee_id = ?", payee.ID).Delete(&PayeeAlias{}).Error; err != nil {
+			return err
+		}
+		if len(payee.Aliases) == 0 {
+			payee.Aliases = []PayeeAlias{}
+			return nil
+		}
+		for i := range payee.Aliases {
+			payee.Aliases[i].ID = 0
+			payee.Aliases[i].PayeeID = payee.ID
+		}
+		return tx.Create(&payee.Aliases).Error
+	})
+}
+
+// 支払先の作成（支払先のない登録済みの取引にも割り当てる）
+func createPayee(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var req PayeeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+
+	payee := Payee{UserID: userID.(uint)}
+	if status, err := applyPayeeRequest(&payee, req); err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	if err := savePayee(&payee); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create payee: " + err.Error()})
+		return
+	}
+
+	matched, err := assignPayees(userID.(uint), false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to match transactions: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"payee": payee, "matched": matched})
+}
+
+// 支払先の変更（支払先のない登録済みの取引にも割り当てる）
+func updatePayee(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var payee Payee
+	if err := db.Where("user_id = ?", userID).First(&payee, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payee not found"})
+		return
+	}
+
+	var req PayeeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+	if status, err := applyPayeeRequest(&payee, req); err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	if err := savePayee(&payee); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update payee: " + err.Error()})
+		return
+	}
+
+	matched, err := assignPayees(userID.(uint), false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to match transactions: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"payee": payee, "matched": matched})
+}
+
+// 支払先の削除（取引は削除せず、支払先を外す）
+func deletePayee(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var payee Payee
+	if err := db.Where("user_id = ?", userID).First(&payee, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payee not found"})
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var assigned []uint
+		if err := tx.Model(&Transaction{}).Where("payee_id = ?", payee.ID).Pluck("id", &assigned).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&Transaction{}).Where("payee_id = ?", payee.ID).UpdateColumn("payee_id", nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("payee_id = ?", payee.ID).Delete(&PayeeAlias{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&payee).Error; err != nil {
+			return err
+		}
+		_, err := indexTransactions(tx, assigned)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete payee: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Payee deleted successfully"})
+}
+
+// 支払先の統合（この支払先の取引・名前・別名を targetId の支払先に移し、この支払先は削除する）
+//
+// 統合先に既定カテゴリがなければ、この支払先の既定カテゴリを引き継ぐ。
+func mergePayee(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var source Payee
+	if err := db.Preload("Aliases").Where("user_id = ?", userID).First(&source, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payee not found"})
+		return
+	}
+
+	var req PayeeMergeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+	if req.TargetID == source.ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "targetId must differ from the merged payee"})
+		return
+	}
+	var target Payee
+	if err := db.Preload("Aliases").Where("user_id = ?", userID).First(&target, req.TargetID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Target payee not found"})
+		return
+	}
+
+	// 統合先の名前・別名と同じになるものは除く
+	seen := map[string]bool{normalizePayeeText(target.Name): true}
+	for _, alias := range target.Aliases {
+		seen[alias.Normalized] = true
+	}
+	var aliases []PayeeAlias
+	for _, alias := range append([]PayeeAlias{{Alias: source.Name, Normalized: normalizePayeeText(source.Name)}}, source.Aliases...) {
+		// 短すぎる名前は別名にできない
+		if utf8.RuneCountInString(alias.Normalized) < minPayeeMatchLength || seen[alias.Normalized] {
+			continue
+		}
+		seen[alias.Normalized] = true
+		aliases = append(aliases, PayeeAlias{UserID: target.UserID, PayeeID: target.ID, Alias: alias.Alias, Normalized: alias.Normalized})
+	}
+
+	var moved int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var assigned []uint
+		if err := tx.Model(&Transaction{}).Where("payee_id = ?", source.ID).Pluck("id", &assigned).Error; err != nil {
+			return err
+		}
+		result := tx.Model(&Transaction{}).Where("payee_id = ?", source.ID).UpdateColumn("payee_id", target.ID)
+		if result.Error != nil {
+			return result.Error
+		}
+		moved = result.RowsAffected
+		if err := tx.Where("payee_id = ?", source.ID).Delete(&PayeeAlias{}).Error; err != nil {
+			return err
+		}
+		if len(aliases) > 0 {
+			if err := tx.Create(&aliases).Error; err != nil {
+				return err
+			}
+		}
+		if target.DefaultCategoryID == nil && source.DefaultCategoryID != nil {
+			if err := tx.Model(&target).UpdateColumn("default_category_id", *source.DefaultCategoryID).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Delete(&source).Error; err != nil {
+			return err
+		}
+		_, err := indexTransactions(tx, assigned)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to merge payees: " + err.Error()})
+		return
+	}
+
+	db.Preload("DefaultCategory").Preload("Aliases", func(tx *gorm.DB) *gorm.DB { return tx.Order("id") }).First(&target, target.ID)
+	c.JSON(http.StatusOK, gin.H{"payee": target, "moved": moved})
+}
+
+// 登録済みの取引に説明から支払先を割り当て、変更した件数を返す
+//
+// overwrite が false の場合は支払先のない取引だけを対象にする。
+func assignPayees(userID uint, overwrite bool) (int, error) {
+	matcher, err := newPayeeMatcher(userID)
+	if err != nil {
+		return 0, err
+	}
+
+	var transactions []struct {
+		ID          uint
+		Description string
+		PayeeID     *uint
+	}
+	query := db.Model(&Transaction{}).Select("id, description, payee_id").Where("user_id = ?", userID)
+	if !overwrite {
+		query = query.Where("payee_id IS NULL")
+	}
+	if err := query.Scan(&transactions).Error; err != nil {
+		return 0, err
+	}
+
+	// 支払先ごとにまとめて更新する（0 は支払先を外す）
+	changes := make(map[uint][]uint)
+	for _, t := range transactions {
+		var payeeID uint
+		if payee := matcher.match(t.Description); payee != nil {
+			payeeID = payee.ID
+		}
+		if (t.PayeeID == nil && payeeID == 0) || (t.PayeeID != nil && *t.PayeeID == payeeID) {
+			continue
+		}
+		changes[payeeID] = append(changes[payeeID], t.ID)
+	}
+
+	changed := 0
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for payeeID, ids := range changes {
+			var value interface{}
+			if payeeID != 0 {
+				value = payeeID
+			}
+			for start := 0; start < len(ids); start += 500 {
+				end := min(start+500, len(ids))
+				if err := tx.Model(&Transaction{}).Where("id IN ?", ids[start:end]).UpdateColumn("payee_id", value).Error; err != nil {
+					return err
+				}
+				if _, err := indexTransactions(tx, ids[start:end]); err != nil {
+					return err
+				}
+			}
+			changed += len(ids)
+		}
+		return nil
+	})
+	return changed, err
+}
+
+// 登録済みの取引に支払先を割り当てる（overwrite=true の場合は割り当て済みの取引も決め直す）
+func matchPayees(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var req PayeeMatchRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+			return
+		}
+	}
+
+	matched, err := assignPayees(userID.(uint), req.Overwrite)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to match transactions: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"matched": matched})
+}
+
+// 支払先別集計（type, startDate, endDate, limit）
+//
+// 金額の大きい支払先から limit 件（既定 10）を返す。includeUnassigned=true の場合は支払先のない取引の合計も加える。
+func getPayeeSummary(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	transactionType := c.DefaultQuery("type", "expense")
+	startDate := c.Query("startDate")
+	endDate := c.Query("endDate")
+
+	limit := 10
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > 100 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 100"})
+			return
+		}
+		limit = n
+	}
+
+	var payees []Payee
+	if err := db.Where("user_id = ?", userID).Find(&payees).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payees: " + err.Error()})
+		return
+	}
+
+	query := db.Model(&Transaction{}).Where("user_id = ? AND type = ?", userID, transactionType)
+	if startDate != "" && endDate != "" {
+		rangeStart, rangeEnd, err := parseDateRange(startDate, endDate)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		query = query.Where("date >= ? AND date < ?", rangeStart, rangeEnd)
+	}
+
+	// 外貨の取引は取引日のレートで基準通貨に換算する
+	converter, err := newCurrencyConverter(userID)
+	if err != nil {
+		respondAggregationError(c, err)
+		return
+	}
+	totals, counts, err := converter.totalsBy(query, "COALESCE(payee_id, 0)")
+	if err != nil {
+		respondAggregationError(c, err)
+		return
+	}
+
+	summaries := make([]PayeeSummary, 0, len(payees))
+	for _, payee := range payees {
+		if counts[payee.ID] == 0 {
+			continue
+		}
+		summaries = append(summaries, PayeeSummary{
+			PayeeID:     payee.ID,
+			PayeeName:   payee.Name,
+			Type:        transactionType,
+			TotalAmount: totals[payee.ID],
+			Count:       counts[payee.ID],
+		})
+	}
+
+	// 金額の大きい順（同額はID順）
+	sort.SliceStable(summaries, func(i, j int) bool {
+		if summaries[i].TotalAmount != summaries[j].TotalAmount {
+			return summaries[i].TotalAmount > summaries[j].TotalAmount
+		}
+		return summaries[i].PayeeID < summaries[j].PayeeID
+	})
+	if len(summaries) > limit {
+		summaries = summaries[:limit]
+	}
+	if c.Query("includeUnassigned") == "true" && counts[0] > 0 {
+		summaries = append(summaries, PayeeSummary{Type: transactionType, TotalAmount: totals[0], Count: counts[0]})
+	}
+
+	c.JSON(http.StatusOK, summaries)
+}
@@ -0,0 +1,1086 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// エクスポートアーカイブの形式
+const (
+	exportFormatName    = "money-tracker-export"
+	exportFormatVersion = 1
+)
+
+// インポートできるアーカイブの最大サイズ
+const maxImportSize = 50 << 20
+
+// ZIP内のアーカイブ本体（CSVは表計算ソフトで見るためのもの）
+const exportArchiveFileName = "archive.json"
+
+// ZIP内の添付ファイルの置き場所（attachments/<id>/<fileName>）
+const exportAttachmentDir = "attachments/"
+
+var errImportTargetNotEmpty = errors.New("import target account is not empty")
+
+var errInvalidImportAttachment = errors.New("invalid attachment file")
+
+// ユーザーのデータをすべて読み込む
+func buildExportArchive(userID uint) (ExportArchive, error) {
+	var user User
+	if err := db.First(&user, userID).Error; err != nil {
+		return ExportArchive{}, err
+	}
+
+	archive := ExportArchive{
+		Format:     exportFormatName,
+		Version:    exportFormatVersion,
+		ExportedAt: time.Now().UTC(),
+		Profile: ExportProfile{
+			Name:         user.Name,
+			Email:        user.Email,
+			BaseCurrency: user.BaseCurrency,
+			CreatedAt:    user.CreatedAt,
+		},
+	}
+
+	queries := []interface{}{
+		&archive.Accounts,
+		&archive.Categories,
+		&archive.Tags,
+		&archive.Payees,
+		&archive.CategoryRules,
+		&archive.ImportProfiles,
+		&archive.Transactions,
+		&archive.Attachments,
+		&archive.Budgets,
+		&archive.FixedExpenses,
+		&archive.CategoryBudgets,
+		&archive.ExchangeRates,
+	}
+	for _, dest := range queries {
+		query := db.Where("user_id = ?", userID).Order("id")
+		if dest == &archive.Transactions {
+			query = query.Preload("Splits", func(tx *gorm.DB) *gorm.DB { return tx.Order("id") }).Preload("Tags")
+		}
+		if dest == &archive.Payees {
+			query = query.Preload("Aliases", func(tx *gorm.DB) *gorm.DB { return tx.Order("id") })
+		}
+		if err := query.Find(dest).Error; err != nil {
+			return archive, err
+		}
+	}
+	return archive, nil
+}
+
+// データエクスポート（format=json または zip）
+func exportUserData(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "zip" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or zip"})
+		return
+	}
+
+	archive, err := buildExportArchive(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export data: " + err.Error()})
+		return
+	}
+
+	fileName := "money-tracker-export-" + archive.ExportedAt.Format("20060102") + "." + format
+	c.Header("Content-Disposition", `attachment; filename="`+fileName+`"`)
+
+	if format == "json" {
+		c.JSON(http.StatusOK, archive)
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Status(http.StatusOK)
+	if err := writeExportZip(c.Writer, archive); err != nil {
+		// ヘッダー送信後なのでログのみ
+		log.Printf("[EXPORT] ERROR: Failed to write export archive for user %d: %v", userID, err)
+	}
+}
+
+// archive.json とエンティティごとのCSVをZIPにまとめる
+func writeExportZip(w io.Writer, archive ExportArchive) error {
+	zw := zip.NewWriter(w)
+
+	f, err := zw.Create(exportArchiveFileName)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(archive); err != nil {
+		return err
+	}
+
+	for _, table := range exportCSVTables(archive) {
+		f, err := zw.Create(table.Name + ".csv")
+		if err != nil {
+			return err
+		}
+		// Excelで文字化けしないようBOMを付ける
+		if _, err := f.Write([]byte("\xEF\xBB\xBF")); err != nil {
+			return err
+		}
+		cw := csv.NewWriter(f)
+		if err := cw.Write(table.Header); err != nil {
+			return err
+		}
+		if err := cw.WriteAll(table.Rows); err != nil {
+			return err
+		}
+	}
+
+	// 添付ファイルの本体（保存先にないものは飛ばし、インポート時に未復元として数える）
+	for _, attachment := range archive.Attachments {
+		object, err := attachmentStorage.Get(attachment.StorageKey)
+		if errors.Is(err, errAttachmentObjectNotFound) {
+			log.Printf("[EXPORT] Attachment %d has no stored file, skipped", attachment.ID)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		// 画像・PDFは圧縮済みなので格納のみ
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: exportAttachmentPath(attachment), Method: zip.Store, Modified: attachment.CreatedAt})
+		if err == nil {
+			_, err = io.Copy(f, object)
+		}
+		object.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+func exportAttachmentPath(attachment Attachment) string {
+	return exportAttachmentDir + formatID(attachment.ID) + "/" + attachment.FileName
+}
+
+type exportCSVTable struct {
+	Name   string
+	Header []string
+	Rows   [][]string
+}
+
+func formatID(id uint) string {
+	if id == 0 {
+		return ""
+	}
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+func formatDate(t time.Time) string {
+	return t.Format("2006-01-02")
+}
+
+// CSVの各表
+func exportCSVTables(archive ExportArchive) []exportCSVTable {
+	accounts := exportCSVTable{Name: "accounts", Header: []string{"id", "name", "type", "currency", "opening_balance", "is_default", "is_active"}}
+	for _, a := range archive.Accounts {
+		accounts.Rows = append(accounts.Rows, []string{
+			formatID(a.ID), a.Name, a.Type, a.Currency, a.OpeningBalance.String(),
+			strconv.FormatBool(a.IsDefault), strconv.FormatBool(a.IsActive),
+		})
+	}
+
+	categories := exportCSVTable{Name: "categories", Header: []string{"id", "name", "type", "color", "icon", "description"}}
+	for _, cat := range archive.Categories {
+		categories.Rows = append(categories.Rows, []string{
+			formatID(cat.ID), cat.Name, cat.Type, cat.Color, cat.Icon, cat.Description,
+		})
+	}
+
+	payees := exportCSVTable{Name: "payees", Header: []string{"id", "name", "default_category_id", "aliases"}}
+	for _, payee := range archive.Payees {
+		defaultCategoryID := ""
+		if payee.DefaultCategoryID != nil {
+			defaultCategoryID = formatID(*payee.DefaultCategoryID)
+		}
+		aliases := make([]string, 0, len(payee.Aliases))
+		for _, alias := range payee.Aliases {
+			aliases = append(aliases, alias.Alias)
+		}
+		payees.Rows = append(payees.Rows, []string{formatID(payee.ID), payee.Name, defaultCategoryID, strings.Join(aliases, "|")})
+	}
+
+	categoryRules := exportCSVTable{Name: "category_rules", Header: []string{"id", "name", "priority", "match_type", "pattern", "type", "min_amount", "max_amount", "category_id", "tags", "enabled"}}
+	for _, rule := range archive.CategoryRules {
+		minAmount, maxAmount := "", ""
+		if rule.MinAmount != nil {
+			minAmount = rule.MinAmount.String()
+		}
+		if rule.MaxAmount != nil {
+			maxAmount = rule.MaxAmount.String()
+		}
+		categoryRules.Rows = append(categoryRules.Rows, []string{
+			formatID(rule.ID), rule.Name, strconv.Itoa(rule.Priority), rule.MatchType, rule.Pattern, rule.Type,
+			minAmount, maxAmount, formatID(rule.CategoryID), strings.Join(rule.Tags, "|"), strconv.FormatBool(rule.Enabled),
+		})
+	}
+
+	importProfiles := exportCSVTable{Name: "import_profiles", Header: []string{"id", "name", "account_id", "default_income_category_id", "default_expense_category_id", "category_rules"}}
+	for _, profile := range archive.ImportProfiles {
+		rules := make([]string, 0, len(profile.CategoryRules))
+		for _, rule := range profile.CategoryRules {
+			rules = append(rules, rule.Keyword+"="+formatID(rule.CategoryID))
+		}
+		importProfiles.Rows = append(importProfiles.Rows, []string{
+			formatID(profile.ID), profile.Name, formatID(profile.AccountID),
+			formatID(profile.DefaultIncomeCategoryID), formatID(profile.DefaultExpenseCategoryID), strings.Join(rules, "|"),
+		})
+	}
+
+	transactions := exportCSVTable{Name: "transactions", Header: []string{"id", "date", "type", "amount", "currency", "account_id", "to_account_id", "to_amount", "category_id", "payee_id", "description"}}
+	for _, t := range archive.Transactions {
+		toAccountID, toAmount, payeeID := "", "", ""
+		if t.ToAccountID != nil {
+			toAccountID = formatID(*t.ToAccountID)
+			toAmount = t.ToAmount.String()
+		}
+		if t.PayeeID != nil {
+			payeeID = formatID(*t.PayeeID)
+		}
+		transactions.Rows = append(transactions.Rows, []string{
+			formatID(t.ID), formatDate(t.Date), t.Type, t.Amount.String(), t.Currency,
+			formatID(t.AccountID), toAccountID, toAmount, formatID(t.CategoryID), payeeID, t.Description,
+		})
+	}
+
+	transactionSplits := exportCSVTable{Name: "transaction_splits", Header: []string{"id", "transaction_id", "category_id", "amount", "memo"}}
+	for _, t := range archive.Transactions {
+		for _, split := range t.Splits {
+			transactionSplits.Rows = append(transactionSplits.Rows, []string{
+				formatID(split.ID), formatID(t.ID), formatID(split.CategoryID), split.Amount.String(), split.Memo,
+			})
+		}
+	}
+
+	tags := exportCSVTable{Name: "tags", Header: []string{"id", "name", "color"}}
+	for _, tag := range archive.Tags {
+		tags.Rows = append(tags.Rows, []string{formatID(tag.ID), tag.Name, tag.Color})
+	}
+
+	transactionTags := exportCSVTable{Name: "transaction_tags", Header: []string{"transaction_id", "tag_id"}}
+	for _, t := range archive.Transactions {
+		for _, tag := range t.Tags {
+			transactionTags.Rows = append(transactionTags.Rows, []string{formatID(t.ID), formatID(tag.ID)})
+		}
+	}
+
+	attachments := exportCSVTable{Name: "attachments", Header: []string{"id", "transaction_id", "file_name", "content_type", "size", "sha256", "path"}}
+	for _, a := range archive.Attachments {
+		attachments.Rows = append(attachments.Rows, []string{
+			formatID(a.ID), formatID(a.TransactionID), a.FileName, a.ContentType,
+			strconv.FormatInt(a.Size, 10), a.SHA256, exportAttachmentPath(a),
+		})
+	}
+
+	budgets := exportCSVTable{Name: "budgets", Header: []string{"id", "year", "month", "amount"}}
+	for _, b := range archive.Budgets {
+		budgets.Rows = append(budgets.Rows, []string{
+			formatID(b.ID), strconv.Itoa(b.Year), strconv.Itoa(b.Month), b.Amount.String(),
+		})
+	}
+
+	fixedExpenses := exportCSVTable{Name: "fixed_expenses", Header: []string{"id", "name", "type", "amount", "currency", "account_id", "category_id", "description", "is_active", "auto_register", "register_day"}}
+	for _, f := range archive.FixedExpenses {
+		fixedExpenses.Rows = append(fixedExpenses.Rows, []string{
+			formatID(f.ID), f.Name, f.Type, f.Amount.String(), f.Currency, formatID(f.AccountID), formatID(f.CategoryID),
+			f.Description, strconv.FormatBool(f.IsActive), strconv.FormatBool(f.AutoRegister), strconv.Itoa(f.RegisterDay),
+		})
+	}
+
+	categoryBudgets := exportCSVTable{Name: "category_budgets", Header: []string{"id", "category_id", "year", "month", "amount"}}
+	for _, cb := range archive.CategoryBudgets {
+		categoryBudgets.Rows = append(categoryBudgets.Rows, []string{
+			formatID(cb.ID), formatID(cb.CategoryID), strconv.Itoa(cb.Year), strconv.Itoa(cb.Month), cb.Amount.String(),
+		})
+	}
+
+	exchangeRates := exportCSVTable{Name: "exchange_rates", Header: []string{"date", "from", "to", "rate"}}
+	for _, r := range archive.ExchangeRates {
+		exchangeRates.Rows = append(exchangeRates.Rows, []string{
+			formatDate(r.Date), r.FromCurrency, r.ToCurrency, strconv.FormatFloat(r.Rate, 'f', -1, 64),
+		})
+	}
+
+	return []exportCSVTable{accounts, categories, tags, payees, categoryRules, importProfiles, transactions, transactionSplits, transactionTags, attachments, budgets, fixedExpenses, categoryBudgets, exchangeRates}
+}
+
+// アップロードされたアーカイブを読み込む（JSONまたはエクスポートしたZIP）
+//
+// ZIPの場合は添付ファイルの本体も添付ファイルのIDごとに返す（JSONの場合は nil）。
+func readImportArchive(c *gin.Context) (ExportArchive, map[uint]*zip.File, error) {
+	var archive ExportArchive
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize)
+
+	var src io.Reader = c.Request.Body
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		file, err := c.FormFile("file")
+		if err != nil {
+			return archive, nil, errors.New("archive file is required (field: file)")
+		}
+		f, err := file.Open()
+		if err != nil {
+			return archive, nil, fmt.Errorf("failed to read uploaded file: %w", err)
+		}
+		defer f.Close()
+		src = f
+	}
+
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return archive, nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	// ZIPの場合は archive.json を読む
+	var files map[uint]*zip.File
+	if bytes.HasPrefix(data, []byte("PK\x03\x04")) {
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return archive, nil, fmt.Errorf("invalid zip archive: %w", err)
+		}
+		f, err := zr.Open(exportArchiveFileName)
+		if err != nil {
+			return archive, nil, errors.New("zip archive does not contain " + exportArchiveFileName)
+		}
+		defer f.Close()
+		// 圧縮前のサイズもアップロードと同じ上限までにする（高圧縮のZIPでメモリを使い切らないため）
+		if data, err = io.ReadAll(io.LimitReader(f, maxImportSize+1)); err != nil {
+			return archive, nil, fmt.Errorf("failed to read %s: %w", exportArchiveFileName, err)
+		}
+		if len(data) > maxImportSize {
+			return archive, nil, fmt.Errorf("%s is too large (max %d MB uncompressed)", exportArchiveFileName, maxImportSize>>20)
+		}
+
+		files = make(map[uint]*zip.File)
+		for _, file := range zr.File {
+			rest, ok := strings.CutPrefix(file.Name, exportAttachmentDir)
+			if !ok {
+				continue
+			}
+			idText, _, _ := strings.Cut(rest, "/")
+			if id, err := strconv.ParseUint(idText, 10, 32); err == nil && id > 0 {
+				files[uint(id)] = file
+			}
+		}
+	}
+
+	if err := json.Unmarshal(data, &archive); err != nil {
+		return archive, nil, fmt.Errorf("invalid archive: %w", err)
+	}
+	if archive.Format != exportFormatName {
+		return archive, nil, errors.New("not a money tracker export archive")
+	}
+	if archive.Version < 1 || archive.Version > exportFormatVersion {
+		return archive, nil, fmt.Errorf("unsupported archive version: %d", archive.Version)
+	}
+	return archive, files, nil
+}
+
+// アーカイブの整合性を検証する（通貨コードはここで正規化する）
+//
+// 参照先のIDがアーカイブ内に存在しない行などをすべて返す。
+func validateImportArchive(archive *ExportArchive) []gin.H {
+	var problems []gin.H
+	invalid := func(entity string, id uint, format string, args ...interface{}) {
+		problems = append(problems, gin.H{"entity": entity, "id": id, "error": fmt.Sprintf(format, args...)})
+	}
+
+	if currency, err := currencyOrDefault(archive.Profile.BaseCurrency, defaultCurrency); err != nil {
+		invalid("profile", 0, "%v", err)
+	} else {
+		archive.Profile.BaseCurrency = currency
+	}
+
+	accounts := make(map[uint]*Account)
+	for i := range archive.Accounts {
+		a := &archive.Accounts[i]
+		if a.ID == 0 || accounts[a.ID] != nil {
+			invalid("accounts", a.ID, "missing or duplicate id")
+			continue
+		}
+		accounts[a.ID] = a
+		switch a.Type {
+		case "cash", "bank", "credit_card", "emoney":
+		default:
+			invalid("accounts", a.ID, "invalid type: %q", a.Type)
+		}
+		if currency, err := normalizeCurrency(a.Currency); err != nil {
+			invalid("accounts", a.ID, "%v", err)
+		} else {
+			a.Currency = currency
+		}
+	}
+
+	categories := make(map[uint]bool)
+	categoryTypes := make(map[uint]string)
+	for _, cat := range archive.Categories {
+		if cat.ID == 0 || categories[cat.ID] {
+			invalid("categories", cat.ID, "missing or duplicate id")
+			continue
+		}
+		categories[cat.ID] = true
+		categoryTypes[cat.ID] = cat.Type
+		if cat.Type != "income" && cat.Type != "expense" {
+			invalid("categories", cat.ID, "invalid type: %q", cat.Type)
+		}
+	}
+
+	tags := make(map[uint]bool)
+	for _, tag := range archive.Tags {
+		if tag.ID == 0 || tags[tag.ID] {
+			invalid("tags", tag.ID, "missing or duplicate id")
+			continue
+		}
+		tags[tag.ID] = true
+		if strings.TrimSpace(tag.Name) == "" {
+			invalid("tags", tag.ID, "name is required")
+		}
+	}
+
+	payees := make(map[uint]bool)
+	for _, payee := range archive.Payees {
+		if payee.ID == 0 || payees[payee.ID] {
+			invalid("payees", payee.ID, "missing or duplicate id")
+			continue
+		}
+		payees[payee.ID] = true
+		if normalizePayeeText(payee.Name) == "" {
+			invalid("payees", payee.ID, "name is required")
+		}
+		if payee.DefaultCategoryID != nil && !categories[*payee.DefaultCategoryID] {
+			invalid("payees", payee.ID, "unknown defaultCategoryId %d", *payee.DefaultCategoryID)
+		}
+	}
+
+	rules := make(map[uint]bool)
+	for _, rule := range archive.CategoryRules {
+		if rule.ID == 0 || rules[rule.ID] {
+			invalid("categoryRules", rule.ID, "missing or duplicate id")
+			continue
+		}
+		rules[rule.ID] = true
+		if !categories[rule.CategoryID] {
+			invalid("categoryRules", rule.ID, "unknown categoryId %d", rule.CategoryID)
+		} else if rule.Type != categoryTypes[rule.CategoryID] {
+			invalid("categoryRules", rule.ID, "type %q does not match category %d", rule.Type, rule.CategoryID)
+		}
+		switch rule.MatchType {
+		case "contains":
+		case "regex":
+			if _, err := compileRulePattern(rule.Pattern); err != nil {
+				invalid("categoryRules", rule.ID, "invalid pattern: %v", err)
+			}
+		default:
+			invalid("categoryRules", rule.ID, "invalid matchType: %q", rule.MatchType)
+		}
+		if rule.MinAmount != nil && rule.MaxAmount != nil && *rule.MinAmount > *rule.MaxAmount {
+			invalid("categoryRules", rule.ID, "minAmount must not exceed maxAmount")
+		}
+	}
+
+	profiles := make(map[uint]bool)
+	for _, profile := range archive.ImportProfiles {
+		if profile.ID == 0 || profiles[profile.ID] {
+			invalid("importProfiles", profile.ID, "missing or duplicate id")
+			continue
+		}
+		profiles[profile.ID] = true
+		if strings.TrimSpace(profile.Name) == "" {
+			invalid("importProfiles", profile.ID, "name is required")
+		}
+		if profile.AccountID != 0 && accounts[profile.AccountID] == nil {
+			invalid("importProfiles", profile.ID, "unknown accountId %d", profile.AccountID)
+		}
+		if profile.DefaultIncomeCategoryID != 0 && !categories[profile.DefaultIncomeCategoryID] {
+			invalid("importProfiles", profile.ID, "unknown defaultIncomeCategoryId %d", profile.DefaultIncomeCategoryID)
+		}
+		if profile.DefaultExpenseCategoryID != 0 && !categories[profile.DefaultExpenseCategoryID] {
+			invalid("importProfiles", profile.ID, "unknown defaultExpenseCategoryId %d", profile.DefaultExpenseCategoryID)
+		}
+		for _, rule := range profile.CategoryRules {
+			if !categories[rule.CategoryID] {
+				invalid("importProfiles", profile.ID, "unknown categoryRules categoryId %d", rule.CategoryID)
+			}
+		}
+	}
+
+	transactions := make(map[uint]bool)
+	for i := range archive.Transactions {
+		t := &archive.Transactions[i]
+		transactions[t.ID] = true
+		if t.PayeeID != nil && !payees[*t.PayeeID] {
+			invalid("transactions", t.ID, "unknown payeeId %d", *t.PayeeID)
+		}
+		for _, tag := range t.Tags {
+			if !tags[tag.ID] {
+				invalid("transactions", t.ID, "unknown tag id %d", tag.ID)
+			}
+		}
+		account, ok := accounts[t.AccountID]
+		if !ok {
+			invalid("transactions", t.ID, "unknown accountId %d", t.AccountID)
+			continue
+		}
+		if currency, err := normalizeCurrency(t.Currency); err != nil || currency != account.Currency {
+			invalid("transactions", t.ID, "currency %q does not match account %d", t.Currency, t.AccountID)
+		} else {
+			t.Currency = currency
+		}
+		if err := checkCurrencyUnit("amount", t.Amount, account.Currency); err != nil {
+			invalid("transactions", t.ID, "%v", err)
+		}
+
+		if len(t.Splits) > 0 {
+			var total Money
+			for _, split := range t.Splits {
+				if !categories[split.CategoryID] {
+					invalid("transactions", t.ID, "unknown split categoryId %d", split.CategoryID)
+				}
+				if err := checkCurrencyUnit("split amount", split.Amount, account.Currency); err != nil {
+					invalid("transactions", t.ID, "%v", err)
+				}
+				total += split.Amount
+			}
+			if t.Type == "transfer" || total != t.Amount {
+				invalid("transactions", t.ID, "splits must sum to the amount of an income or expense transaction")
+			}
+		}
+
+		switch t.Type {
+		case "income", "expense":
+			if !categories[t.CategoryID] {
+				invalid("transactions", t.ID, "unknown categoryId %d", t.CategoryID)
+			}
+		case "transfer":
+			if t.ToAccountID == nil || accounts[*t.ToAccountID] == nil {
+				invalid("transactions", t.ID, "transfer requires a known toAccountId")
+			} else if *t.ToAccountID == t.AccountID {
+				invalid("transactions", t.ID, "cannot transfer to the same account")
+			} else if err := checkCurrencyUnit("toAmount", t.ToAmount, accounts[*t.ToAccountID].Currency); err != nil {
+				invalid("transactions", t.ID, "%v", err)
+			}
+		default:
+			invalid("transactions", t.ID, "invalid type: %q", t.Type)
+		}
+	}
+
+	attachments := make(map[uint]bool)
+	for _, a := range archive.Attachments {
+		if a.ID == 0 || attachments[a.ID] {
+			invalid("attachments", a.ID, "missing or duplicate id")
+			continue
+		}
+		attachments[a.ID] = true
+		if !transactions[a.TransactionID] {
+			invalid("attachments", a.ID, "unknown transactionId %d", a.TransactionID)
+		}
+	}
+
+	for _, b := range archive.Budgets {
+		if b.Month < 1 || b.Month > 12 || b.Year < 1 {
+			invalid("budgets", b.ID, "invalid year/month: %d/%d", b.Year, b.Month)
+		}
+		if err := checkCurrencyUnit("amount", b.Amount, archive.Profile.BaseCurrency); err != nil {
+			invalid("budgets", b.ID, "%v", err)
+		}
+	}
+
+	for i := range archive.FixedExpenses {
+		f := &archive.FixedExpenses[i]
+		if f.AccountID != 0 && accounts[f.AccountID] == nil {
+			invalid("fixedExpenses", f.ID, "unknown accountId %d", f.AccountID)
+		}
+		if !categories[f.CategoryID] {
+			invalid("fixedExpenses", f.ID, "unknown categoryId %d", f.CategoryID)
+		}
+		if f.Type != "income" && f.Type != "expense" {
+			invalid("fixedExpenses", f.ID, "invalid type: %q", f.Type)
+		}
+		if currency, err := normalizeCurrency(f.Currency); err != nil {
+			invalid("fixedExpenses", f.ID, "%v", err)
+		} else {
+			f.Currency = currency
+			if err := checkCurrencyUnit("amount", f.Amount, currency); err != nil {
+				invalid("fixedExpenses", f.ID, "%v", err)
+			}
+		}
+	}
+
+	for _, cb := range archive.CategoryBudgets {
+		if !categories[cb.CategoryID] {
+			invalid("categoryBudgets", cb.ID, "unknown categoryId %d", cb.CategoryID)
+		}
+		if cb.Month < 1 || cb.Month > 12 || cb.Year < 1 {
+			invalid("categoryBudgets", cb.ID, "invalid year/month: %d/%d", cb.Year, cb.Month)
+		}
+		if err := checkCurrencyUnit("amount", cb.Amount, archive.Profile.BaseCurrency); err != nil {
+			invalid("categoryBudgets", cb.ID, "%v", err)
+		}
+	}
+
+	rates := make(map[string]bool)
+	for i := range archive.ExchangeRates {
+		r := &archive.ExchangeRates[i]
+		from, fromErr := normalizeCurrency(r.FromCurrency)
+		to, toErr := normalizeCurrency(r.ToCurrency)
+		if fromErr != nil || toErr != nil || from == to || r.Rate <= 0 {
+			invalid("exchangeRates", r.ID, "invalid rate %s/%s: %v", r.FromCurrency, r.ToCurrency, r.Rate)
+			continue
+		}
+		r.FromCurrency, r.ToCurrency = from, to
+
+		key := formatDate(r.Date) + from + to
+		if rates[key] {
+			invalid("exchangeRates", r.ID, "duplicate rate for %s %s/%s", formatDate(r.Date), from, to)
+		}
+		rates[key] = true
+	}
+
+	return problems
+}
+
+// アーカイブの添付ファイルを新しいキーで保存する（TransactionID はアーカイブのままにする）
+//
+// 本体がないもの（JSON形式のアーカイブなど）は復元せず、その件数を返す。
+// 内容が壊れているものや対応していない形式のものがあれば、保存済みのファイルを消してエラーにする。
+func storeImportedAttachments(userID uint, archive ExportArchive, files map[uint]*zip.File) ([]Attachment, int, error) {
+	maxSize := attachmentMaxSize()
+	var stored []Attachment
+	skipped := 0
+	for _, a := range archive.Attachments {
+		file, ok := files[a.ID]
+		if !ok {
+			skipped++
+			continue
+		}
+		data, err := readImportAttachment(a, file, maxSize)
+		if err == nil {
+			attachment := Attachment{UserID: userID, TransactionID: a.TransactionID, CreatedAt: a.CreatedAt}
+			contentType, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+			attachment.FileName = attachmentFileName(a.FileName, attachmentTypes[contentType])
+			if err = storeAttachmentFile(&attachment, data, contentType); err == nil {
+				stored = append(stored, attachment)
+				continue
+			}
+		}
+		removeAttachmentObjects(stored)
+		return nil, 0, err
+	}
+	return stored, skipped, nil
+}
+
+// ZIP内の添付ファイルを読み、サイズ・形式・ハッシュを確認する
+func readImportAttachment(a Attachment, file *zip.File, maxSize int64) ([]byte, error) {
+	f, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("%w %d: %v", errInvalidImportAttachment, a.ID, err)
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w %d: %v", errInvalidImportAttachment, a.ID, err)
+	}
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("%w %d: file must not exceed %d MB", errInvalidImportAttachment, a.ID, maxSize>>20)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("%w %d: file is empty", errInvalidImportAttachment, a.ID)
+	}
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	if _, ok := attachmentTypes[contentType]; !ok {
+		return nil, fmt.Errorf("%w %d: unsupported file type %s", errInvalidImportAttachment, a.ID, contentType)
+	}
+	sum := sha256.Sum256(data)
+	if a.SHA256 != "" && !strings.EqualFold(a.SHA256, hex.EncodeToString(sum[:])) {
+		return nil, fmt.Errorf("%w %d: sha256 does not match", errInvalidImportAttachment, a.ID)
+	}
+	return data, nil
+}
+
+// アーカイブを空のアカウントに取り込む（IDは振り直す）
+//
+// attachments は storeImportedAttachments で保存済みの添付ファイル。
+func restoreArchive(tx *gorm.DB, userID uint, archive ExportArchive, attachments []Attachment) (gin.H, error) {
+	// 既存のデータと混ざらないよう、取引などが1件もないアカウントにのみ取り込む
+	for _, model := range []interface{}{&Transaction{}, &Tag{}, &Payee{}, &CategoryRule{}, &ImportProfile{}, &Budget{}, &FixedExpense{}, &CategoryBudget{}, &ExchangeRate{}} {
+		var count int64
+		if err := tx.Model(model).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			return nil, errImportTargetNotEmpty
+		}
+	}
+
+	// 登録時に作られたデフォルトのカテゴリ・口座はアーカイブの内容で置き換える
+	if err := tx.Where("user_id = ?", userID).Delete(&Category{}).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("user_id = ?", userID).Delete(&Account{}).Error; err != nil {
+		return nil, err
+	}
+
+	profile := map[string]interface{}{"base_currency": archive.Profile.BaseCurrency}
+	if archive.Profile.Name != "" {
+		profile["name"] = archive.Profile.Name
+	}
+	if err := tx.Model(&User{}).Where("id = ?", userID).Updates(profile).Error; err != nil {
+		return nil, err
+	}
+
+	// 口座（デフォルト口座は1つだけにする）
+	accountIDs := make(map[uint]uint)
+	var inactiveAccounts []uint
+	hasDefault := false
+	for _, a := range archive.Accounts {
+		oldID, active := a.ID, a.IsActive
+		a.ID, a.UserID = 0, userID
+		a.IsDefault = a.IsDefault && !hasDefault
+		hasDefault = hasDefault || a.IsDefault
+		if err := tx.Create(&a).Error; err != nil {
+			return nil, err
+		}
+		accountIDs[oldID] = a.ID
+		if !active {
+			inactiveAccounts = append(inactiveAccounts, a.ID)
+		}
+	}
+	// is_active はゼロ値だと既定値（true）で作成されるため後から更新する
+	if len(inactiveAccounts) > 0 {
+		if err := tx.Model(&Account{}).Where("id IN ?", inactiveAccounts).Update("is_active", false).Error; err != nil {
+			return nil, err
+		}
+	}
+	var defaultAccountID uint
+	switch {
+	case len(archive.Accounts) == 0:
+		account := Account{UserID: userID, Name: "現金", Type: "cash", Currency: archive.Profile.BaseCurrency, IsDefault: true, IsActive: true}
+		if err := tx.Create(&account).Error; err != nil {
+			return nil, err
+		}
+		defaultAccountID = account.ID
+	case !hasDefault:
+		defaultAccountID = accountIDs[archive.Accounts[0].ID]
+		if err := setDefaultAccount(tx, userID, defaultAccountID); err != nil {
+			return nil, err
+		}
+	default:
+		for _, a := range archive.Accounts {
+			if a.IsDefault {
+				defaultAccountID = accountIDs[a.ID]
+				break
+			}
+		}
+	}
+
+	categoryIDs := make(map[uint]uint)
+	for _, cat := range archive.Categories {
+		oldID := cat.ID
+		cat.ID, cat.UserID = 0, userID
+		if err := tx.Create(&cat).Error; err != nil {
+			return nil, err
+		}
+		categoryIDs[oldID] = cat.ID
+	}
+
+	tagIDs := make(map[uint]uint)
+	for _, tag := range archive.Tags {
+		oldID := tag.ID
+		tag.ID, tag.UserID, tag.TransactionCount = 0, userID, 0
+		if err := tx.Create(&tag).Error; err != nil {
+			return nil, err
+		}
+		tagIDs[oldID] = tag.ID
+	}
+
+	payeeIDs := make(map[uint]uint)
+	for _, payee := range archive.Payees {
+		oldID := payee.ID
+		payee.ID, payee.UserID, payee.TransactionCount = 0, userID, 0
+		if payee.DefaultCategoryID != nil {
+			defaultCategoryID := categoryIDs[*payee.DefaultCategoryID]
+			payee.DefaultCategoryID = &defaultCategoryID
+		}
+		if err := tx.Omit(clause.Associations).Create(&payee).Error; err != nil {
+			return nil, err
+		}
+		payeeIDs[oldID] = payee.ID
+
+		// 別名は正規化し直す（名前や他の別名と同じになるもの、短すぎるものは除く）
+		seen := map[string]bool{normalizePayeeText(payee.Name): true}
+		var aliases []PayeeAlias
+		for _, alias := range payee.Aliases {
+			normalized := normalizePayeeText(alias.Alias)
+			if utf8.RuneCountInString(normalized) < minPayeeMatchLength || seen[normalized] {
+				continue
+			}
+			seen[normalized] = true
+			aliases = append(aliases, PayeeAlias{UserID: userID, PayeeID: payee.ID, Alias: alias.Alias, Normalized: normalized})
+		}
+		if len(aliases) > 0 {
+			if err := tx.Create(&aliases).Error; err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for i := range archive.CategoryRules {
+		rule := &archive.CategoryRules[i]
+		rule.ID, rule.UserID = 0, userID
+		rule.CategoryID = categoryIDs[rule.CategoryID]
+	}
+
+	// 取込設定の口座・カテゴリ（0は未指定）
+	for i := range archive.ImportProfiles {
+		profile := &archive.ImportProfiles[i]
+		profile.ID, profile.UserID = 0, userID
+		if profile.AccountID != 0 {
+			profile.AccountID = accountIDs[profile.AccountID]
+		}
+		if profile.DefaultIncomeCategoryID != 0 {
+			profile.DefaultIncomeCategoryID = categoryIDs[profile.DefaultIncomeCategoryID]
+		}
+		if profile.DefaultExpenseCategoryID != 0 {
+			profile.DefaultExpenseCategoryID = categoryIDs[profile.DefaultExpenseCategoryID]
+		}
+		rules := make(ImportCategoryRules, len(profile.CategoryRules))
+		for j, rule := range profile.CategoryRules {
+			rules[j] = ImportCategoryRule{Keyword: rule.Keyword, CategoryID: categoryIDs[rule.CategoryID]}
+		}
+		profile.CategoryRules = rules
+	}
+
+	// 重複の確認済みの記録は、登録後に新しいIDで付け直す
+	transactionIDs := make([]uint, len(archive.Transactions))
+	duplicateOf := make(map[int]uint)
+	for i := range archive.Transactions {
+		t := &archive.Transactions[i]
+		transactionIDs[i] = t.ID
+		if t.DuplicateOfID != nil {
+			duplicateOf[i] = *t.DuplicateOfID
+			t.DuplicateOfID = nil
+		}
+		t.ID, t.UserID = 0, userID
+		t.AccountID = accountIDs[t.AccountID]
+		t.CategoryID = categoryIDs[t.CategoryID]
+		if t.PayeeID != nil {
+			payeeID := payeeIDs[*t.PayeeID]
+			t.PayeeID = &payeeID
+		}
+		if t.ToAccountID != nil {
+			toAccountID := accountIDs[*t.ToAccountID]
+			t.ToAccountID = &toAccountID
+		}
+		for j := range t.Splits {
+			t.Splits[j].ID, t.Splits[j].UserID = 0, userID
+			t.Splits[j].CategoryID = categoryIDs[t.Splits[j].CategoryID]
+		}
+	}
+
+	for i := range archive.Budgets {
+		archive.Budgets[i].ID, archive.Budgets[i].UserID = 0, userID
+	}
+
+	var inactiveFixedExpenses []int
+	for i := range archive.FixedExpenses {
+		f := &archive.FixedExpenses[i]
+		f.ID, f.UserID = 0, userID
+		f.CategoryID = categoryIDs[f.CategoryID]
+		if f.AccountID == 0 {
+			f.AccountID = defaultAccountID
+		} else {
+			f.AccountID = accountIDs[f.AccountID]
+		}
+		if !f.IsActive {
+			inactiveFixedExpenses = append(inactiveFixedExpenses, i)
+		}
+	}
+
+	for i := range archive.CategoryBudgets {
+		cb := &archive.CategoryBudgets[i]
+		cb.ID, cb.UserID = 0, userID
+		cb.CategoryID = categoryIDs[cb.CategoryID]
+	}
+
+	for i := range archive.ExchangeRates {
+		archive.ExchangeRates[i].ID, archive.ExchangeRates[i].UserID = 0, userID
+	}
+
+	// 関連（Category）は保存しない
+	batches := []struct {
+		rows  interface{}
+		count int
+	}{
+		{&archive.CategoryRules, len(archive.CategoryRules)},
+		{&archive.ImportProfiles, len(archive.ImportProfiles)},
+		{&archive.Transactions, len(archive.Transactions)},
+		{&archive.Budgets, len(archive.Budgets)},
+		{&archive.FixedExpenses, len(archive.FixedExpenses)},
+		{&archive.CategoryBudgets, len(archive.CategoryBudgets)},
+		{&archive.ExchangeRates, len(archive.ExchangeRates)},
+	}
+	for _, batch := range batches {
+		if batch.count == 0 {
+			continue
+		}
+		if err := tx.Omit(clause.Associations).CreateInBatches(batch.rows, 500).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	// 内訳・タグは登録した取引のIDで作る
+	var splits []TransactionSplit
+	var transactionTags []TransactionTag
+	for _, t := range archive.Transactions {
+		for _, split := range t.Splits {
+			split.TransactionID = t.ID
+			splits = append(splits, split)
+		}
+		for _, tag := range t.Tags {
+			transactionTags = append(transactionTags, TransactionTag{TransactionID: t.ID, TagID: tagIDs[tag.ID]})
+		}
+	}
+	if len(transactionTags) > 0 {
+		if err := tx.CreateInBatches(&transactionTags, 500).Error; err != nil {
+			return nil, err
+		}
+	}
+	if len(splits) > 0 {
+		if err := tx.Omit(clause.Associations).CreateInBatches(&splits, 500).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	newIDs := make(map[uint]uint, len(transactionIDs))
+	for i, oldID := range transactionIDs {
+		newIDs[oldID] = archive.Transactions[i].ID
+	}
+
+	// 添付ファイルは登録した取引に付け直す
+	if len(attachments) > 0 {
+		for i := range attachments {
+			attachments[i].TransactionID = newIDs[attachments[i].TransactionID]
+		}
+		if err := tx.CreateInBatches(&attachments, 500).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	if len(duplicateOf) > 0 {
+		for i, oldKeepID := range duplicateOf {
+			keepID, ok := newIDs[oldKeepID]
+			if !ok {
+				continue
+			}
+			if err := tx.Model(&Transaction{}).Where("id = ?", archive.Transactions[i].ID).Update("duplicate_of_id", keepID).Error; err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if _, err := indexTransactions(tx, tx.Model(&Transaction{}).Select("id").Where("user_id = ?", userID)); err != nil {
+		return nil, err
+	}
+
+	if len(inactiveFixedExpenses) > 0 {
+		ids := make([]uint, 0, len(inactiveFixedExpenses))
+		for _, i := range inactiveFixedExpenses {
+			ids = append(ids, archive.FixedExpenses[i].ID)
+		}
+		if err := tx.Model(&FixedExpense{}).Where("id IN ?", ids).Update("is_active", false).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	return gin.H{
+		"accounts":        len(archive.Accounts),
+		"categories":      len(archive.Categories),
+		"tags":            len(archive.Tags),
+		"payees":          len(archive.Payees),
+		"categoryRules":   len(archive.CategoryRules),
+		"importProfiles":  len(archive.ImportProfiles),
+		"transactions":    len(archive.Transactions),
+		"attachments":     len(attachments),
+		"budgets":         len(archive.Budgets),
+		"fixedExpenses":   len(archive.FixedExpenses),
+		"categoryBudgets": len(archive.CategoryBudgets),
+		"exchangeRates":   len(archive.ExchangeRates),
+	}, nil
+}
+
+// データインポート（エクスポートしたアーカイブを新しいアカウントに復元する）
+//
+// 1件でも不正なデータがあれば何も登録しない。
+func importUserData(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	archive, files, err := readImportArchive(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if problems := validateImportArchive(&archive); len(problems) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Archive failed integrity checks", "problems": problems})
+		return
+	}
+
+	// 添付ファイルの本体は先に保存し、登録に失敗したら消す
+	attachments, skippedAttachments, err := storeImportedAttachments(userID.(uint), archive, files)
+	if errors.Is(err, errInvalidImportAttachment) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store attachments: " + err.Error()})
+		return
+	}
+
+	var imported gin.H
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		imported, err = restoreArchive(tx, userID.(uint), archive, attachments)
+		return err
+	})
+	if err != nil {
+		removeAttachmentObjects(attachments)
+	}
+	if errors.Is(err, errImportTargetNotEmpty) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Import is only allowed into an account without transactions, tags, payees, category rules, import profiles, budgets, fixed expenses or exchange rates"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import data: " + err.Error()})
+		return
+	}
+
+	// JSON形式のアーカイブには添付ファイルの本体が含まれない
+	imported["attachmentsSkipped"] = skippedAttachments
+	c.JSON(http.StatusOK, gin.H{"imported": imported})
+}
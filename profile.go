@@ -0,0 +1,330 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// アカウント削除の猶予期間（この間は復元できる）
+var accountDeletionGracePeriod = getEnvDuration("ACCOUNT_DELETION_GRACE_PERIOD", 30*24*time.Hour)
+
+// プロフィール更新
+//
+// メールアドレスを変更する場合は新しいアドレスに確認メールを送り、確認されるまでは現在のアドレスのまま。
+func updateProfile(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var req UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user User
+	if err := db.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "ユーザーが見つかりません"})
+		return
+	}
+
+	user.Name = req.Name
+	// 大文字・小文字だけの変更も変更として扱う（重複の確認では区別しない）
+	email := strings.TrimSpace(req.Email)
+	emailChanged := email != user.Email
+
+	if emailChanged {
+		if !checkPasswordHash(req.CurrentPassword, user.Password) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "パスワードが間違っています"})
+			return
+		}
+
+		var count int64
+		db.Model(&User{}).Where("LOWER(email) = LOWER(?) AND id <> ?", email, user.ID).Count(&count)
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "このメールアドレスは既に登録されています"})
+			return
+		}
+		user.PendingEmail = email
+	}
+
+	if err := db.Model(&user).Updates(map[string]interface{}{"name": user.Name, "pending_email": user.PendingEmail}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "プロフィールの更新に失敗しました"})
+		return
+	}
+
+	if emailChanged {
+		if err := sendEmailChangeConfirmation(user); err != nil {
+			log.Printf("[MAIL] ERROR: Failed to send email change confirmation to user %d: %v", user.ID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "確認メールの送信に失敗しました"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, user)
+}
+
+// 新しいメールアドレスに確認メールを送信
+func sendEmailChangeConfirmation(user User) error {
+	token, err := createUserToken(db, user.ID, purposeEmailChange, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	return mailer.Send(Mail{
+		To:      user.PendingEmail,
+		Subject: "【MoneyTracker】メールアドレス変更の確認",
+		Body: user.Name + " 様\n\n" +
+			"メールアドレスを " + user.PendingEmail + " に変更するリクエストを受け付けました。\n" +
+			"以下のリンクから変更を確定してください。\n\n" +
+			appLink("/verify-email", token) + "\n\n" +
+			"このリンクの有効期限は " + emailVerificationTTL.String() + " です。\n" +
+			"心当たりがない場合は、このメールを破棄してください。\n",
+	})
+}
+
+// 確認済みの新しいメールアドレスに切り替える
+func applyEmailChange(tx *gorm.DB, userID uint) (User, error) {
+	var user User
+	if err := tx.First(&user, userID).Error; err != nil {
+		return user, err
+	}
+	if user.PendingEmail == "" {
+		return user, errInvalidUserToken
+	}
+
+	var count int64
+	tx.Model(&User{}).Where("LOWER(email) = LOWER(?) AND id <> ?", user.PendingEmail, user.ID).Count(&count)
+	if count > 0 {
+		return user, errEmailTaken
+	}
+
+	// 返り値は変更前の状態（旧アドレスへの通知に使う）
+	if err := tx.Model(&User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+		"email":          user.PendingEmail,
+		"pending_email":  "",
+		"email_verified": true,
+	}).Error; err != nil {
+		return user, err
+	}
+	return user, nil
+}
+
+// 変更前のアドレスに通知（乗っ取りに気付けるように）
+func notifyEmailChanged(user User, newEmail string) {
+	err := mailer.Send(Mail{
+		To:      user.Email,
+		Subject: "【MoneyTracker】メールアドレスが変更されました",
+		Body: user.Name + " 様\n\n" +
+			"アカウントのメールアドレスが " + newEmail + " に変更されました。\n" +
+			"心当たりがない場合は、至急パスワードを再設定してください。\n",
+	})
+	if err != nil {
+		log.Printf("[MAIL] ERROR: Failed to send email change notice to user %d: %v", user.ID, err)
+	}
+}
+
+// パスワード変更（このセッション以外のログインは無効にする）
+func changePassword(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	sessionID, _ := c.Get("sessionID")
+
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user User
+	if err := db.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "ユーザーが見つかりません"})
+		return
+	}
+	if !checkPasswordHash(req.CurrentPassword, user.Password) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "現在のパスワードが間違っています"})
+		return
+	}
+
+	hashedPassword, err := hashPassword(req.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "パスワードの処理に失敗しました"})
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("password", hashedPassword).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Session{}).Where("user_id = ? AND id <> ? AND revoked_at IS NULL", user.ID, sessionID).
+			Updates(map[string]interface{}{"revoked_at": time.Now(), "revoked_reason": "password_changed"}).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "パスワードの変更に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "パスワードを変更しました"})
+}
+
+// ユーザーアカウント削除（猶予期間の後にデータを完全に削除する）
+func deleteUserAccount(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var req DeleteAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user User
+	if err := db.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "ユーザーが見つかりません"})
+		return
+	}
+	if !checkPasswordHash(req.Password, user.Password) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "パスワードが間違っています"})
+		return
+	}
+	if user.TOTPEnabled {
+		ok, err := verifySecondFactor(db, user, req.Code)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "認証コードの確認に失敗しました"})
+			return
+		}
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "認証コードが正しくありません"})
+			return
+		}
+	}
+
+	scheduledAt := time.Now().Add(accountDeletionGracePeriod)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Update("deletion_scheduled_at", scheduledAt).Error; err != nil {
+			return err
+		}
+		// ログインとアクセストークンはすぐに使えなくする
+		if err := revokeUserSessions(tx, user.ID, "account_deleted"); err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", user.ID).Delete(&PersonalAccessToken{}).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "アカウントの削除に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":             "アカウントを削除しました。期限までは復元できます",
+		"deletionScheduledAt": scheduledAt,
+	})
+}
+
+// 削除予定のアカウントを復元
+func restoreUserAccount(c *gin.Context) {
+	var req LoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ip := c.ClientIP()
+	if err := checkLoginThrottle(req.Email, ip); err != nil {
+		if !respondLockedOut(c, err) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "アカウントの復元に失敗しました"})
+		}
+		return
+	}
+
+	var user User
+	if err := db.Where("LOWER(email) = LOWER(?)", req.Email).First(&user).Error; err != nil || !checkPasswordHash(req.Password, user.Password) {
+		recordLoginFailure(req.Email, ip)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "メールアドレスまたはパスワードが間違っています"})
+		return
+	}
+	if user.DeletionScheduledAt == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "このアカウントは削除予定ではありません"})
+		return
+	}
+
+	if err := db.Model(&user).Update("deletion_scheduled_at", nil).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "アカウントの復元に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "アカウントを復元しました。再度ログインしてください"})
+}
+
+// ユーザーとそのデータをすべて削除する（1つのトランザクションで実行）
+func purgeUserData(tx *gorm.DB, userID uint) error {
+	// セッションに紐づくリフレッシュトークン
+	if err := tx.Where("session_id IN (?)", tx.Model(&Session{}).Select("id").Where("user_id = ?", userID)).
+		Delete(&RefreshToken{}).Error; err != nil {
+		return err
+	}
+
+	// 取引とタグの対応
+	if err := tx.Where("tag_id IN (?)", tx.Model(&Tag{}).Select("id").Where("user_id = ?", userID)).
+		Delete(&TransactionTag{}).Error; err != nil {
+		return err
+	}
+
+	owned := []interface{}{
+		&Attachment{},
+		&TransactionSearch{},
+		&TransactionSplit{},
+		&Tag{},
+		&Transaction{},
+		&FixedExpense{},
+		&CategoryBudget{},
+		&Budget{},
+		&Category{},
+		&Account{},
+		&ExchangeRate{},
+		&Session{},
+		&UserToken{},
+		&RecoveryCode{},
+		&PersonalAccessToken{},
+		&LoginAttempt{},
+		&ImportProfile{},
+		&CategoryRule{},
+		&PayeeAlias{},
+		&Payee{},
+	}
+	for _, model := range owned {
+		if err := tx.Where("user_id = ?", userID).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+
+	return tx.Delete(&User{}, userID).Error
+}
+
+// 猶予期間が過ぎたアカウントを完全に削除する（日次バッチ）
+func purgeDeletedUsers() {
+	var users []User
+	if err := db.Where("deletion_scheduled_at IS NOT NULL AND deletion_scheduled_at < ?", time.Now()).Find(&users).Error; err != nil {
+		log.Printf("[BATCH] ERROR: Failed to fetch users scheduled for deletion: %v", err)
+		return
+	}
+
+	for _, user := range users {
+		// 添付ファイルの本体は記録の削除後に保存先から消す
+		var attachments []Attachment
+		if err := db.Where("user_id = ?", user.ID).Find(&attachments).Error; err != nil {
+			log.Printf("[BATCH] ERROR: Failed to purge user %d: %v", user.ID, err)
+			continue
+		}
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			return purgeUserData(tx, user.ID)
+		}); err != nil {
+			log.Printf("[BATCH] ERROR: Failed to purge user %d: %v", user.ID, err)
+			continue
+		}
+		removeAttachmentObjects(attachments)
+		categoryModels.forget(user.ID)
+		log.Printf("[BATCH] Purged user %d and all of their data", user.ID)
+	}
+}
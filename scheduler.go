@@ -83,4 +83,15 @@ func checkAndProcessCurrentMonth() {
 		log.Printf("[BATCH] Processing already completed for %s: %d transactions processed", 
 			currentMonth, processedCount)
 	}
-}
\ No newline at end of file
+}
+// 日次のメンテナンス処理を開始する関数（期限切れセッションの削除など）
+func startDailyMaintenance() {
+	go func() {
+		for {
+			purgeExpiredSessions()
+			purgeLoginAttempts()
+			purgeDeletedUsers()
+			time.Sleep(24 * time.Hour)
+		}
+	}()
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/unicode/norm"
+	"gorm.io/gorm"
+)
+
+// 取引の全文検索（説明・支払先・タグ・カテゴリ名）
+//
+// 単語の区切りがない日本語でも探せるよう、漢字・かなは文字の2-gramに分け、英数字は単語ごとに索引を作る。
+// 索引（transaction_searches）はGoで変換した文字列を持ち、データベースごとの全文検索で引く。
+//   - SQLite: FTS5（外部コンテンツ。トリガーで transaction_searches と同期する）。FTS5 なしでビルドした場合は LIKE で探す
+//   - MySQL: ngram パーサーの FULLTEXT インデックス（ngram_token_size は既定の 2）
+//   - PostgreSQL: to_tsvector('simple') の GIN インデックス
+
+// SQLiteでFTS5の索引を使えるか（起動時に syncSearchIndex で決める）
+var searchFTS5 bool
+
+// PostgreSQLの検索用の文書（GINインデックスと同じ式で検索する）
+const postgresSearchDocument = "setweight(to_tsvector('simple', description), 'A') || setweight(to_tsvector('simple', payee), 'B') || " +
+	"setweight(to_tsvector('simple', tags), 'B') || setweight(to_tsvector('simple', category), 'C')"
+
+var errInvalidSearchQuery = errors.New("q must contain letters or digits")
+
+// 検索語の最大数
+const maxSearchTerms = 10
+
+// transaction_searches の変更を FTS5 の索引に反映するトリガー
+var searchFTSTriggers = map[string]string{
+	"transaction_searches_ai": `AFTER INSERT ON transaction_searches BEGIN
+		INSERT INTO transaction_searches_fts(rowid, description, payee, tags, category)
+		VALUES (new.transaction_id, new.description, new.payee, new.tags, new.category);
+	END`,
+	"transaction_searches_ad": `AFTER DELETE ON transaction_searches BEGIN
+		INSERT INTO transaction_searches_fts(transaction_searches_fts, rowid, description, payee, tags, category)
+		VALUES ('delete', old.transaction_id, old.description, old.payee, old.tags, old.category);
+	END`,
+	"transaction_searches_au": `AFTER UPDATE ON transaction_searches BEGIN
+		INSERT INTO transaction_searches_fts(transaction_searches_fts, rowid, description, payee, tags, category)
+		VALUES ('delete', old.transaction_id, old.description, old.payee, old.tags, old.category);
+		INSERT INTO transaction_searches_fts(rowid, description, payee, tags, category)
+		VALUES (new.transaction_id, new.description, new.payee, new.tags, new.category);
+	END`,
+}
+
+// SQLiteのFTS5の索引とトリガーを作り、使えるかを返す
+//
+// FTS5なしでビルドした場合はトリガーを実行できないため外しておき、FTS5ありで起動したときに索引ごと作り直す。
+func createSearchFTS(tx *gorm.DB) (bool, error) {
+	var available int
+	if err := tx.Raw("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&available).Error; err != nil {
+		return false, err
+	}
+	if available == 0 {
+		for name := range searchFTSTriggers {
+			if err := tx.Exec("DROP TRIGGER IF EXISTS " + name).Error; err != nil {
+				return false, err
+			}
+		}
+		return false, nil
+	}
+
+	var triggers int64
+	if err := tx.Raw("SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND tbl_name = 'transaction_searches'").Scan(&triggers).Error; err != nil {
+		return false, err
+	}
+	if tx.Migrator().HasTable("transaction_searches_fts") && triggers == int64(len(searchFTSTriggers)) {
+		return true, nil
+	}
+
+	statements := []string{
+		`CREATE VIRTUAL TABLE IF NOT EXISTS transaction_searches_fts USING fts5(description, payee, tags, category,
+			content='transaction_searches', content_rowid='transaction_id', tokenize='unicode61 remove_diacritics 0')`,
+	}
+	for name, body := range searchFTSTriggers {
+		statements = append(statements, "CREATE TRIGGER IF NOT EXISTS "+name+" "+body)
+	}
+	// 登録済みの索引から作り直す
+	statements = append(statements, `INSERT INTO transaction_searches_fts(transaction_searches_fts) VALUES ('rebuild')`)
+	for _, statement := range statements {
+		if err := tx.Exec(statement).Error; err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// 起動時に索引を整える
+//
+// SQLiteでFTS5が使えるようになっていれば索引を作り、削除済みの取引の行を消し、索引のない取引（初期データなど）を登録する。
+func syncSearchIndex() {
+	if dbDriver == driverSQLite {
+		fts, err := createSearchFTS(db)
+		if err != nil {
+			log.Printf("[SEARCH] ERROR: Failed to create FTS5 index: %v", err)
+		}
+		searchFTS5 = fts
+		if !fts {
+			log.Printf("[SEARCH] SQLite was built without FTS5 (build with -tags sqlite_fts5); falling back to LIKE search")
+		}
+	}
+
+	if err := db.Where("transaction_id NOT IN (?)", db.Model(&Transaction{}).Select("id")).Delete(&TransactionSearch{}).Error; err != nil {
+		log.Printf("[SEARCH] ERROR: Failed to remove stale search entries: %v", err)
+		return
+	}
+	missing := db.Model(&Transaction{}).Select("id").Where("id NOT IN (?)", db.Model(&TransactionSearch{}).Select("transaction_id"))
+	indexed, err := indexTransactions(db, missing)
+	if err != nil {
+		log.Printf("[SEARCH] ERROR: Failed to index transactions: %v", err)
+		return
+	}
+	if indexed > 0 {
+		log.Printf("[SEARCH] Indexed %d transaction(s)", indexed)
+	}
+}
+
+// 取引の索引を作り直し、登録した件数を返す
+//
+// transactionIDs は取引IDの一覧またはサブクエリ。取引の保存と同じトランザクションで呼ぶこと。
+func indexTransactions(tx *gorm.DB, transactionIDs interface{}) (int, error) {
+	var ids []uint
+	if err := tx.Model(&Transaction{}).Where("id IN (?)", transactionIDs).Order("id").Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+
+	for start := 0; start < len(ids); start += 500 {
+		chunk := ids[start:min(start+500, len(ids))]
+
+		var transactions []Transaction
+		if err := tx.Preload("Category").Preload("Splits.Category").Preload("Tags").Where("id IN ?", chunk).Find(&transactions).Error; err != nil {
+			return 0, err
+		}
+		payeeNames, err := loadPayeeNames(tx, transactions)
+		if err != nil {
+			return 0, err
+		}
+
+		rows := make([]TransactionSearch, 0, len(transactions))
+		for _, t := range transactions {
+			fields := searchFields(t, payeeNames)
+			rows = append(rows, TransactionSearch{
+				TransactionID: t.ID,
+				UserID:        t.UserID,
+				Description:   searchIndexText(fields["description"]),
+				Payee:         searchIndexText(fields["payee"]),
+				Tags:          searchIndexText(fields["tags"]),
+				Category:      searchIndexText(fields["category"]),
+			})
+		}
+
+		if err := tx.Where("transaction_id IN ?", chunk).Delete(&TransactionSearch{}).Error; err != nil {
+			return 0, err
+		}
+		if err := tx.Create(&rows).Error; err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), nil
+}
+
+// 削除する取引の索引を消す（transactionIDs は取引IDの一覧またはサブクエリ）
+func removeFromSearchIndex(tx *gorm.DB, transactionIDs interface{}) error {
+	return tx.Where("transaction_id IN (?)", transactionIDs).Delete(&TransactionSearch{}).Error
+}
+
+func loadPayeeNames(tx *gorm.DB, transactions []Transaction) (map[uint]string, error) {
+	var payeeIDs []uint
+	for _, t := range transactions {
+		if t.PayeeID != nil {
+			payeeIDs = append(payeeIDs, *t.PayeeID)
+		}
+	}
+	names := make(map[uint]string)
+	if len(payeeIDs) == 0 {
+		return names, nil
+	}
+	var payees []Payee
+	if err := tx.Select("id, name").Where("id IN ?", payeeIDs).Find(&payees).Error; err != nil {
+		return nil, err
+	}
+	for _, payee := range payees {
+		names[payee.ID] = payee.Name
+	}
+	return names, nil
+}
+
+// 検索の対象にする項目（Category, Splits.Category, Tags を読み込んだ取引）
+func searchFields(t Transaction, payeeNames map[uint]string) map[string]string {
+	var categories, tags []string
+	if t.Category.Name != "" {
+		categories = append(categories, t.Category.Name)
+	}
+	for _, split := range t.Splits {
+		if split.Category.Name != "" && split.Category.Name != t.Category.Name {
+			categories = append(categories, split.Category.Name)
+		}
+	}
+	for _, tag := range t.Tags {
+		tags = append(tags, tag.Name)
+	}
+	fields := map[string]string{
+		"description": t.Description,
+		"category":    strings.Join(categories, ", "),
+		"tags":        strings.Join(tags, ", "),
+	}
+	if t.PayeeID != nil {
+		fields["payee"] = payeeNames[*t.PayeeID]
+	}
+	return fields
+}
+
+func isSearchBigramRune(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana) || r == 'ー' || r == '々'
+}
+
+// 検索用の語に分ける
+//
+// 全角・半角と大文字・小文字をそろえ、英数字は単語ごと、漢字・かなは続いている部分を2文字ずつずらして分ける（セブン → セブ ブン）。
+// 索引では1文字でも前方一致で探せるよう、漢字・かなの続きの最後の1文字も加える。
+func searchTokens(text string, forIndex bool) []string {
+	var tokens []string
+	var word, run []rune
+	flushWord := func() {
+		if len(word) > 0 {
+			tokens = append(tokens, string(word))
+			word = word[:0]
+		}
+	}
+	flushRun := func() {
+		switch {
+		case len(run) == 1:
+			tokens = append(tokens, string(run))
+		case len(run) > 1:
+			for i := 0; i+2 <= len(run); i++ {
+				tokens = append(tokens, string(run[i:i+2]))
+			}
+			if forIndex {
+				tokens = append(tokens, string(run[len(run)-1:]))
+			}
+		}
+		run = run[:0]
+	}
+
+	for _, r := range strings.ToLower(norm.NFKC.String(text)) {
+		switch {
+		case isSearchBigramRune(r):
+			flushWord()
+			run = append(run, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushRun()
+			word = append(word, r)
+		default:
+			flushWord()
+			flushRun()
+		}
+	}
+	flushWord()
+	flushRun()
+	return tokens
+}
+
+// 全角・半角と大文字・小文字をそろえ、英数字・漢字・かな以外を空白にする
+func normalizeSearchText(text string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(norm.NFKC.String(text)), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+}
+
+// 索引に保存する文字列（MySQLは ngram パーサーが分けるため正規化した文字列のまま）
+func searchIndexText(text string) string {
+	if dbDriver == driverMySQL {
+		return normalizeSearchText(text)
+	}
+	return strings.Join(searchTokens(text, true), " ")
+}
+
+// 検索語（空白区切り。すべてを含む取引を探す）
+type searchTerm struct {
+	Text   string   // 一致箇所の強調に使う
+	Tokens []string // 隣り合って並んでいる必要がある
+	Prefix bool     // 最後の語は前方一致（英数字の単語か、漢字・かな1文字）
+}
+
+func parseSearchQuery(q string) ([]searchTerm, error) {
+	var terms []searchTerm
+	for _, field := range strings.Fields(norm.NFKC.String(q)) {
+		tokens := searchTokens(field, false)
+		if len(tokens) == 0 {
+			continue
+		}
+		last := []rune(tokens[len(tokens)-1])
+		terms = append(terms, searchTerm{
+			Text:   field,
+			Tokens: tokens,
+			Prefix: !isSearchBigramRune(last[0]) || len(last) == 1,
+		})
+	}
+	if len(terms) == 0 {
+		return nil, errInvalidSearchQuery
+	}
+	if len(terms) > maxSearchTerms {
+		return nil, fmt.Errorf("q must have at most %d words", maxSearchTerms)
+	}
+	return terms, nil
+}
+
+// 検索語に一致する取引のIDと関連度を返すサブクエリ（列は transaction_id, score）
+func searchSubquery(userID interface{}, terms []searchTerm) *gorm.DB {
+	switch {
+	case dbDriver == driverMySQL:
+		parts := make([]string, 0, len(terms))
+		for _, term := range terms {
+			parts = append(parts, `+"`+normalizeSearchText(term.Text)+`"`)
+		}
+		match := strings.Join(parts, " ")
+		return db.Raw(`SELECT transaction_id, MATCH(description, payee, tags, category) AGAINST (? IN BOOLEAN MODE) AS score
+			FROM transaction_searches WHERE user_id = ? AND MATCH(description, payee, tags, category) AGAINST (? IN BOOLEAN MODE)`,
+			match, userID, match)
+
+	case dbDriver == driverPostgres:
+		parts := make([]string, 0, len(terms))
+		for _, term := range terms {
+			part := strings.Join(term.Tokens, " <-> ")
+			if term.Prefix {
+				part += ":*"
+			}
+			parts = append(parts, "("+part+")")
+		}
+		query := strings.Join(parts, " & ")
+		return db.Raw(`SELECT transaction_id, ts_rank(`+postgresSearchDocument+`, to_tsquery('simple', ?)) AS score
+			FROM transaction_searches WHERE user_id = ? AND `+postgresSearchDocument+` @@ to_tsquery('simple', ?)`,
+			query, userID, query)
+
+	case searchFTS5:
+		// bm25 は小さいほど一致しているため符号を反転する（重みは説明・支払先・タグ・カテゴリの順）
+		parts := make([]string, 0, len(terms))
+		for _, term := range terms {
+			part := `"` + strings.Join(term.Tokens, " ") + `"`
+			if term.Prefix {
+				part += " *"
+			}
+			parts = append(parts, part)
+		}
+		return db.Raw(`SELECT rowid AS transaction_id, -bm25(transaction_searches_fts, 4.0, 2.0, 2.0, 1.0) AS score
+			FROM transaction_searches_fts WHERE transaction_searches_fts MATCH ?`, strings.Join(parts, " AND "))
+
+	default:
+		// FTS5 がない場合は語の並びを LIKE で探す（関連度はなし）
+		conditions := []string{"user_id = ?"}
+		args := []interface{}{userID}
+		for _, term := range terms {
+			pattern := "% " + strings.Join(term.Tokens, " ")
+			if !term.Prefix {
+				pattern += " "
+			}
+			conditions = append(conditions, "(' ' || description || ' | ' || payee || ' | ' || tags || ' | ' || category || ' ') LIKE ?")
+			args = append(args, pattern+"%")
+		}
+		return db.Raw("SELECT transaction_id, 0 AS score FROM transaction_searches WHERE "+strings.Join(conditions, " AND "), args...)
+	}
+}
+
+// 取引一覧の全文検索
+//
+// query は絞り込み済みの取引のクエリ。sort=relevance（関連度順）または date（日付の新しい順）。
+func searchTransactions(c *gin.Context, query *gorm.DB, terms []searchTerm, offset, limit int) ([]Transaction, error) {
+	userID, _ := c.Get("userID")
+
+	query = query.Joins("JOIN (?) AS search ON search.transaction_id = transactions.id", searchSubquery(userID, terms))
+	switch c.DefaultQuery("sort", "relevance") {
+	case "relevance":
+		query = query.Order("search.score DESC, date DESC, transactions.id DESC")
+	case "date":
+		query = query.Order("date DESC, transactions.id DESC")
+	default:
+		return nil, &filterError{message: "sort must be relevance or date"}
+	}
+
+	var hits []struct {
+		ID    uint
+		Score float64
+	}
+	if err := query.Select("transactions.id, search.score").Offset(offset).Limit(limit).Scan(&hits).Error; err != nil {
+		return nil, err
+	}
+	ids := make([]uint, 0, len(hits))
+	for _, hit := range hits {
+		ids = append(ids, hit.ID)
+	}
+
+	var transactions []Transaction
+	if err := db.Preload("Category").Preload("Splits.Category").Preload("Tags").Where("id IN ?", ids).Find(&transactions).Error; err != nil {
+		return nil, err
+	}
+	payeeNames, err := loadPayeeNames(db, transactions)
+	if err != nil {
+		return nil, err
+	}
+
+	// 検索結果の順に並べ、一致箇所を付ける
+	position := make(map[uint]int, len(hits))
+	for i, hit := range hits {
+		position[hit.ID] = i
+	}
+	sort.Slice(transactions, func(i, j int) bool {
+		return position[transactions[i].ID] < position[transactions[j].ID]
+	})
+	for i := range transactions {
+		result := &TransactionSearchResult{
+			Score:      hits[position[transactions[i].ID]].Score,
+			Highlights: make(map[string]string),
+		}
+		for field, text := range searchFields(transactions[i], payeeNames) {
+			if snippet, ok := highlightSearchTerms(text, terms); ok {
+				result.Highlights[field] = snippet
+			}
+		}
+		transactions[i].Search = result
+	}
+	return transactions, nil
+}
+
+// 抜粋の最大の長さ（文字数）
+const searchSnippetLength = 80
+
+// 検索語に一致した箇所を <mark> で囲んだ抜粋を返す（一致しなければ false）
+//
+// 全角・半角、大文字・小文字、空白・記号の違いは無視して探し、元の文字列の該当部分を囲む。
+func highlightSearchTerms(text string, terms []searchTerm) (string, bool) {
+	// 比較用の文字と、それぞれの元の文字列での位置
+	type span struct{ start, end int }
+	var compact []rune
+	var origin []span
+	for i := 0; i < len(text); {
+		n := norm.NFKC.NextBoundaryInString(text[i:], true)
+		if n <= 0 {
+			n = len(text) - i
+		}
+		for _, r := range strings.ToLower(norm.NFKC.String(text[i : i+n])) {
+			if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				compact = append(compact, r)
+				origin = append(origin, span{i, i + n})
+			}
+		}
+		i += n
+	}
+
+	var marks []span
+	for _, term := range terms {
+		// 区切りを除いて比べる（amazon.co.jp → amazoncojp）
+		needle := []rune(strings.ReplaceAll(normalizeSearchText(term.Text), " ", ""))
+		for i := 0; i+len(needle) <= len(compact); i++ {
+			if string(compact[i:i+len(needle)]) == string(needle) {
+				marks = append(marks, span{origin[i].start, origin[i+len(needle)-1].end})
+			}
+		}
+	}
+	if len(marks) == 0 {
+		return "", false
+	}
+
+	// 重なる箇所はまとめる
+	sort.Slice(marks, func(i, j int) bool { return marks[i].start < marks[j].start })
+	merged := marks[:1]
+	for _, m := range marks[1:] {
+		last := &merged[len(merged)-1]
+		if m.start <= last.end {
+			last.end = max(last.end, m.end)
+			continue
+		}
+		merged = append(merged, m)
+	}
+
+	// 長い場合は最初の一致箇所の前後を切り出す
+	from, to := 0, len(text)
+	if utf8.RuneCountInString(text) > searchSnippetLength {
+		from = merged[0].start
+		for n := 0; n < searchSnippetLength/4 && from > 0; n++ {
+			_, size := utf8.DecodeLastRuneInString(text[:from])
+			from -= size
+		}
+		to = from
+		for n := 0; n < searchSnippetLength && to < len(text); n++ {
+			_, size := utf8.DecodeRuneInString(text[to:])
+			to += size
+		}
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+	pos := from
+	for _, m := range merged {
+		if m.start >= to {
+			break
+		}
+		b.WriteString(html.EscapeString(text[pos:m.start]))
+		b.WriteString("<mark>" + html.EscapeString(text[m.start:min(m.end, to)]) + "</mark>")
+		pos = min(m.end, to)
+	}
+	b.WriteString(html.EscapeString(text[pos:to]))
+	if to < len(text) {
+		b.WriteString("…")
+	}
+	return b.String(), true
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSearchTokens(t *testing.T) {
+	tests := []struct {
+		text    string
+		want    []string
+		wantIdx []string // 索引用（forIndex）
+		sameAsQ bool     // 索引用も検索用と同じ
+	}{
+		{text: "セブンイレブン", want: []string{"セブ", "ブン", "ンイ", "イレ", "レブ", "ブン"}, wantIdx: []string{"セブ", "ブン", "ンイ", "イレ", "レブ", "ブン", "ン"}},
+		{text: "ｾﾌﾞﾝ 1234", want: []string{"セブ", "ブン", "1234"}, wantIdx: []string{"セブ", "ブン", "ン", "1234"}},
+		{text: "東京2026年", want: []string{"東京", "2026", "年"}, wantIdx: []string{"東京", "京", "2026", "年"}},
+		{text: "コーヒー", want: []string{"コー", "ーヒ", "ヒー"}, wantIdx: []string{"コー", "ーヒ", "ヒー", "ー"}},
+		{text: "Amazon.co.jp", want: []string{"amazon", "co", "jp"}, sameAsQ: true},
+		{text: "ＡＢＣ東", want: []string{"abc", "東"}, sameAsQ: true},
+		{text: "々", want: []string{"々"}, sameAsQ: true},
+		{text: "!!! ---", want: nil, sameAsQ: true},
+	}
+	for _, tt := range tests {
+		if got := searchTokens(tt.text, false); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("searchTokens(%q, false) = %q, want %q", tt.text, got, tt.want)
+		}
+		wantIdx := tt.wantIdx
+		if tt.sameAsQ {
+			wantIdx = tt.want
+		}
+		if got := searchTokens(tt.text, true); !reflect.DeepEqual(got, wantIdx) {
+			t.Errorf("searchTokens(%q, true) = %q, want %q", tt.text, got, wantIdx)
+		}
+	}
+}
+
+func TestParseSearchQuery(t *testing.T) {
+	tests := []struct {
+		q       string
+		want    []searchTerm
+		wantErr bool
+	}{
+		{q: "セブン 渋谷", want: []searchTerm{
+			{Text: "セブン", Tokens: []string{"セブ", "ブン"}},
+			{Text: "渋谷", Tokens: []string{"渋谷"}},
+		}},
+		// 英数字の単語と漢字・かな1文字は前方一致
+		{q: "amaz", want: []searchTerm{{Text: "amaz", Tokens: []string{"amaz"}, Prefix: true}}},
+		{q: "セ", want: []searchTerm{{Text: "セ", Tokens: []string{"セ"}, Prefix: true}}},
+		{q: "東京2026", want: []searchTerm{{Text: "東京2026", Tokens: []string{"東京", "2026"}, Prefix: true}}},
+		{q: "2026東京", want: []searchTerm{{Text: "2026東京", Tokens: []string{"2026", "東京"}}}},
+		{q: "ｾﾌﾞﾝ　AMAZON", want: []searchTerm{
+			{Text: "セブン", Tokens: []string{"セブ", "ブン"}},
+			{Text: "AMAZON", Tokens: []string{"amazon"}, Prefix: true},
+		}},
+		{q: "!!! セ ?", want: []searchTerm{{Text: "セ", Tokens: []string{"セ"}, Prefix: true}}},
+		{q: "!!! ?", wantErr: true},
+		{q: "", wantErr: true},
+		{q: strings.Repeat("a ", maxSearchTerms+1), wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseSearchQuery(tt.q)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseSearchQuery(%q) = %+v, want error", tt.q, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseSearchQuery(%q) returned error: %v", tt.q, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseSearchQuery(%q) = %+v, want %+v", tt.q, got, tt.want)
+		}
+	}
+
+	if got, err := parseSearchQuery(strings.Repeat("a ", maxSearchTerms)); err != nil || len(got) != maxSearchTerms {
+		t.Errorf("parseSearchQuery with %d words = %d terms, %v", maxSearchTerms, len(got), err)
+	}
+	if _, err := parseSearchQuery("- / ."); !errors.Is(err, errInvalidSearchQuery) {
+		t.Errorf("parseSearchQuery without letters returned %v, want errInvalidSearchQuery", err)
+	}
+}
+
+func TestHighlightSearchTerms(t *testing.T) {
+	tests := []struct {
+		text   string
+		q      string
+		want   string
+		wantOK bool
+	}{
+		{text: "セブン-イレブン 渋谷店", q: "イレブン", want: "セブン-<mark>イレブン</mark> 渋谷店", wantOK: true},
+		{text: "セブン-イレブン 渋谷店", q: "渋谷 セブン", want: "<mark>セブン</mark>-イレブン <mark>渋谷</mark>店", wantOK: true},
+		{text: "AMAZON.CO.JP", q: "amazon.co.jp", want: "<mark>AMAZON.CO.JP</mark>", wantOK: true},
+		{text: "ＡＭＡＺＯＮ マーケット", q: "amazon", want: "<mark>ＡＭＡＺＯＮ</mark> マーケット", wantOK: true},
+		{text: "ｾﾌﾞﾝｲﾚﾌﾞﾝ", q: "セブン", want: "<mark>ｾﾌﾞﾝ</mark>ｲﾚﾌﾞﾝ", wantOK: true},
+		{text: "A&B <shop>", q: "shop", want: "A&amp;B &lt;<mark>shop</mark>&gt;", wantOK: true},
+		{text: "ローソン", q: "セブン", want: "", wantOK: false},
+
+		// 長い場合は最初の一致箇所の20文字前から80文字を切り出す（マルチバイト文字の途中では切らない）
+		{
+			text:   strings.Repeat("あ", 100) + "セブン" + strings.Repeat("い", 100),
+			q:      "セブン",
+			want:   "…" + strings.Repeat("あ", 20) + "<mark>セブン</mark>" + strings.Repeat("い", 57) + "…",
+			wantOK: true,
+		},
+		{
+			text:   strings.Repeat("あ", 77) + "セブンイレブン" + strings.Repeat("い", 3),
+			q:      "セブンイレブン",
+			want:   "…" + strings.Repeat("あ", 20) + "<mark>セブンイレブン</mark>いいい",
+			wantOK: true,
+		},
+		// 抜粋の外の一致箇所は含めない
+		{
+			text:   "セブン" + strings.Repeat("あ", 100) + "セブン",
+			q:      "セブン",
+			want:   "<mark>セブン</mark>" + strings.Repeat("あ", 77) + "…",
+			wantOK: true,
+		},
+		// 抜粋の終わりにかかる一致箇所は途中まで囲む
+		{
+			text:   "セブン" + strings.Repeat("あ", 75) + "ローソン" + strings.Repeat("い", 10),
+			q:      "セブン ローソン",
+			want:   "<mark>セブン</mark>" + strings.Repeat("あ", 75) + "<mark>ロー</mark>…",
+			wantOK: true,
+		},
+	}
+	for _, tt := range tests {
+		terms, err := parseSearchQuery(tt.q)
+		if err != nil {
+			t.Fatalf("parseSearchQuery(%q) returned error: %v", tt.q, err)
+		}
+		got, ok := highlightSearchTerms(tt.text, terms)
+		if ok != tt.wantOK || got != tt.want {
+			t.Errorf("highlightSearchTerms(%q, %q) = %q, %v, want %q, %v", tt.text, tt.q, got, ok, tt.want, tt.wantOK)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("highlightSearchTerms(%q, %q) returned invalid UTF-8: %q", tt.text, tt.q, got)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 // パスワードハッシュ化（seed用）
@@ -28,16 +29,12 @@ func seedData() {
 		// テストユーザー用のデフォルトカテゴリ作成
 		createDefaultCategories(testUser.ID)
 	}
-	
-	// 既存のすべてのユーザーに対して不足しているカテゴリを追加
-	ensureAllUsersHaveDefaultCategories()
-	
-	// 既存のカテゴリのアイコンを絵文字から商用アイコンに更新
-	updateEmojiIconsToCommercialIcons()
+
+	// 既存データの更新（アイコン変換・不足カテゴリの追加）はマイグレーションで一度だけ実行する
 }
 
-// 既存のカテゴリのアイコンを絵文字から商用アイコンに更新
-func updateEmojiIconsToCommercialIcons() {
+// 既存のカテゴリのアイコンを絵文字から商用アイコンに更新（データマイグレーション）
+func updateEmojiIconsToCommercialIcons(tx *gorm.DB) error {
 	// 絵文字から商用アイコンへのマッピング
 	iconMapping := map[string]string{
 		"💼": "briefcase",
@@ -64,7 +61,10 @@ func updateEmojiIconsToCommercialIcons() {
 	
 	// 各絵文字アイコンを商用アイコンに更新
 	for emojiIcon, commercialIcon := range iconMapping {
-		db.Model(&Category{}).Where("icon = ?", emojiIcon).Update("icon", commercialIcon)
+		if err := tx.Model(&Category{}).Where("icon = ?", emojiIcon).Update("icon", commercialIcon).Error; err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
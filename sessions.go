@@ -0,0 +1,226 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// アクセストークン・リフレッシュトークンの有効期間
+var (
+	accessTokenTTL  = getEnvDuration("ACCESS_TOKEN_TTL", 15*time.Minute)
+	refreshTokenTTL = getEnvDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+)
+
+var (
+	errInvalidRefreshToken = errors.New("invalid refresh token")
+	errRefreshTokenReused  = errors.New("refresh token reused")
+)
+
+// トークンのハッシュ（DBにはハッシュのみ保存する）
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// 推測不可能なトークンを生成（リフレッシュトークン・メール用トークン）
+func newRandomToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// セッションに新しいリフレッシュトークンを発行
+func createRefreshToken(tx *gorm.DB, sessionID uint, now time.Time) (string, error) {
+	token, err := newRandomToken()
+	if err != nil {
+		return "", err
+	}
+
+	record := RefreshToken{
+		SessionID: sessionID,
+		TokenHash: hashToken(token),
+		ExpiresAt: now.Add(refreshTokenTTL),
+	}
+	if err := tx.Create(&record).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ログイン・登録時に新しいセッションを作成し、トークン一式を返す
+func issueTokens(c *gin.Context, user User) (AuthResponse, error) {
+	now := time.Now()
+	session := Session{
+		UserID:     user.ID,
+		UserAgent:  c.Request.UserAgent(),
+		IPAddress:  c.ClientIP(),
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(refreshTokenTTL),
+	}
+
+	var refreshToken string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&session).Error; err != nil {
+			return err
+		}
+		var err error
+		refreshToken, err = createRefreshToken(tx, session.ID, now)
+		return err
+	})
+	if err != nil {
+		return AuthResponse{}, err
+	}
+
+	token, err := generateToken(user.ID, user.Email, session.ID)
+	if err != nil {
+		return AuthResponse{}, err
+	}
+
+	return AuthResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(accessTokenTTL / time.Second),
+		User:         user,
+	}, nil
+}
+
+// リフレッシュトークンをローテーションする
+//
+// 使用済みのトークンが再度提示された場合は漏洩とみなし、セッション（トークンファミリー）ごと失効させる。
+func rotateRefreshToken(token string) (Session, string, error) {
+	var session Session
+	var newToken string
+	now := time.Now()
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var record RefreshToken
+		if err := tx.Where("token_hash = ?", hashToken(token)).First(&record).Error; err != nil {
+			return errInvalidRefreshToken
+		}
+		if err := tx.First(&session, record.SessionID).Error; err != nil {
+			return errInvalidRefreshToken
+		}
+		if session.RevokedAt != nil || now.After(record.ExpiresAt) {
+			return errInvalidRefreshToken
+		}
+
+		// 使用済みにする（同時に使われた場合も片方だけが成功する）
+		result := tx.Model(&RefreshToken{}).Where("id = ? AND used_at IS NULL", record.ID).Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errRefreshTokenReused
+		}
+
+		session.LastUsedAt = now
+		session.ExpiresAt = now.Add(refreshTokenTTL)
+		if err := tx.Model(&session).Updates(map[string]interface{}{
+			"last_used_at": session.LastUsedAt,
+			"expires_at":   session.ExpiresAt,
+		}).Error; err != nil {
+			return err
+		}
+
+		var err error
+		newToken, err = createRefreshToken(tx, session.ID, now)
+		return err
+	})
+
+	if errors.Is(err, errRefreshTokenReused) {
+		log.Printf("[AUTH] Refresh token reuse detected, revoking session %d (user %d)", session.ID, session.UserID)
+		if revokeErr := revokeSession(session.ID, "reuse_detected"); revokeErr != nil {
+			log.Printf("[AUTH] ERROR: Failed to revoke session %d: %v", session.ID, revokeErr)
+		}
+	}
+	return session, newToken, err
+}
+
+// セッションを失効させる（アクセストークンも以後は拒否される）
+func revokeSession(sessionID uint, reason string) error {
+	return db.Model(&Session{}).Where("id = ? AND revoked_at IS NULL", sessionID).
+		Updates(map[string]interface{}{"revoked_at": time.Now(), "revoked_reason": reason}).Error
+}
+
+// セッションが有効か（失効・期限切れでない）
+func isSessionActive(sessionID uint) bool {
+	var session Session
+	if err := db.Select("id, revoked_at, expires_at").First(&session, sessionID).Error; err != nil {
+		return false
+	}
+	return session.RevokedAt == nil && time.Now().Before(session.ExpiresAt)
+}
+
+// トークン更新
+func refreshTokens(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	session, refreshToken, err := rotateRefreshToken(req.RefreshToken)
+	if err != nil {
+		if errors.Is(err, errInvalidRefreshToken) || errors.Is(err, errRefreshTokenReused) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "無効なリフレッシュトークンです"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "トークンの更新に失敗しました"})
+		return
+	}
+
+	var user User
+	if err := db.First(&user, session.UserID).Error; err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "ユーザーが見つかりません"})
+		return
+	}
+
+	token, err := generateToken(user.ID, user.Email, session.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "トークンの生成に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, AuthResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int64(accessTokenTTL / time.Second),
+		User:         user,
+	})
+}
+
+// 期限切れのリフレッシュトークンと、失効・期限切れから一定期間経ったセッションを削除
+func purgeExpiredSessions() {
+	cutoff := time.Now().Add(-refreshTokenTTL)
+
+	result := db.Where("expires_at < ?", time.Now()).Delete(&RefreshToken{})
+	if result.Error != nil {
+		log.Printf("[BATCH] ERROR: Failed to purge refresh tokens: %v", result.Error)
+		return
+	}
+
+	sessions := db.Where("expires_at < ? OR revoked_at < ?", cutoff, cutoff).Delete(&Session{})
+	if sessions.Error != nil {
+		log.Printf("[BATCH] ERROR: Failed to purge sessions: %v", sessions.Error)
+		return
+	}
+
+	log.Printf("[BATCH] Purged %d refresh token(s) and %d session(s)", result.RowsAffected, sessions.RowsAffected)
+}
+
+// ユーザーの全セッションを失効させる（パスワード変更時など）
+func revokeUserSessions(tx *gorm.DB, userID uint, reason string) error {
+	return tx.Model(&Session{}).Where("user_id = ? AND revoked_at IS NULL", userID).
+		Updates(map[string]interface{}{"revoked_at": time.Now(), "revoked_reason": reason}).Error
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"gorm.io/gorm"
+)
+
+// 内訳のリクエストを検証して取引に反映する
+//
+// 内訳は2件以上で、金額の合計が取引の金額と一致し、カテゴリは取引と同じ種類である必要がある。
+// 取引のカテゴリは最も金額の大きい内訳のカテゴリにする（内訳に対応していない画面・集計のため）。
+func applyTransactionSplits(transaction *Transaction, splits []TransactionSplitRequest) (int, error) {
+	transaction.Splits = nil
+	if len(splits) == 0 {
+		return 0, nil
+	}
+	if transaction.Type == "transfer" {
+		return http.StatusBadRequest, fmt.Errorf("Transfers cannot be split")
+	}
+	if len(splits) < 2 {
+		return http.StatusBadRequest, fmt.Errorf("splits must have at least 2 lines")
+	}
+
+	categoryIDs := make([]uint, 0, len(splits))
+	for _, split := range splits {
+		categoryIDs = append(categoryIDs, split.CategoryID)
+	}
+	var categories []Category
+	if err := db.Where("user_id = ? AND id IN ?", transaction.UserID, categoryIDs).Find(&categories).Error; err != nil {
+		return http.StatusInternalServerError, err
+	}
+	categoryTypes := make(map[uint]string, len(categories))
+	for _, category := range categories {
+		categoryTypes[category.ID] = category.Type
+	}
+
+	var total Money
+	var largest Money
+	for i, split := range splits {
+		categoryType, ok := categoryTypes[split.CategoryID]
+		if !ok {
+			return http.StatusBadRequest, fmt.Errorf("splits[%d]: category %d not found", i, split.CategoryID)
+		}
+		if categoryType != transaction.Type {
+			return http.StatusBadRequest, fmt.Errorf("splits[%d]: category %d is not an %s category", i, split.CategoryID, transaction.Type)
+		}
+		if (split.Amount > 0) != (transaction.Amount > 0) {
+			return http.StatusBadRequest, fmt.Errorf("splits[%d]: amount must have the same sign as the transaction amount", i)
+		}
+		if err := checkCurrencyUnit(fmt.Sprintf("splits[%d]: amount", i), split.Amount, transaction.Currency); err != nil {
+			return http.StatusBadRequest, err
+		}
+
+		total += split.Amount
+		size := split.Amount
+		if size < 0 {
+			size = -size
+		}
+		if size > largest {
+			largest = size
+			transaction.CategoryID = split.CategoryID
+		}
+		transaction.Splits = append(transaction.Splits, TransactionSplit{
+			UserID:     transaction.UserID,
+			CategoryID: split.CategoryID,
+			Amount:     split.Amount,
+			Memo:       split.Memo,
+		})
+	}
+	if total != transaction.Amount {
+		return http.StatusBadRequest, fmt.Errorf("Sum of splits (%s) must equal the transaction amount (%s)", total, transaction.Amount)
+	}
+	return 0, nil
+}
+
+// 取引と内訳・タグを保存する（内訳・タグは置き換える）
+func saveTransaction(transaction *Transaction) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Splits", "Tags").Save(transaction).Error; err != nil {
+			return err
+		}
+		if err := replaceTransactionTags(tx, transaction); err != nil {
+			return err
+		}
+		if err := tx.Where("transaction_id = ?", transaction.ID).Delete(&TransactionSplit{}).Error; err != nil {
+			return err
+		}
+		if len(transaction.Splits) > 0 {
+			for i := range transaction.Splits {
+				transaction.Splits[i].ID = 0
+				transaction.Splits[i].TransactionID = transaction.ID
+				transaction.Splits[i].UserID = transaction.UserID
+			}
+			if err := tx.Omit("Category").Create(&transaction.Splits).Error; err != nil {
+				return err
+			}
+		}
+		_, err := indexTransactions(tx, []uint{transaction.ID})
+		return err
+	})
+}
+
+// 取引に付随するデータ（内訳・タグ・添付ファイル・検索の索引）を削除する
+//
+// transactionIDs は取引IDの一覧またはサブクエリ。削除した添付ファイルを返すので、
+// コミット後に removeAttachmentObjects で保存先のファイルを削除すること。
+func deleteTransactionDetails(tx *gorm.DB, transactionIDs interface{}) ([]Attachment, error) {
+	if err := tx.Where("transaction_id IN (?)", transactionIDs).Delete(&TransactionSplit{}).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Where("transaction_id IN (?)", transactionIDs).Delete(&TransactionTag{}).Error; err != nil {
+		return nil, err
+	}
+	if err := removeFromSearchIndex(tx, transactionIDs); err != nil {
+		return nil, err
+	}
+	return deleteAttachmentRecords(tx, transactionIDs)
+}
+
+// カテゴリ別に集計するための明細行
+//
+// 内訳のある取引は内訳ごとに1行、それ以外の取引は1行になる。
+// 列は transactions と同じ名前（user_id, type, date, currency, category_id, amount）。
+func categoryLines() *gorm.DB {
+	lines := db.Table("transactions AS t").
+		Select("t.user_id, t.type, t.date, t.currency, COALESCE(s.category_id, t.category_id) AS category_id, COALESCE(s.amount, t.amount) AS amount").
+		Joins("LEFT JOIN transaction_splits s ON s.transaction_id = t.id")
+	return db.Table("(?) AS category_lines", lines)
+}
@@ -0,0 +1,517 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 明細ファイルの1件（OFX・QIF共通）
+type statementEntry struct {
+	Line       int
+	AccountRef string // OFXの口座番号（ACCTID）またはQIFの口座名
+	Currency   string
+	Date       time.Time
+	Amount     Money // 符号付き（マイナスが出金）
+	Payee      string
+	Memo       string
+	Category   string // QIFのみ（親:子）
+	FITID      string // OFXの取引ID（口座内で一意）
+	Transfer   bool   // OFXの TRNTYPE XFER（振込を含む）またはQIFの L[口座名]
+	Errors     []string
+}
+
+// ファイルの形式を判定する（ofx, qif）
+func detectStatementFormat(fileName string, data []byte) string {
+	lower := strings.ToLower(fileName)
+	switch {
+	case strings.HasSuffix(lower, ".ofx"), strings.HasSuffix(lower, ".qfx"):
+		return "ofx"
+	case strings.HasSuffix(lower, ".qif"):
+		return "qif"
+	}
+
+	head := bytes.ToUpper(bytes.TrimSpace(data[:min(len(data), 1024)]))
+	switch {
+	case bytes.HasPrefix(head, []byte("OFXHEADER")), bytes.Contains(head, []byte("<OFX")):
+		return "ofx"
+	case bytes.HasPrefix(head, []byte("!TYPE")), bytes.HasPrefix(head, []byte("!ACCOUNT")), bytes.HasPrefix(head, []byte("!OPTION")):
+		return "qif"
+	}
+	return ""
+}
+
+// OFX（QFXを含む）を読み込む
+//
+// 1.x（SGML・終了タグの省略あり）と 2.x（XML）のどちらも、タグと値の並びとして読む。
+func parseOFX(data []byte) ([]statementEntry, error) {
+	text := string(data)
+	start := strings.Index(strings.ToUpper(text), "<OFX>")
+	if start < 0 {
+		return nil, errors.New("OFX body not found")
+	}
+
+	var entries []statementEntry
+	var current *statementEntry
+	accountRef, currency := "", ""
+
+	for pos := start; pos < len(text); {
+		open := strings.IndexByte(text[pos:], '<')
+		if open < 0 {
+			break
+		}
+		open += pos
+		end := strings.IndexByte(text[open:], '>')
+		if end < 0 {
+			break
+		}
+		end += open
+		tag := strings.ToUpper(strings.TrimSpace(text[open+1 : end]))
+
+		// 値は次のタグまで
+		next := strings.IndexByte(text[end+1:], '<')
+		if next < 0 {
+			next = len(text) - end - 1
+		}
+		value := html.UnescapeString(strings.TrimSpace(text[end+1 : end+1+next]))
+		pos = end + 1
+
+		// SGMLでは </STMTTRN> が省略されるため、次の取引や一覧の終わりでも区切る
+		switch tag {
+		case "STMTTRN", "/STMTTRN", "/BANKTRANLIST":
+			if current != nil {
+				entries = append(entries, *current)
+				current = nil
+			}
+			if tag == "STMTTRN" {
+				line := strings.Count(text[:open], "\n") + 1
+				current = &statementEntry{Line: line, AccountRef: accountRef, Currency: currency}
+			}
+			continue
+		}
+		if value == "" || strings.HasPrefix(tag, "/") {
+			continue
+		}
+
+		if current == nil {
+			switch tag {
+			case "ACCTID":
+				accountRef = value
+			case "CURDEF":
+				currency = value
+			}
+			continue
+		}
+
+		switch tag {
+		case "DTPOSTED":
+			date, err := parseOFXDate(value)
+			if err != nil {
+				current.Errors = append(current.Errors, "invalid DTPOSTED: "+value)
+			}
+			current.Date = date
+		case "TRNAMT":
+			amount, err := parseOFXAmount(value)
+			if err != nil {
+				current.Errors = append(current.Errors, "invalid TRNAMT: "+value)
+			}
+			current.Amount = amount
+		case "FITID":
+			current.FITID = value
+		case "NAME":
+			current.Payee = value
+		case "MEMO":
+			current.Memo = value
+		case "TRNTYPE":
+			current.Transfer = value == "XFER"
+		case "CURSYM":
+			// 外貨建ての取引（<CURRENCY> または <ORIGCURRENCY> の中）
+			current.Currency = value
+		}
+	}
+
+	// 終了タグが省略された最後の取引
+	if current != nil {
+		entries = append(entries, *current)
+	}
+	return entries, nil
+}
+
+// OFXの日時（YYYYMMDD[HHMMSS[.XXX]][[+9:JST]]）の日付部分
+func parseOFXDate(value string) (time.Time, error) {
+	if len(value) < 8 {
+		return time.Time{}, fmt.Errorf("invalid date: %s", value)
+	}
+	return time.Parse("20060102", value[:8])
+}
+
+// OFXの金額（小数点にカンマを使う銀行もある）
+func parseOFXAmount(value string) (Money, error) {
+	if strings.Contains(value, ",") && !strings.Contains(value, ".") {
+		value = strings.Replace(value, ",", ".", 1)
+	}
+	return parseMoney(value)
+}
+
+// QIFの日付の既定の形式（アメリカ式）
+var qifDateLayouts = []string{"1/2/2006", "1/2/06", "2006-01-02", "2006/01/02"}
+
+// QIFを読み込む
+//
+// dateFormat は YYYY/MM/DD 形式の指定。空の場合は MM/DD/YYYY などを順に試す。
+func parseQIF(data []byte, dateFormat string) ([]statementEntry, error) {
+	var entries []statementEntry
+	current := statementEntry{}
+	accountRef, header := "", ""
+	inAccountBlock, supported := false, false
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimRight(scanner.Text(), "\r ")
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "!") {
+			header = strings.ToLower(line)
+			inAccountBlock = header == "!account"
+			if strings.HasPrefix(header, "!type:") {
+				// 投資口座・カテゴリ一覧などは取引として取り込まない
+				switch strings.TrimSpace(strings.TrimPrefix(header, "!type:")) {
+				case "bank", "ccard", "cash", "oth a", "oth l":
+					supported = true
+				default:
+					supported = false
+				}
+			}
+			continue
+		}
+
+		code, value := line[0], strings.TrimSpace(line[1:])
+		if inAccountBlock {
+			switch code {
+			case 'N':
+				accountRef = value
+			case '^':
+				inAccountBlock = false
+			}
+			continue
+		}
+		if !supported {
+			continue
+		}
+
+		if current.Line == 0 {
+			current = statementEntry{Line: lineNumber, AccountRef: accountRef}
+		}
+		switch code {
+		case 'D':
+			date, err := parseQIFDate(value, dateFormat)
+			if err != nil {
+				current.Errors = append(current.Errors, "invalid date: "+value)
+			}
+			current.Date = date
+		case 'T', 'U':
+			amount, err := parseStatementAmount(value)
+			if err != nil {
+				current.Errors = append(current.Errors, "invalid amount: "+value)
+			}
+			current.Amount = amount
+		case 'P':
+			current.Payee = value
+		case 'M':
+			current.Memo = value
+		case 'L':
+			// [口座名] は口座間の振替
+			if strings.HasPrefix(value, "[") {
+				current.Transfer = true
+			} else {
+				current.Category = value
+			}
+		case '^':
+			entries = append(entries, current)
+			current = statementEntry{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if current.Line != 0 {
+		entries = append(entries, current)
+	}
+	if header == "" {
+		return nil, errors.New("QIF header (!Type:...) not found")
+	}
+	return entries, nil
+}
+
+// QIFの日付（1/ 2'98 のような表記も扱う）
+func parseQIFDate(value, dateFormat string) (time.Time, error) {
+	value = strings.ReplaceAll(strings.ReplaceAll(value, " ", ""), "'", "/")
+	if dateFormat != "" {
+		return time.Parse(dateLayout(dateFormat), value)
+	}
+	for _, layout := range qifDateLayouts {
+		if date, err := time.Parse(layout, value); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date: %q", value)
+}
+
+// 明細の口座を決める
+//
+// 指定された口座、口座番号（末尾一致）または口座名が一致する口座、デフォルト口座の順に使う。
+type statementAccountMatcher struct {
+	accounts []Account
+	fallback Account
+	fixed    bool
+}
+
+func newStatementAccountMatcher(userID, accountID uint) (*statementAccountMatcher, error) {
+	fallback, err := resolveAccount(userID, accountID)
+	if err != nil {
+		return nil, errors.New("Account not found")
+	}
+
+	m := &statementAccountMatcher{fallback: fallback, fixed: accountID != 0}
+	if err := db.Where("user_id = ?", userID).Order("id").Find(&m.accounts).Error; err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *statementAccountMatcher) match(ref string) Account {
+	ref = strings.TrimSpace(ref)
+	if m.fixed || ref == "" {
+		return m.fallback
+	}
+	for _, account := range m.accounts {
+		// 口座番号は下4桁だけ登録されていてもよい
+		if account.StatementRef != "" && len(account.StatementRef) >= 4 && strings.HasSuffix(ref, account.StatementRef) {
+			return account
+		}
+		if account.StatementRef == ref || strings.EqualFold(account.Name, ref) {
+			return account
+		}
+	}
+	return m.fallback
+}
+
+// 明細を取込行に変換する
+//
+// 振替の明細も既定では収入・支出として取り込む（銀行は通常の振込も XFER で出力するため、除くと残高がずれる）。
+// skipTransfers が true の場合は、振替先の口座の明細も取り込む場合のために除外する。
+func statementRows(userID uint, format string, entries []statementEntry, matcher *statementAccountMatcher, skipTransfers bool) ([]CSVImportRow, error) {
+	resolver, err := newPresetCategoryResolver(userID)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := newCategoryRuleEngine(userID)
+	if err != nil {
+		return nil, err
+	}
+	payees, err := newPayeeMatcher(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []CSVImportRow
+	occurrences := make(map[string]int)
+	for _, entry := range entries {
+		row := CSVImportRow{Line: entry.Line, Errors: entry.Errors}
+		if entry.Transfer && skipTransfers {
+			row.Skipped = "transfer"
+			rows = append(rows, row)
+			continue
+		}
+
+		account := matcher.match(entry.AccountRef)
+		if entry.Currency != "" && !strings.EqualFold(entry.Currency, account.Currency) {
+			row.Errors = append(row.Errors, fmt.Sprintf("currency %s does not match account currency %s", entry.Currency, account.Currency))
+		}
+		if entry.Amount == 0 && len(entry.Errors) == 0 {
+			row.Errors = append(row.Errors, "amount must not be zero")
+		}
+		if err := checkCurrencyUnit("amount", entry.Amount, account.Currency); err != nil {
+			row.Errors = append(row.Errors, err.Error())
+		}
+
+		transaction := Transaction{
+			UserID:      userID,
+			Type:        "income",
+			Amount:      entry.Amount,
+			Currency:    account.Currency,
+			AccountID:   account.ID,
+			Description: joinNonEmpty(" / ", entry.Payee, entry.Memo),
+			Date:        entry.Date,
+			ExternalID:  statementExternalID(format, account, entry, occurrences),
+		}
+		if transaction.Amount < 0 {
+			transaction.Type = "expense"
+			transaction.Amount = -transaction.Amount
+		}
+
+		payee := payees.apply(&transaction)
+		if rule := rules.apply(&transaction); rule != nil {
+			row.RuleID = &rule.ID
+		} else if category, ok := payeeDefaultCategory(payee, transaction.Type); ok && entry.Category == "" {
+			// 取込元にカテゴリがなければ支払先の既定カテゴリを使う
+			transaction.CategoryID = category.ID
+			transaction.Category = category
+		} else {
+			major, minor, _ := strings.Cut(entry.Category, ":")
+			category, isNew := resolver.resolve(major, minor, transaction.Type)
+			category.UserID = userID
+			transaction.CategoryID = category.ID
+			transaction.Category = category
+			row.NewCategory = isNew
+		}
+
+		row.Transaction = &transaction
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// 再取込で重複しないための識別子
+//
+// OFXは口座番号とFITID、FITIDのないQIFは内容のハッシュ（同じ内容は出現順で区別）を使う。
+func statementExternalID(format string, account Account, entry statementEntry, occurrences map[string]int) string {
+	var key string
+	if entry.FITID != "" {
+		key = entry.AccountRef + "\x1f" + entry.FITID
+	} else {
+		content := fmt.Sprintf("%d\x1f%s\x1f%d\x1f%s\x1f%s\x1f%s", account.ID, entry.Date.Format("2006-01-02"), entry.Amount, entry.Payee, entry.Memo, entry.Category)
+		occurrences[content]++
+		key = content + "\x1f" + strconv.Itoa(occurrences[content])
+	}
+	sum := sha256.Sum256([]byte(key))
+	return format + ":" + hex.EncodeToString(sum[:20])
+}
+
+// OFX・QIF明細の取込
+//
+// 不正な行だけを除いて登録し、登録・重複・除外・不正の件数を返す。dryRun=true の場合は登録しない。
+// skipTransfers=true の場合は振替の明細を除外する。
+func importStatement(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxCSVImportSize)
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Statement file is required (field: file)"})
+		return
+	}
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read uploaded file: " + err.Error()})
+		return
+	}
+	defer src.Close()
+
+	data, err := io.ReadAll(src)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read uploaded file: " + err.Error()})
+		return
+	}
+	if data, err = decodeStatement(data, "auto"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	format := c.PostForm("format")
+	if format == "" {
+		format = detectStatementFormat(file.Filename, data)
+	}
+
+	var entries []statementEntry
+	switch format {
+	case "ofx", "qfx":
+		format = "ofx"
+		entries, err = parseOFX(data)
+	case "qif":
+		entries, err = parseQIF(data, c.PostForm("dateFormat"))
+	default:
+		err = errors.New("unknown statement format; expected OFX, QFX or QIF")
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	accountID, _ := strconv.ParseUint(c.PostForm("accountId"), 10, 64)
+	matcher, err := newStatementAccountMatcher(userID.(uint), uint(accountID))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rows, err := statementRows(userID.(uint), format, entries, matcher, c.PostForm("skipTransfers") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read statement: " + err.Error()})
+		return
+	}
+	if err := markDuplicateRows(userID.(uint), rows); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check duplicates: " + err.Error()})
+		return
+	}
+
+	rejected, duplicates, possibleDuplicates := []CSVImportRow{}, []CSVImportRow{}, []CSVImportRow{}
+	skipped, pending := 0, 0
+	for _, row := range rows {
+		switch {
+		case len(row.Errors) > 0:
+			rejected = append(rejected, row)
+		case row.Skipped != "":
+			skipped++
+		case row.DuplicateOf != nil:
+			duplicates = append(duplicates, row)
+		default:
+			pending++
+			if len(row.PossibleDuplicates) > 0 {
+				possibleDuplicates = append(possibleDuplicates, row)
+			}
+		}
+	}
+	summary := gin.H{
+		"format":                format,
+		"rejected":              len(rejected),
+		"duplicates":            len(duplicates),
+		"skipped":               skipped,
+		"possibleDuplicates":    len(possibleDuplicates),
+		"rejectedRows":          rejected,
+		"duplicateRows":         duplicates,
+		"possibleDuplicateRows": possibleDuplicates,
+	}
+
+	if c.PostForm("dryRun") == "true" {
+		summary["dryRun"] = true
+		summary["created"] = pending
+		c.JSON(http.StatusOK, summary)
+		return
+	}
+
+	created, createdCategories, err := saveImportRows(rows)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import transactions: " + err.Error()})
+		return
+	}
+	summary["created"] = created
+	summary["createdCategories"] = createdCategories
+	c.JSON(http.StatusOK, summary)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func ymd(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestParseOFX(t *testing.T) {
+	// 1.x（SGML）: 終了タグが省略され、最後の取引も </STMTTRN> なしで一覧が終わる
+	sgml := `OFXHEADER:100
+DATA:OFXSGML
+VERSION:102
+
+<OFX>
+<BANKMSGSRSV1><STMTTRNRS><STMTRS>
+<CURDEF>JPY
+<BANKACCTFROM><BANKID>0001<ACCTID>1234567<ACCTTYPE>CHECKING</BANKACCTFROM>
+<BANKTRANLIST>
+<DTSTART>20261001
+<STMTTRN>
+<TRNTYPE>DEBIT
+<DTPOSTED>20261005120000.000[+9:JST]
+<TRNAMT>-1500
+<FITID>A001
+<NAME>セブン&amp;アイ
+<MEMO>カード払い
+<STMTTRN>
+<TRNTYPE>XFER
+<DTPOSTED>20261006
+<TRNAMT>-50000
+<FITID>A002
+<NAME>振込 ヤマダ
+</BANKTRANLIST>
+</STMTRS></STMTTRNRS></BANKMSGSRSV1>
+</OFX>
+`
+	// 2.x（XML）: 小数点にカンマを使う銀行、外貨建ての取引、不正な値
+	xml := `<?xml version="1.0" encoding="UTF-8"?>
+<?OFX OFXHEADER="200" VERSION="211"?>
+<OFX><CREDITCARDMSGSRSV1><CCSTMTTRNRS><CCSTMTRS>
+<CURDEF>EUR</CURDEF>
+<CCACCTFROM><ACCTID>4111</ACCTID></CCACCTFROM>
+<BANKTRANLIST>
+<STMTTRN><TRNTYPE>DEBIT</TRNTYPE><DTPOSTED>20261007</DTPOSTED><TRNAMT>-12,34</TRNAMT><FITID>B1</FITID><NAME>Café</NAME></STMTTRN>
+<STMTTRN><TRNTYPE>DEBIT</TRNTYPE><DTPOSTED>20261008</DTPOSTED><TRNAMT>-20.00</TRNAMT><FITID>B2</FITID><NAME>Shop</NAME><ORIGCURRENCY><CURRATE>0.9</CURRATE><CURSYM>USD</CURSYM></ORIGCURRENCY></STMTTRN>
+<STMTTRN><TRNTYPE>CREDIT</TRNTYPE><DTPOSTED>2026</DTPOSTED><TRNAMT>1.005</TRNAMT><FITID>B3</FITID></STMTTRN>
+</BANKTRANLIST>
+</CCSTMTRS></CCSTMTTRNRS></CREDITCARDMSGSRSV1></OFX>
+`
+
+	tests := []struct {
+		name string
+		data string
+		want []statementEntry
+	}{
+		{
+			name: "sgml",
+			data: sgml,
+			want: []statementEntry{
+				{Line: 11, AccountRef: "1234567", Currency: "JPY", Date: ymd(2026, 10, 5), Amount: -150000, Payee: "セブン&アイ", Memo: "カード払い", FITID: "A001"},
+				{Line: 18, AccountRef: "1234567", Currency: "JPY", Date: ymd(2026, 10, 6), Amount: -5000000, Payee: "振込 ヤマダ", FITID: "A002", Transfer: true},
+			},
+		},
+		{
+			name: "xml",
+			data: xml,
+			want: []statementEntry{
+				{Line: 7, AccountRef: "4111", Currency: "EUR", Date: ymd(2026, 10, 7), Amount: -1234, Payee: "Café", FITID: "B1"},
+				{Line: 8, AccountRef: "4111", Currency: "USD", Date: ymd(2026, 10, 8), Amount: -2000, Payee: "Shop", FITID: "B2"},
+				{Line: 9, AccountRef: "4111", Currency: "EUR", FITID: "B3", Errors: []string{"invalid DTPOSTED: 2026", "invalid TRNAMT: 1.005"}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		entries, err := parseOFX([]byte(tt.data))
+		if err != nil {
+			t.Errorf("%s: parseOFX returned error: %v", tt.name, err)
+			continue
+		}
+		checkStatementEntries(t, tt.name, entries, tt.want)
+	}
+
+	if _, err := parseOFX([]byte("OFXHEADER:100\n<SIGNONMSGSRSV1>")); err == nil {
+		t.Error("parseOFX accepted a file without an OFX body")
+	}
+}
+
+func TestParseQIF(t *testing.T) {
+	data := "!Account\r\n" +
+		"NChecking\r\n" +
+		"TBank\r\n" +
+		"^\r\n" +
+		"!Type:Bank\r\n" +
+		"D10/15/2026\r\n" +
+		"T-1,234.56\r\n" +
+		"PAmazon\r\n" +
+		"MGift\r\n" +
+		"LShopping:Online\r\n" +
+		"^\r\n" +
+		"D10/16'26\r\n" +
+		"T500.00\r\n" +
+		"L[Savings]\r\n" +
+		"^\r\n" +
+		"!Type:Invst\r\n" +
+		"D10/17/2026\r\n" +
+		"NBuy\r\n" +
+		"YACME\r\n" +
+		"T-999.00\r\n" +
+		"^\r\n" +
+		"!Type:Cat\r\n" +
+		"NFood\r\n" +
+		"^\r\n" +
+		"!Type:CCard\r\n" +
+		"D13/45/2026\r\n" +
+		"U-20.00\r\n" +
+		"PNo terminator\r\n"
+
+	entries, err := parseQIF([]byte(data), "")
+	if err != nil {
+		t.Fatalf("parseQIF returned error: %v", err)
+	}
+	checkStatementEntries(t, "qif", entries, []statementEntry{
+		{Line: 6, AccountRef: "Checking", Date: ymd(2026, 10, 15), Amount: -123456, Payee: "Amazon", Memo: "Gift", Category: "Shopping:Online"},
+		{Line: 12, AccountRef: "Checking", Date: ymd(2026, 10, 16), Amount: 50000, Transfer: true},
+		{Line: 26, AccountRef: "Checking", Amount: -2000, Payee: "No terminator", Errors: []string{"invalid date: 13/45/2026"}},
+	})
+
+	if _, err := parseQIF([]byte("D10/15/2026\nT-1.00\n^\n"), ""); err == nil {
+		t.Error("parseQIF accepted a file without a header")
+	}
+}
+
+func TestParseQIFDate(t *testing.T) {
+	tests := []struct {
+		value      string
+		dateFormat string
+		want       time.Time
+		wantErr    bool
+	}{
+		{value: "10/15/2026", want: ymd(2026, 10, 15)},
+		{value: "1/2/2026", want: ymd(2026, 1, 2)},
+		{value: "1/ 2'98", want: ymd(1998, 1, 2)},
+		{value: "12/31'05", want: ymd(2005, 12, 31)},
+		{value: "2026-10-15", want: ymd(2026, 10, 15)},
+		{value: "2026/10/15", want: ymd(2026, 10, 15)},
+		{value: "15/10/2026", dateFormat: "DD/MM/YYYY", want: ymd(2026, 10, 15)},
+		{value: "15.10.26", dateFormat: "DD.MM.YY", want: ymd(2026, 10, 15)},
+		{value: "15/10/2026", wantErr: true},
+		{value: "10/15/2026", dateFormat: "DD/MM/YYYY", wantErr: true},
+		{value: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseQIFDate(tt.value, tt.dateFormat)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseQIFDate(%q, %q) = %v, want error", tt.value, tt.dateFormat, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseQIFDate(%q, %q) returned error: %v", tt.value, tt.dateFormat, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("parseQIFDate(%q, %q) = %v, want %v", tt.value, tt.dateFormat, got, tt.want)
+		}
+	}
+}
+
+func checkStatementEntries(t *testing.T, name string, got, want []statementEntry) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%s: got %d entries, want %d: %+v", name, len(got), len(want), got)
+		return
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.Line != w.Line || g.AccountRef != w.AccountRef || g.Currency != w.Currency || !g.Date.Equal(w.Date) ||
+			g.Amount != w.Amount || g.Payee != w.Payee || g.Memo != w.Memo || g.Category != w.Category ||
+			g.FITID != w.FITID || g.Transfer != w.Transfer || len(g.Errors) != len(w.Errors) {
+			t.Errorf("%s: entry %d = %+v, want %+v", name, i, g, w)
+			continue
+		}
+		for j := range w.Errors {
+			if g.Errors[j] != w.Errors[j] {
+				t.Errorf("%s: entry %d error %d = %q, want %q", name, i, j, g.Errors[j], w.Errors[j])
+			}
+		}
+	}
+}
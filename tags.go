@@ -0,0 +1,341 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// タグ名から取引に付けるタグを作る（前後の空白を除き、同じ名前は1つにまとめる）
+//
+// 未登録のタグは ID が 0 のまま返し、保存時に作成する。
+func tagsFromNames(names []string) []Tag {
+	tags := make([]Tag, 0, len(names))
+	seen := make(map[string]bool)
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		tags = append(tags, Tag{Name: name})
+	}
+	return tags
+}
+
+// 取引のタグを置き換える（未登録のタグは作成する）
+func replaceTransactionTags(tx *gorm.DB, transaction *Transaction) error {
+	if err := tx.Where("transaction_id = ?", transaction.ID).Delete(&TransactionTag{}).Error; err != nil {
+		return err
+	}
+
+	for i := range transaction.Tags {
+		tag := &transaction.Tags[i]
+		if tag.ID == 0 {
+			err := tx.Where("user_id = ? AND name = ?", transaction.UserID, tag.Name).First(tag).Error
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				*tag = Tag{UserID: transaction.UserID, Name: tag.Name}
+				err = tx.Create(tag).Error
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if err := tx.Create(&TransactionTag{TransactionID: transaction.ID, TagID: tag.ID}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// タグ一覧（付いている取引の件数つき）
+func getTags(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var tags []Tag
+	if err := db.Where("user_id = ?", userID).Order("name ASC").Find(&tags).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tags: " + err.Error()})
+		return
+	}
+
+	var counts []struct {
+		TagID uint
+		Count int64
+	}
+	if err := db.Model(&TransactionTag{}).Select("tag_id, COUNT(*) as count").
+		Where("tag_id IN (?)", db.Model(&Tag{}).Select("id").Where("user_id = ?", userID)).
+		Group("tag_id").Scan(&counts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tags: " + err.Error()})
+		return
+	}
+	countByTag := make(map[uint]int64, len(counts))
+	for _, row := range counts {
+		countByTag[row.TagID] = row.Count
+	}
+	for i := range tags {
+		tags[i].TransactionCount = countByTag[tags[i].ID]
+	}
+
+	c.JSON(http.StatusOK, tags)
+}
+
+// 同じ名前のタグが既にあるか（excludeID のタグは除く）
+func tagNameTaken(userID interface{}, name string, excludeID uint) bool {
+	var count int64
+	db.Model(&Tag{}).Where("user_id = ? AND name = ? AND id <> ?", userID, name, excludeID).Count(&count)
+	return count > 0
+}
+
+func createTag(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var req TagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+	if tagNameTaken(userID, name, 0) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Tag already exists"})
+		return
+	}
+
+	tag := Tag{UserID: userID.(uint), Name: name, Color: req.Color}
+	if err := db.Create(&tag).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create tag: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusCreated, tag)
+}
+
+// タグ名・色の変更（同じ名前のタグがある場合は統合を使う）
+func updateTag(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var tag Tag
+	if err := db.Where("user_id = ?", userID).First(&tag, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tag not found"})
+		return
+	}
+
+	var req TagRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+	if tagNameTaken(userID, name, tag.ID) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Another tag already has this name; merge the tags instead"})
+		return
+	}
+
+	oldName := tag.Name
+	tag.Name, tag.Color = name, req.Color
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&tag).Error; err != nil {
+			return err
+		}
+		if oldName == name {
+			return nil
+		}
+		if err := renameRuleTags(tx, tag.UserID, oldName, name); err != nil {
+			return err
+		}
+		// タグ名は検索の対象なので索引を作り直す
+		_, err := indexTransactions(tx, tx.Model(&TransactionTag{}).Select("transaction_id").Where("tag_id = ?", tag.ID))
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update tag: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, tag)
+}
+
+// タグの統合（このタグの付いた取引に targetId のタグを付け、このタグは削除する）
+func mergeTag(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var source Tag
+	if err := db.Where("user_id = ?", userID).First(&source, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tag not found"})
+		return
+	}
+
+	var req TagMergeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+	if req.TargetID == source.ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "targetId must differ from the merged tag"})
+		return
+	}
+	var target Tag
+	if err := db.Where("user_id = ?", userID).First(&target, req.TargetID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Target tag not found"})
+		return
+	}
+
+	var moved int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var tagged []uint
+		if err := tx.Model(&TransactionTag{}).Where("tag_id = ?", source.ID).Pluck("transaction_id", &tagged).Error; err != nil {
+			return err
+		}
+		result := tx.Exec(`INSERT INTO transaction_tags (transaction_id, tag_id)
+			SELECT transaction_id, ? FROM transaction_tags
+			WHERE tag_id = ? AND transaction_id NOT IN (SELECT transaction_id FROM transaction_tags WHERE tag_id = ?)`,
+			target.ID, source.ID, target.ID)
+		if result.Error != nil {
+			return result.Error
+		}
+		moved = result.RowsAffected
+		if err := tx.Where("tag_id = ?", source.ID).Delete(&TransactionTag{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&source).Error; err != nil {
+			return err
+		}
+		if err := renameRuleTags(tx, source.UserID, source.Name, target.Name); err != nil {
+			return err
+		}
+		_, err := indexTransactions(tx, tagged)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to merge tags: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tag": target, "tagged": moved})
+}
+
+// タグの削除（取引からも外す。取引は削除しない）
+func deleteTag(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var tag Tag
+	if err := db.Where("user_id = ?", userID).First(&tag, c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tag not found"})
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var tagged []uint
+		if err := tx.Model(&TransactionTag{}).Where("tag_id = ?", tag.ID).Pluck("transaction_id", &tagged).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("tag_id = ?", tag.ID).Delete(&TransactionTag{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&tag).Error; err != nil {
+			return err
+		}
+		if err := renameRuleTags(tx, tag.UserID, tag.Name, ""); err != nil {
+			return err
+		}
+		_, err := indexTransactions(tx, tagged)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete tag: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Tag deleted successfully"})
+}
+
+// 取引一覧のタグでの絞り込み
+//
+// tagId（カンマ区切りで複数指定した場合はいずれかが付いているもの）または tag（タグ名）。
+func filterTransactionsByTag(query *gorm.DB, c *gin.Context) *gorm.DB {
+	if value := c.Query("tagId"); value != "" {
+		var tagIDs []uint
+		for _, part := range strings.Split(value, ",") {
+			if id, err := strconv.ParseUint(strings.TrimSpace(part), 10, 64); err == nil {
+				tagIDs = append(tagIDs, uint(id))
+			}
+		}
+		query = query.Where("id IN (?)", db.Model(&TransactionTag{}).Select("transaction_id").Where("tag_id IN ?", tagIDs))
+	}
+	if name := c.Query("tag"); name != "" {
+		query = query.Where("id IN (?)", db.Table("transaction_tags").Select("transaction_tags.transaction_id").
+			Joins("JOIN tags ON tags.id = transaction_tags.tag_id").Where("tags.name = ?", name))
+	}
+	return query
+}
+
+// タグ別集計（type, startDate, endDate）
+//
+// 複数のタグが付いた取引は、それぞれのタグに全額を計上する。
+func getTagSummary(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	transactionType := c.DefaultQuery("type", "expense")
+	startDate := c.Query("startDate")
+	endDate := c.Query("endDate")
+
+	var tags []Tag
+	if err := db.Where("user_id = ?", userID).Find(&tags).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tags: " + err.Error()})
+		return
+	}
+
+	query := db.Table("transactions").Joins("JOIN transaction_tags ON transaction_tags.transaction_id = transactions.id").
+		Where("transactions.user_id = ? AND transactions.type = ?", userID, transactionType)
+	if startDate != "" && endDate != "" {
+		rangeStart, rangeEnd, err := parseDateRange(startDate, endDate)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		query = query.Where("transactions.date >= ? AND transactions.date < ?", rangeStart, rangeEnd)
+	}
+
+	// 外貨の取引は取引日のレートで基準通貨に換算する
+	converter, err := newCurrencyConverter(userID)
+	if err != nil {
+		respondAggregationError(c, err)
+		return
+	}
+	totals, counts, err := converter.totalsBy(query, "transaction_tags.tag_id")
+	if err != nil {
+		respondAggregationError(c, err)
+		return
+	}
+
+	summaries := make([]TagSummary, 0, len(tags))
+	for _, tag := range tags {
+		summaries = append(summaries, TagSummary{
+			TagID:       tag.ID,
+			TagName:     tag.Name,
+			TagColor:    tag.Color,
+			Type:        transactionType,
+			TotalAmount: totals[tag.ID],
+			Count:       counts[tag.ID],
+		})
+	}
+
+	// 金額の大きい順（同額はID順）
+	sort.SliceStable(summaries, func(i, j int) bool {
+		if summaries[i].TotalAmount != summaries[j].TotalAmount {
+			return summaries[i].TotalAmount > summaries[j].TotalAmount
+		}
+		return summaries[i].TagID < summaries[j].TagID
+	})
+
+	c.JSON(http.StatusOK, summaries)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// パーソナルアクセストークンの接頭辞（JWTと区別し、漏洩時に検出しやすくする）
+const personalAccessTokenPrefix = "mt_pat_"
+
+// トークンのスコープ
+const (
+	scopeRead              = "read"
+	scopeTransactionsWrite = "transactions:write"
+	scopeBudgetsWrite      = "budgets:write"
+)
+
+var errInvalidAccessToken = errors.New("invalid access token")
+
+// スコープの一覧（DBにはスペース区切りで保存し、JSONでは配列として扱う）
+type TokenScopes []string
+
+func (s TokenScopes) Has(scope string) bool {
+	for _, value := range s {
+		if value == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (s TokenScopes) Value() (driver.Value, error) {
+	return strings.Join(s, " "), nil
+}
+
+func (s *TokenScopes) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*s = nil
+	case []byte:
+		*s = strings.Fields(string(v))
+	case string:
+		*s = strings.Fields(v)
+	default:
+		return fmt.Errorf("cannot scan %T into TokenScopes", value)
+	}
+	return nil
+}
+
+// アクセストークンを検証する（期限切れ・削除済みは無効）
+func authenticatePersonalAccessToken(tokenString string) (PersonalAccessToken, error) {
+	var token PersonalAccessToken
+	if err := db.Where("token_hash = ?", hashToken(tokenString)).First(&token).Error; err != nil {
+		return token, errInvalidAccessToken
+	}
+
+	now := time.Now()
+	if token.ExpiresAt != nil && now.After(*token.ExpiresAt) {
+		return token, errInvalidAccessToken
+	}
+
+	// 最終使用日時は1分単位で十分なので、毎回は更新しない
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > time.Minute {
+		if err := db.Model(&token).UpdateColumn("last_used_at", now).Error; err != nil {
+			log.Printf("[AUTH] ERROR: Failed to update last used time of token %d: %v", token.ID, err)
+		}
+	}
+	return token, nil
+}
+
+// トークンに指定のスコープが必要なルート（ログインセッションはすべて許可）
+func requireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, ok := c.Get("tokenScopes")
+		if !ok {
+			c.Next()
+			return
+		}
+
+		if scopes, _ := value.(TokenScopes); !scopes.Has(scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "このトークンには " + scope + " の権限がありません"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// ログインセッションでのみ使えるルート（アカウント設定・トークン管理など）
+func requireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := c.Get("sessionID"); !ok {
+			c.JSON(http.StatusForbidden, gin.H{"error": "この操作はアクセストークンでは実行できません"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// アクセストークン一覧取得
+func getPersonalAccessTokens(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	var tokens []PersonalAccessToken
+
+	if err := db.Where("user_id = ?", userID).Order("created_at DESC").Find(&tokens).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tokens: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// アクセストークン作成（平文のトークンはこの応答でのみ返す）
+func createPersonalAccessToken(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	var req PersonalAccessTokenRequest
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+
+	secret, err := newRandomToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	plain := personalAccessTokenPrefix + secret
+
+	// スコープの重複を除く
+	var scopes TokenScopes
+	for _, scope := range req.Scopes {
+		if !scopes.Has(scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	token := PersonalAccessToken{
+		UserID:      userID.(uint),
+		Name:        req.Name,
+		TokenPrefix: plain[:len(personalAccessTokenPrefix)+4],
+		TokenHash:   hashToken(plain),
+		Scopes:      scopes,
+	}
+	if req.ExpiresInDays > 0 {
+		expiresAt := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		token.ExpiresAt = &expiresAt
+	}
+
+	if err := db.Create(&token).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"token":       plain,
+		"accessToken": token,
+	})
+}
+
+// アクセストークン削除（以後このトークンは使えない）
+func deletePersonalAccessToken(c *gin.Context) {
+	userID, _ := c.Get("userID")
+	id := c.Param("id")
+
+	result := db.Where("user_id = ?", userID).Delete(&PersonalAccessToken{}, id)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete token: " + result.Error.Error()})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Token deleted successfully"})
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+// TOTP（RFC 6238）の設定。認証アプリの既定値に合わせる
+const (
+	totpPeriod = 30 // 秒
+	totpDigits = 6
+	totpSkew   = 1 // 前後に許容する時間ステップ数
+	totpIssuer = "MoneyTracker"
+
+	recoveryCodeCount = 10
+)
+
+// ログイン2段階目のチャレンジトークンの有効期間
+var twoFactorChallengeTTL = getEnvDuration("TWO_FACTOR_CHALLENGE_TTL", 5*time.Minute)
+
+const challengePurpose = "2fa_challenge"
+
+var errInvalidChallenge = errors.New("invalid challenge token")
+
+// チャレンジトークンのクレーム（セッションを持たないため authMiddleware では拒否される）
+type challengeClaims struct {
+	UserID  uint   `json:"user_id"`
+	Purpose string `json:"purpose"`
+	jwt.RegisteredClaims
+}
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTPシークレットを生成（160ビット、Base32）
+func newTOTPSecret() (string, error) {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base32NoPadding.EncodeToString(buf), nil
+}
+
+// 時間ステップに対応するコード（RFC 4226 の HOTP）
+func totpCode(secret string, step int64) (string, error) {
+	key, err := base32NoPadding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod), nil
+}
+
+// コードを検証し、一致した時間ステップを返す
+func verifyTOTP(secret, code string, now time.Time) (int64, bool) {
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		expected, err := totpCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if hmac.Equal([]byte(expected), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// 認証アプリに登録するための otpauth URI
+func totpURI(secret, email string) string {
+	values := url.Values{}
+	values.Set("secret", secret)
+	values.Set("issuer", totpIssuer)
+	values.Set("algorithm", "SHA1")
+	values.Set("digits", fmt.Sprint(totpDigits))
+	values.Set("period", fmt.Sprint(totpPeriod))
+	label := url.PathEscape(totpIssuer + ":" + email)
+	return "otpauth://totp/" + label + "?" + values.Encode()
+}
+
+// 入力されたリカバリーコードを正規化（区切り・大文字小文字を無視）
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// リカバリーコードを発行する（既存のコードは無効になる）
+func generateRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		buf := make([]byte, 7)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(base32NoPadding.EncodeToString(buf))[:10]
+		code := raw[:5] + "-" + raw[5:]
+
+		if err := tx.Create(&RecoveryCode{UserID: userID, CodeHash: hashToken(raw)}).Error; err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// 認証コードまたはリカバリーコードを検証する（どちらも一度しか使えない）
+func verifySecondFactor(tx *gorm.DB, user User, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	now := time.Now()
+
+	if step, ok := verifyTOTP(user.TOTPSecret, code, now); ok {
+		// 同じコードの再利用を防ぐ
+		result := tx.Model(&User{}).Where("id = ? AND totp_last_step < ?", user.ID, step).Update("totp_last_step", step)
+		return result.RowsAffected > 0, result.Error
+	}
+
+	normalized := normalizeRecoveryCode(code)
+	if len(normalized) != 10 {
+		return false, nil
+	}
+	result := tx.Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, hashToken(normalized)).
+		Update("used_at", now)
+	return result.RowsAffected > 0, result.Error
+}
+
+// ログイン2段階目用のチャレンジトークンを発行
+func generateChallengeToken(userID uint) (string, error) {
+	claims := &challengeClaims{
+		UserID:  userID,
+		Purpose: challengePurpose,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(twoFactorChallengeTTL)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+	return jwtKeys.sign(claims)
+}
+
+// チャレンジトークンを検証してユーザーIDを返す
+func parseChallengeToken(tokenString string) (uint, error) {
+	claims := &challengeClaims{}
+	token, err := jwtKeys.parse(tokenString, claims)
+	if err != nil || !token.Valid || claims.Purpose != challengePurpose {
+		return 0, errInvalidChallenge
+	}
+	return claims.UserID, nil
+}
+
+// 2段階認証の登録開始（シークレットを発行し、確認されるまでは無効のまま）
+func setupTwoFactor(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var user User
+	if err := db.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "ユーザーが見つかりません"})
+		return
+	}
+	if user.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "2段階認証は既に有効です"})
+		return
+	}
+
+	secret, err := newTOTPSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "シークレットの生成に失敗しました"})
+		return
+	}
+	if err := db.Model(&user).Updates(map[string]interface{}{"totp_secret": secret, "totp_last_step": 0}).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "2段階認証の設定に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":     secret,
+		"otpauthUri": totpURI(secret, user.Email),
+	})
+}
+
+// 2段階認証の有効化（認証アプリのコードで確認し、リカバリーコードを返す）
+func confirmTwoFactor(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var user User
+	if err := db.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "ユーザーが見つかりません"})
+		return
+	}
+	if user.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "2段階認証は既に有効です"})
+		return
+	}
+	if user.TOTPSecret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "先に2段階認証の設定を開始してください"})
+		return
+	}
+
+	step, ok := verifyTOTP(user.TOTPSecret, strings.TrimSpace(req.Code), time.Now())
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "認証コードが正しくありません"})
+		return
+	}
+
+	var codes []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]interface{}{"totp_enabled": true, "totp_last_step": step}).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = generateRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "2段階認証の有効化に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "2段階認証を有効にしました",
+		"recoveryCodes": codes,
+	})
+}
+
+// パスワードと2段階目のコードで再認証する
+func reauthenticateTwoFactor(c *gin.Context) (User, bool) {
+	userID, _ := c.Get("userID")
+
+	var req TwoFactorReauthRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return User{}, false
+	}
+
+	var user User
+	if err := db.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "ユーザーが見つかりません"})
+		return user, false
+	}
+	if !user.TOTPEnabled {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "2段階認証は有効になっていません"})
+		return user, false
+	}
+	if !checkPasswordHash(req.Password, user.Password) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "パスワードが間違っています"})
+		return user, false
+	}
+
+	ok, err := verifySecondFactor(db, user, req.Code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "認証コードの確認に失敗しました"})
+		return user, false
+	}
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "認証コードが正しくありません"})
+		return user, false
+	}
+	return user, true
+}
+
+// 2段階認証の無効化
+func disableTwoFactor(c *gin.Context) {
+	user, ok := reauthenticateTwoFactor(c)
+	if !ok {
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]interface{}{"totp_enabled": false, "totp_secret": "", "totp_last_step": 0}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", user.ID).Delete(&RecoveryCode{}).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "2段階認証の無効化に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "2段階認証を無効にしました"})
+}
+
+// リカバリーコードの再発行
+func regenerateRecoveryCodes(c *gin.Context) {
+	user, ok := reauthenticateTwoFactor(c)
+	if !ok {
+		return
+	}
+
+	codes, err := generateRecoveryCodes(db, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "リカバリーコードの発行に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
+}
+
+// ログイン2段階目（チャレンジトークンと認証コードを検証してトークンを発行）
+func loginTwoFactor(c *gin.Context) {
+	var req TwoFactorLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, err := parseChallengeToken(req.ChallengeToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "チャレンジトークンが無効か、有効期限が切れています"})
+		return
+	}
+
+	var user User
+	if err := db.First(&user, userID).Error; err != nil || !user.TOTPEnabled {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "チャレンジトークンが無効か、有効期限が切れています"})
+		return
+	}
+
+	// 認証コードの総当たりもログインと同じ回数制限を受ける
+	ip := c.ClientIP()
+	if err := checkLoginThrottle(user.Email, ip); err != nil {
+		if respondLockedOut(c, err) {
+			auditLoginFailure(c, user.Email, &user.ID, "locked_out")
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "ログインに失敗しました"})
+		return
+	}
+
+	ok, err := verifySecondFactor(db, user, req.Code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "認証コードの確認に失敗しました"})
+		return
+	}
+	if !ok {
+		recordLoginFailure(user.Email, ip)
+		auditLoginFailure(c, user.Email, &user.ID, "bad_2fa_code")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "認証コードが正しくありません"})
+		return
+	}
+	resetLoginFailures(user.Email)
+
+	response, err := issueTokens(c, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "トークンの生成に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// RFC 6238 付録Bのシークレット（ASCIIの "12345678901234567890"）
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// RFC 6238 付録Bの SHA1 の値（8桁）の下6桁
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+		{unix: 20000000000, want: "353130"},
+	}
+	for _, tt := range tests {
+		got, err := totpCode(rfc6238Secret, tt.unix/totpPeriod)
+		if err != nil {
+			t.Fatalf("totpCode returned error: %v", err)
+		}
+		if got != tt.want {
+			t.Errorf("totpCode at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+
+	if got, err := totpCode("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", 1); err != nil || got != "287082" {
+		t.Errorf("totpCode with a lower case secret = %s, %v, want 287082", got, err)
+	}
+	if _, err := totpCode("not base32!", 1); err == nil {
+		t.Error("totpCode accepted an invalid secret")
+	}
+}
+
+func TestVerifyTOTPWindow(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / totpPeriod
+
+	tests := []struct {
+		offset int64
+		ok     bool
+	}{
+		{offset: -2, ok: false},
+		{offset: -1, ok: true},
+		{offset: 0, ok: true},
+		{offset: 1, ok: true},
+		{offset: 2, ok: false},
+	}
+	for _, tt := range tests {
+		code, err := totpCode(rfc6238Secret, current+tt.offset)
+		if err != nil {
+			t.Fatalf("totpCode returned error: %v", err)
+		}
+		step, ok := verifyTOTP(rfc6238Secret, code, now)
+		if ok != tt.ok {
+			t.Errorf("verifyTOTP with step offset %d = %v, want %v", tt.offset, ok, tt.ok)
+			continue
+		}
+		if ok && step != current+tt.offset {
+			t.Errorf("verifyTOTP with step offset %d returned step %d, want %d", tt.offset, step, current+tt.offset)
+		}
+	}
+
+	for _, code := range []string{"", "05047", "0504711", "abcdef"} {
+		if _, ok := verifyTOTP(rfc6238Secret, code, now); ok {
+			t.Errorf("verifyTOTP accepted %q", code)
+		}
+	}
+}
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	tests := map[string]string{
+		"abcde-fghij":   "abcdefghij",
+		"ABCDE FGHIJ":   "abcdefghij",
+		"abcdefghij":    "abcdefghij",
+		"Ab-Cd Ef-GhIj": "abcdefghij",
+	}
+	for code, want := range tests {
+		if got := normalizeRecoveryCode(code); got != want {
+			t.Errorf("normalizeRecoveryCode(%q) = %q, want %q", code, got, want)
+		}
+	}
+}
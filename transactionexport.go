@@ -0,0 +1,286 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/transform"
+)
+
+// 取引エクスポートの1行（カテゴリ・口座は名前で出力する）
+type transactionExportRow struct {
+	ID            uint      `json:"id"`
+	Date          time.Time `json:"-"`
+	Type          string    `json:"type"`
+	Amount        Money     `json:"amount"`
+	Currency      string    `json:"currency"`
+	AccountName   string    `json:"account"`
+	ToAccountName string    `json:"toAccount,omitempty"`
+	ToAmount      Money     `json:"toAmount,omitempty"`
+	CategoryName  string    `json:"category,omitempty"`
+	Description   string    `json:"description"`
+}
+
+// CSV・Excelの列
+var transactionExportHeader = []string{"id", "date", "type", "amount", "currency", "account", "to_account", "to_amount", "category", "description"}
+
+func (r transactionExportRow) fields() []string {
+	toAmount := ""
+	if r.ToAccountName != "" {
+		toAmount = r.ToAmount.String()
+	}
+	return []string{
+		formatID(r.ID), formatDate(r.Date), r.Type, r.Amount.String(), r.Currency,
+		r.AccountName, r.ToAccountName, toAmount, r.CategoryName, r.Description,
+	}
+}
+
+// 形式ごとの書き出し
+type transactionExportWriter interface {
+	WriteRow(row transactionExportRow) error
+	Close() error
+}
+
+// 取引エクスポート（format=csv, xlsx, json）
+//
+// 取引一覧と同じ条件で絞り込み、全件を1行ずつ読みながら書き出す。
+// CSVは encoding=shift_jis で Shift_JIS（日本語版Excel向け）、既定はBOM付きUTF-8。
+func exportTransactions(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "xlsx" && format != "json" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv, xlsx or json"})
+		return
+	}
+	csvEncoding := c.DefaultQuery("encoding", "utf-8")
+	if csvEncoding != "utf-8" && csvEncoding != "shift_jis" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "encoding must be utf-8 or shift_jis"})
+		return
+	}
+
+	filtered := filterTransactions(db.Model(&Transaction{}).Select("id").Where("user_id = ?", userID), c)
+	if filtered.Error != nil {
+		respondFilterError(c, "Failed to export transactions", filtered.Error)
+		return
+	}
+	rows, err := db.Table("transactions AS t").
+		Select("t.id, t.date, t.type, t.amount, t.currency, a.name AS account_name, ta.name AS to_account_name, t.to_amount, c.name AS category_name, t.description").
+		Joins("LEFT JOIN accounts a ON a.id = t.account_id").
+		Joins("LEFT JOIN accounts ta ON ta.id = t.to_account_id").
+		Joins("LEFT JOIN categories c ON c.id = t.category_id").
+		Where("t.id IN (?)", filtered).
+		Order("t.date DESC, t.created_at DESC").
+		Rows()
+	if err != nil {
+		respondFilterError(c, "Failed to export transactions", err)
+		return
+	}
+	defer rows.Close()
+
+	fileName := "transactions-" + time.Now().Format("20060102") + "." + format
+	c.Header("Content-Disposition", `attachment; filename="`+fileName+`"`)
+
+	var w transactionExportWriter
+	switch format {
+	case "csv":
+		if csvEncoding == "shift_jis" {
+			c.Header("Content-Type", "text/csv; charset=Shift_JIS")
+		} else {
+			c.Header("Content-Type", "text/csv; charset=utf-8")
+		}
+		c.Status(http.StatusOK)
+		w, err = newTransactionCSVWriter(c.Writer, csvEncoding)
+	case "xlsx":
+		c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		c.Status(http.StatusOK)
+		w, err = newTransactionXLSXWriter(c.Writer)
+	case "json":
+		c.Header("Content-Type", "application/json; charset=utf-8")
+		c.Status(http.StatusOK)
+		w, err = newTransactionJSONWriter(c.Writer)
+	}
+
+	// ヘッダー送信後のエラーはログのみ
+	for err == nil && rows.Next() {
+		var row transactionExportRow
+		if err = db.ScanRows(rows, &row); err == nil {
+			err = w.WriteRow(row)
+		}
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		log.Printf("[EXPORT] ERROR: Failed to write transaction export for user %d: %v", userID, err)
+	}
+}
+
+// CSV
+type transactionCSVWriter struct {
+	w     *csv.Writer
+	close func() error
+}
+
+func newTransactionCSVWriter(w io.Writer, csvEncoding string) (*transactionCSVWriter, error) {
+	out := &transactionCSVWriter{close: func() error { return nil }}
+	if csvEncoding == "shift_jis" {
+		// Shift_JISにない文字（絵文字など）は ? に置き換える
+		encoder := encoding.ReplaceUnsupported(japanese.ShiftJIS.NewEncoder())
+		sjis := transform.NewWriter(w, encoder)
+		w = sjis
+		out.close = sjis.Close
+	} else if _, err := w.Write([]byte("\xEF\xBB\xBF")); err != nil {
+		return nil, err
+	}
+
+	out.w = csv.NewWriter(w)
+	// 日本語版Excelで開けるよう改行はCRLFにする
+	out.w.UseCRLF = true
+	return out, out.w.Write(transactionExportHeader)
+}
+
+func (t *transactionCSVWriter) WriteRow(row transactionExportRow) error {
+	return t.w.Write(row.fields())
+}
+
+func (t *transactionCSVWriter) Close() error {
+	t.w.Flush()
+	if err := t.w.Error(); err != nil {
+		return err
+	}
+	return t.close()
+}
+
+// JSON（配列を1件ずつ書き出す）
+type transactionJSONWriter struct {
+	w     io.Writer
+	count int
+}
+
+func newTransactionJSONWriter(w io.Writer) (*transactionJSONWriter, error) {
+	_, err := io.WriteString(w, "[")
+	return &transactionJSONWriter{w: w}, err
+}
+
+func (t *transactionJSONWriter) WriteRow(row transactionExportRow) error {
+	if t.count > 0 {
+		if _, err := io.WriteString(t.w, ","); err != nil {
+			return err
+		}
+	}
+	t.count++
+
+	// 日付は時刻なしで出力する
+	data, err := json.Marshal(struct {
+		transactionExportRow
+		Date string `json:"date"`
+	}{row, formatDate(row.Date)})
+	if err != nil {
+		return err
+	}
+	_, err = t.w.Write(data)
+	return err
+}
+
+func (t *transactionJSONWriter) Close() error {
+	_, err := io.WriteString(t.w, "]\n")
+	return err
+}
+
+// Excel（xlsx）
+//
+// シート1枚だけの最小限の構成を、行を追記しながらZIPに書き出す。
+type transactionXLSXWriter struct {
+	zw    *zip.Writer
+	sheet io.Writer
+	row   int
+}
+
+var xlsxStaticParts = []struct{ name, body string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+	{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="transactions" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`},
+}
+
+func newTransactionXLSXWriter(w io.Writer) (*transactionXLSXWriter, error) {
+	zw := zip.NewWriter(w)
+	for _, part := range xlsxStaticParts {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, part.body); err != nil {
+			return nil, err
+		}
+	}
+
+	sheet, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(sheet, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`); err != nil {
+		return nil, err
+	}
+
+	t := &transactionXLSXWriter{zw: zw, sheet: sheet}
+	return t, t.writeCells(transactionExportHeader, nil)
+}
+
+// 金額の列（数値として出力する）
+var xlsxNumericColumns = map[int]bool{3: true, 7: true}
+
+func (t *transactionXLSXWriter) WriteRow(row transactionExportRow) error {
+	return t.writeCells(row.fields(), xlsxNumericColumns)
+}
+
+func (t *transactionXLSXWriter) writeCells(values []string, numeric map[int]bool) error {
+	t.row++
+	var b strings.Builder
+	fmt.Fprintf(&b, `<row r="%d">`, t.row)
+	for i, value := range values {
+		if value == "" {
+			continue
+		}
+		ref := fmt.Sprintf("%c%d", 'A'+i, t.row)
+		if numeric[i] {
+			fmt.Fprintf(&b, `<c r="%s"><v>%s</v></c>`, ref, value)
+			continue
+		}
+		fmt.Fprintf(&b, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">`, ref)
+		if err := xml.EscapeText(&b, []byte(value)); err != nil {
+			return err
+		}
+		b.WriteString(`</t></is></c>`)
+	}
+	b.WriteString(`</row>`)
+	_, err := io.WriteString(t.sheet, b.String())
+	return err
+}
+
+func (t *transactionXLSXWriter) Close() error {
+	if _, err := io.WriteString(t.sheet, `</sheetData></worksheet>`); err != nil {
+		return err
+	}
+	return t.zw.Close()
+}
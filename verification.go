@@ -0,0 +1,281 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// メール用トークンの用途
+const (
+	purposePasswordReset     = "password_reset"
+	purposeEmailVerification = "email_verification"
+	purposeEmailChange       = "email_change"
+)
+
+// メール用トークンの有効期間とリンク先
+var (
+	passwordResetTTL     = getEnvDuration("PASSWORD_RESET_TTL", time.Hour)
+	emailVerificationTTL = getEnvDuration("EMAIL_VERIFICATION_TTL", 48*time.Hour)
+	appURL               = getEnv("APP_URL", "http://localhost:3000")
+)
+
+var (
+	errInvalidUserToken = errors.New("invalid or expired token")
+	errEmailTaken       = errors.New("email already registered")
+)
+
+// 使い捨てトークンを発行する（同じ用途の未使用トークンは無効にする）
+func createUserToken(tx *gorm.DB, userID uint, purpose string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	if err := tx.Model(&UserToken{}).Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+		Update("used_at", now).Error; err != nil {
+		return "", err
+	}
+
+	token, err := newRandomToken()
+	if err != nil {
+		return "", err
+	}
+
+	record := UserToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		TokenHash: hashToken(token),
+		ExpiresAt: now.Add(ttl),
+	}
+	if err := tx.Create(&record).Error; err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// トークンを検証して使用済みにする
+func consumeUserToken(tx *gorm.DB, token, purpose string) (UserToken, error) {
+	var record UserToken
+	if err := tx.Where("token_hash = ? AND purpose = ?", hashToken(token), purpose).First(&record).Error; err != nil {
+		return record, errInvalidUserToken
+	}
+
+	now := time.Now()
+	if record.UsedAt != nil || now.After(record.ExpiresAt) {
+		return record, errInvalidUserToken
+	}
+
+	// 同時に使われた場合も片方だけが成功する
+	result := tx.Model(&UserToken{}).Where("id = ? AND used_at IS NULL", record.ID).Update("used_at", now)
+	if result.Error != nil {
+		return record, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return record, errInvalidUserToken
+	}
+	return record, nil
+}
+
+// フロントエンドのページへのリンク
+func appLink(path, token string) string {
+	return appURL + path + "?token=" + url.QueryEscape(token)
+}
+
+// メールアドレス確認メールを送信
+func sendVerificationEmail(user User) error {
+	token, err := createUserToken(db, user.ID, purposeEmailVerification, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	return mailer.Send(Mail{
+		To:      user.Email,
+		Subject: "【MoneyTracker】メールアドレスの確認",
+		Body: user.Name + " 様\n\n" +
+			"MoneyTrackerへのご登録ありがとうございます。\n" +
+			"以下のリンクからメールアドレスを確認してください。\n\n" +
+			appLink("/verify-email", token) + "\n\n" +
+			"このリンクの有効期限は " + emailVerificationTTL.String() + " です。\n" +
+			"心当たりがない場合は、このメールを破棄してください。\n",
+	})
+}
+
+// パスワード再設定メール送信
+func forgotPassword(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// メールアドレス・IPごとに送信回数を制限する（制限の保存先に問題があっても受け付ける）
+	if err := checkPasswordResetLimit(req.Email, c.ClientIP()); err != nil {
+		if respondTooManyRequests(c, err, "リクエストが多すぎます。しばらくしてから再度お試しください") {
+			return
+		}
+		log.Printf("[AUTH] ERROR: Failed to check password reset limit: %v", err)
+	}
+
+	// 登録の有無が応答の内容・時間で分からないよう、送信は応答後に行う（失敗はログのみ）
+	go sendPasswordResetEmail(req.Email)
+
+	c.JSON(http.StatusOK, gin.H{"message": "パスワード再設定用のメールを送信しました"})
+}
+
+// 登録済みのメールアドレスであればパスワード再設定メールを送信
+func sendPasswordResetEmail(email string) {
+	var user User
+	if err := db.Where("LOWER(email) = LOWER(?)", email).First(&user).Error; err != nil {
+		return
+	}
+
+	token, err := createUserToken(db, user.ID, purposePasswordReset, passwordResetTTL)
+	if err != nil {
+		log.Printf("[AUTH] ERROR: Failed to create password reset token for user %d: %v", user.ID, err)
+		return
+	}
+
+	err = mailer.Send(Mail{
+		To:      user.Email,
+		Subject: "【MoneyTracker】パスワードの再設定",
+		Body: user.Name + " 様\n\n" +
+			"パスワード再設定のリクエストを受け付けました。\n" +
+			"以下のリンクから新しいパスワードを設定してください。\n\n" +
+			appLink("/reset-password", token) + "\n\n" +
+			"このリンクの有効期限は " + passwordResetTTL.String() + " で、1回のみ使用できます。\n" +
+			"心当たりがない場合は、このメールを破棄してください。\n",
+	})
+	if err != nil {
+		log.Printf("[MAIL] ERROR: Failed to send password reset email to user %d: %v", user.ID, err)
+	}
+}
+
+// パスワード再設定
+func resetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	hashedPassword, err := hashPassword(req.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "パスワードの処理に失敗しました"})
+		return
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		record, err := consumeUserToken(tx, req.Token, purposePasswordReset)
+		if err != nil {
+			return err
+		}
+
+		// メールを受け取れたのでメールアドレスも確認済みとみなす
+		if err := tx.Model(&User{}).Where("id = ?", record.UserID).
+			Updates(map[string]interface{}{"password": hashedPassword, "email_verified": true}).Error; err != nil {
+			return err
+		}
+
+		// 既存のログインはすべて無効にする
+		return revokeUserSessions(tx, record.UserID, "password_reset")
+	})
+	if errors.Is(err, errInvalidUserToken) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "リンクが無効か、有効期限が切れています"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "パスワードの再設定に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "パスワードを再設定しました"})
+}
+
+// メールアドレス確認
+func verifyEmail(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "トークンが必要です"})
+		return
+	}
+
+	// メールアドレス変更の確認リンクも同じページで受け付ける
+	var changed *User
+	err := db.Transaction(func(tx *gorm.DB) error {
+		record, err := consumeUserToken(tx, token, purposeEmailVerification)
+		if errors.Is(err, errInvalidUserToken) {
+			if record, err = consumeUserToken(tx, token, purposeEmailChange); err != nil {
+				return err
+			}
+			user, err := applyEmailChange(tx, record.UserID)
+			if err != nil {
+				return err
+			}
+			changed = &user
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return tx.Model(&User{}).Where("id = ?", record.UserID).Update("email_verified", true).Error
+	})
+	if errors.Is(err, errInvalidUserToken) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "リンクが無効か、有効期限が切れています"})
+		return
+	}
+	if errors.Is(err, errEmailTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": "このメールアドレスは既に登録されています"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "メールアドレスの確認に失敗しました"})
+		return
+	}
+
+	if changed != nil {
+		notifyEmailChanged(*changed, changed.PendingEmail)
+		c.JSON(http.StatusOK, gin.H{"message": "メールアドレスを変更しました"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "メールアドレスを確認しました"})
+}
+
+// 確認メール再送信
+func resendVerificationEmail(c *gin.Context) {
+	userID, _ := c.Get("userID")
+
+	var user User
+	if err := db.First(&user, userID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "ユーザーが見つかりません"})
+		return
+	}
+	if user.EmailVerified {
+		c.JSON(http.StatusOK, gin.H{"message": "メールアドレスは確認済みです"})
+		return
+	}
+
+	if err := sendVerificationEmail(user); err != nil {
+		log.Printf("[MAIL] ERROR: Failed to send verification email to user %d: %v", user.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "メールの送信に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "確認メールを送信しました"})
+}
+
+// メールアドレス確認済みのユーザーのみ許可するミドルウェア（authMiddlewareの後に使う）
+func requireVerifiedEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, _ := c.Get("userID")
+
+		var user User
+		if err := db.Select("id, email_verified").First(&user, userID).Error; err != nil || !user.EmailVerified {
+			c.JSON(http.StatusForbidden, gin.H{"error": "メールアドレスの確認が必要です"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}